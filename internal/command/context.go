@@ -2,6 +2,7 @@ package command
 
 import (
 	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
 	"github.com/iamharvey/regi/internal/pkg/data"
 	"github.com/iamharvey/regi/internal/pkg/io"
 	"github.com/pkg/errors"
@@ -25,6 +26,19 @@ const (
 
   # GetContext context info
   regi context get context1
+
+  # Fix the server of a context
+  regi context update context1 -s=192.168.0.168:5001
+
+  # Rename a context
+  regi context rename context1 staging
+
+  # Export contexts without passwords, and import them on another machine
+  regi context export > contexts.yaml
+  regi context import contexts.yaml
+
+  # Add the registries 'docker login' has logged in to
+  regi context import-docker
 `
 
 	// msgShortCtxListCmd is the short version description for `context list` command.
@@ -41,32 +55,107 @@ const (
 
 	// msgShortCtxDelCmd is the short version description for `context delete` command.
 	msgShortCtxDelCmd = "DeleteContext context given context name."
+
+	// msgShortCtxUpdateCmd is the short version description for `context update` command.
+	msgShortCtxUpdateCmd = "Update the given settings of a context."
+
+	// msgShortCtxRenameCmd is the short version description for `context rename` command.
+	msgShortCtxRenameCmd = "Rename a context."
+
+	// msgShortCtxExportCmd is the short version description for `context export` command.
+	msgShortCtxExportCmd = "Export contexts as a portable bundle, without passwords unless asked to."
+
+	// msgShortCtxImportCmd is the short version description for `context import` command.
+	msgShortCtxImportCmd = "Import contexts from a bundle made by 'context export'."
+
+	// msgShortCtxImportDockerCmd is the short version description for `context import-docker` command.
+	msgShortCtxImportDockerCmd = "Add contexts for the registries of the Docker config file."
+
+	// msgShortCtxMigrateSecretsCmd is the short version description for `context migrate-secrets` command.
+	msgShortCtxMigrateSecretsCmd = "Encrypt the passwords stored in plain text by older versions."
 )
 
+// contextInfo is the output of a context. Password is never printed.
+type contextInfo struct {
+	Name                  string `json:"name" yaml:"name"`
+	Server                string `json:"server" yaml:"server"`
+	InsecureSkipTLSVerify bool   `json:"insecureSkipTLSVerify" yaml:"insecure-skip-tls-verify"`
+	CAFile                string `json:"certificateAuthority,omitempty" yaml:"certificate-authority,omitempty"`
+	CertFile              string `json:"clientCertificate,omitempty" yaml:"client-certificate,omitempty"`
+	KeyFile               string `json:"clientKey,omitempty" yaml:"client-key,omitempty"`
+	User                  string `json:"user,omitempty" yaml:"user,omitempty"`
+	CredentialsStore      string `json:"credentialsStore,omitempty" yaml:"credentials-store,omitempty"`
+	Current               bool   `json:"current" yaml:"current"`
+}
+
+// newContextInfo converts a registry entry to its output.
+func newContextInfo(reg *data.Registry, current *data.Registry) contextInfo {
+	return contextInfo{
+		Name:                  reg.Name,
+		Server:                reg.Server,
+		InsecureSkipTLSVerify: reg.InsecureSkipTLSVerify,
+		CAFile:                reg.CAFile,
+		CertFile:              reg.CertFile,
+		KeyFile:               reg.KeyFile,
+		User:                  reg.User,
+		CredentialsStore:      reg.CredentialsStore,
+		Current:               current != nil && current.Name == reg.Name,
+	}
+}
+
+// Header implements output.Table.
+func (c contextInfo) Header() []string {
+	return contextList{}.Header()
+}
+
+// Rows implements output.Table.
+func (c contextInfo) Rows() [][]string {
+	return contextList{Contexts: []contextInfo{c}}.Rows()
+}
+
+// contextList is the output of `context list`.
+type contextList struct {
+	Contexts []contextInfo `json:"contexts" yaml:"contexts"`
+}
+
+// Header implements output.Table.
+func (l contextList) Header() []string {
+	return []string{"CURRENT", "NAME", "SERVER", "USER", "INSECURE", "CA", "CLIENT-CERT"}
+}
+
+// Rows implements output.Table.
+func (l contextList) Rows() [][]string {
+	var rows [][]string
+	for _, c := range l.Contexts {
+		current := ""
+		if c.Current {
+			current = "*"
+		}
+		rows = append(rows, []string{
+			current, c.Name, c.Server, c.User, fmt.Sprintf("%v", c.InsecureSkipTLSVerify), c.CAFile, c.CertFile,
+		})
+	}
+	return rows
+}
+
 // CmdContextOptions eases access to storage and console io.
 type cmdContextOptions struct {
 	*data.DB
 	io.Streams
 }
 
-// NewCmdContextOptions returns a new Options for context command.
-func NewCmdContextOptions(streams io.Streams) (*cmdContextOptions, error) {
-	db, err := data.NewDB()
-	if err != nil {
-		return nil, err
-	}
+// NewCmdContextOptions returns a new Options for context command on the storage db. Commands pass nil, and
+// open the storage given by the global flags once they are parsed.
+func NewCmdContextOptions(db *data.DB, streams io.Streams) *cmdContextOptions {
 	return &cmdContextOptions{
 		DB:      db,
 		Streams: streams,
-	}, nil
+	}
 }
 
 // NewCmdContext creates a Context command.
 func NewCmdContext(streams io.Streams) *cobra.Command {
-	o, err := NewCmdContextOptions(streams)
-	if err != nil {
-		streams.ErrOut.Write([]byte(err.Error()))
-	}
+	o := NewCmdContextOptions(nil, streams)
 
 	// Context root command.
 	cmd := &cobra.Command{
@@ -75,6 +164,11 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortCtxCmd,
 		Example:               msgExamplesCtxCmd,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			var err error
+			o.DB, err = openDB(cmd)
+			checkErr(err)
+		},
 	}
 
 	// ListContexts all the contexts.
@@ -84,7 +178,7 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortCtxListCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.listCmdRun())
+			checkErr(o.listCmdRun(cmd))
 		},
 	}
 
@@ -94,7 +188,7 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortCtxSetCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.setCmdRun(cmd, args))
+			checkErr(o.setCmdRun(cmd, args))
 		},
 	}
 
@@ -104,7 +198,7 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortCtxAddCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.addCmdRun(cmd))
+			checkErr(o.addCmdRun(cmd))
 		},
 	}
 
@@ -114,7 +208,7 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortCtxGetCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.getCmdRun(cmd, args))
+			checkErr(o.getCmdRun(cmd, args))
 		},
 	}
 
@@ -125,7 +219,67 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortCtxDelCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.deleteCmdRun(cmd, args))
+			checkErr(o.deleteCmdRun(cmd, args))
+		},
+	}
+
+	// Update context.
+	updateCmd := &cobra.Command{
+		Use:                   "update <name>",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortCtxUpdateCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.updateCmdRun(cmd, args))
+		},
+	}
+
+	// Rename context.
+	renameCmd := &cobra.Command{
+		Use:                   "rename <old-name> <new-name>",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortCtxRenameCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.renameCmdRun(cmd, args))
+		},
+	}
+
+	// Export contexts.
+	exportCmd := &cobra.Command{
+		Use:                   "export [name...]",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortCtxExportCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.exportCmdRun(cmd, args))
+		},
+	}
+
+	// Import contexts.
+	importCmd := &cobra.Command{
+		Use:                   "import <file>",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortCtxImportCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.importCmdRun(cmd, args))
+		},
+	}
+
+	// Import contexts from the Docker config file.
+	importDockerCmd := &cobra.Command{
+		Use:                   "import-docker",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortCtxImportDockerCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.importDockerCmdRun(cmd))
+		},
+	}
+
+	// Encrypt passwords stored in plain text.
+	migrateSecretsCmd := &cobra.Command{
+		Use:                   "migrate-secrets",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortCtxMigrateSecretsCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.migrateSecretsCmdRun())
 		},
 	}
 
@@ -135,13 +289,23 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 	cmd.AddCommand(addCmd)
 	cmd.AddCommand(getCmd)
 	cmd.AddCommand(delCmd)
+	cmd.AddCommand(updateCmd)
+	cmd.AddCommand(renameCmd)
+	cmd.AddCommand(exportCmd)
+	cmd.AddCommand(importCmd)
+	cmd.AddCommand(importDockerCmd)
+	cmd.AddCommand(migrateSecretsCmd)
 
 	// Add flags.
 	addCmd.Flags().StringP("name", "n", "", "context name (required)")
-	addCmd.Flags().StringP("server", "s", "", "registry server address (required)")
-	addCmd.Flags().BoolP("verify", "v", false, "insecure skip TLS verify, default is false")
-	addCmd.Flags().StringP("user", "u", "", "registry username")
-	addCmd.Flags().StringP("password", "p", "", "registry password")
+	addContextFlags(addCmd)
+	addContextFlags(updateCmd)
+	addCmd.Flags().Lookup("server").Usage += " (required)"
+	exportCmd.Flags().Bool("with-secrets", false,
+		fmt.Sprintf("include passwords, encrypted with the passphrase given by %s", data.PassphraseEnv))
+	importDockerCmd.Flags().StringP("file", "f", "", "path to the Docker config file, ~/.docker/config.json by default")
+	importCmd.Flags().String("strategy", data.ImportSkip,
+		fmt.Sprintf("what to do with contexts already existing, one of %s, %s or %s", data.ImportMerge, data.ImportOverwrite, data.ImportSkip))
 
 	// SetCurrentContext required options.
 	addCmd.MarkFlagRequired("name")
@@ -150,8 +314,26 @@ func NewCmdContext(streams io.Streams) *cobra.Command {
 	return cmd
 }
 
+// addContextFlags adds the flags of the settings of a context, shared by `add` and `update`.
+func addContextFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("server", "s", "", "registry server address")
+	cmd.Flags().BoolP("verify", "v", false, "insecure skip TLS verify, default is false")
+	cmd.Flags().StringP("user", "u", "", "registry username")
+	cmd.Flags().StringP("password", "p", "", "registry password")
+	cmd.Flags().String("ca-file", "", "path to a PEM bundle of CA certificates trusted for the registry")
+	cmd.Flags().String("cert-file", "", "path to a PEM client certificate for mutual TLS")
+	cmd.Flags().String("key-file", "", "path to the PEM key of the client certificate")
+	cmd.Flags().String("credentials-store", "",
+		fmt.Sprintf("keep credentials in the Docker credential helper with the name, or %q for the Docker config file", credentials.DockerConfig))
+}
+
 // listCmdRun lists all the registries.
-func (o *cmdContextOptions) listCmdRun() error {
+func (o *cmdContextOptions) listCmdRun(cmd *cobra.Command) error {
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
 	// GetContext current registry.
 	current, err := o.CurrentContext()
 	if err != nil {
@@ -164,6 +346,14 @@ func (o *cmdContextOptions) listCmdRun() error {
 		return err
 	}
 
+	if !format.IsDefault() {
+		result := contextList{Contexts: []contextInfo{}}
+		for _, v := range registries {
+			result.Contexts = append(result.Contexts, newContextInfo(v, current))
+		}
+		return format.Print(o.Out, result)
+	}
+
 	// Display all cached registries.
 	o.Out.Write([]byte("\nContexts:\n"))
 	if len(registries) == 0 {
@@ -218,10 +408,15 @@ func (o *cmdContextOptions) setCmdRun(cmd *cobra.Command, args []string) error {
 func (o *cmdContextOptions) getCmdRun(cmd *cobra.Command, args []string) error {
 	tips := fmt.Sprintf(">> tips：please use '%s -h' to get for information about the command.", cmd.CommandPath())
 
-	ctxName := args[0]
-	if len(ctxName) == 0 {
+	if len(args) == 0 || len(args[0]) == 0 {
 		return errors.Errorf("context name is not specified, %s", tips)
 	}
+	ctxName := args[0]
+
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
 
 	// Check against cache registries. If the given name does not match any entries, return an error.
 	reg, err := o.DB.GetContext(ctxName)
@@ -233,18 +428,35 @@ func (o *cmdContextOptions) getCmdRun(cmd *cobra.Command, args []string) error {
 		return errors.Errorf("context %q not found", ctxName)
 	}
 
+	if !format.IsDefault() {
+		current, err := o.CurrentContext()
+		if err != nil {
+			return err
+		}
+		return format.Print(o.Out, newContextInfo(reg, current))
+	}
+
 	// Display info.
 	o.Out.Write([]byte("\n" + fmt.Sprintf(`Context %q:
 - server: %s
 - insecure skip TLS verify: %v
+- certificate authority: %s
+- client certificate: %s
+- client key: %s
 - user: %s
 - password: ***
-`, reg.Name, reg.Server, reg.InsecureSkipTLSVerify, reg.User)))
+- credentials store: %s
+`, reg.Name, reg.Server, reg.InsecureSkipTLSVerify, reg.CAFile, reg.CertFile, reg.KeyFile, reg.User, reg.CredentialsStore)))
 	return nil
 }
 
 // addCmdRun add new registry.
 func (o *cmdContextOptions) addCmdRun(cmd *cobra.Command) error {
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
 	// Context name.
 	name, err := cmd.Flags().GetString("name")
 	if err != nil {
@@ -275,8 +487,52 @@ func (o *cmdContextOptions) addCmdRun(cmd *cobra.Command) error {
 		return err
 	}
 
+	// CA bundle.
+	caFile, err := cmd.Flags().GetString("ca-file")
+	if err != nil {
+		return err
+	}
+
+	// Client certificate and key.
+	certFile, err := cmd.Flags().GetString("cert-file")
+	if err != nil {
+		return err
+	}
+
+	keyFile, err := cmd.Flags().GetString("key-file")
+	if err != nil {
+		return err
+	}
+
+	if (len(certFile) == 0) != (len(keyFile) == 0) {
+		return errors.New("client certificate and key must be specified together")
+	}
+
+	// Credentials store.
+	store, err := cmd.Flags().GetString("credentials-store")
+	if err != nil {
+		return err
+	}
+
 	// Add new context.
-	ok, err := o.DB.Add(name, server, user, password, verify)
+	reg := &data.Registry{
+		Name:                  name,
+		Server:                server,
+		InsecureSkipTLSVerify: verify,
+		User:                  user,
+		Password:              password,
+		CAFile:                caFile,
+		CertFile:              certFile,
+		KeyFile:               keyFile,
+		CredentialsStore:      store,
+	}
+
+	// With a credentials store, the password is kept there rather than in regi's storage.
+	if len(store) > 0 {
+		reg.Password = ""
+	}
+
+	ok, err := o.DB.Add(reg)
 	if err != nil {
 		return err
 	}
@@ -285,13 +541,178 @@ func (o *cmdContextOptions) addCmdRun(cmd *cobra.Command) error {
 		return errors.Errorf("fail to add context, duplicated entry for context %q is not allowed", name)
 	}
 
+	if len(store) > 0 && len(password) > 0 {
+		err = credentials.NewStore(store).Store(server, credentials.Credentials{Username: user, Secret: password})
+		if err != nil {
+			return errors.Wrapf(err, "context %q is added, but unable to store its credentials", name)
+		}
+	}
+
+	// A newly added context is never the current one.
+	if !format.IsDefault() {
+		return format.Print(o.Out, newContextInfo(reg, nil))
+	}
+
 	o.Out.Write([]byte(fmt.Sprintf(`Context added:
 - name: %s
 - server: %s
 - insecure skip TLS verify: %v
+- certificate authority: %s
+- client certificate: %s
+- client key: %s
+- user: %s
+- password: ***
+- credentials store: %s
+`, name, server, verify, caFile, certFile, keyFile, user, store)))
+	return nil
+}
+
+// updateCmdRun updates the settings of a context given by flags, others are kept.
+func (o *cmdContextOptions) updateCmdRun(cmd *cobra.Command, args []string) error {
+	tips := fmt.Sprintf(">> tips：please use '%s -h' to get for information about the command.", cmd.CommandPath())
+
+	if len(args) == 0 {
+		return errors.Errorf("context name is missing\n%s", tips)
+	}
+	name := args[0]
+
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
+	reg, err := o.DB.GetContext(name)
+	if err != nil {
+		return err
+	}
+
+	if reg == nil {
+		return errors.Errorf("context %q not found", name)
+	}
+
+	// Where the credentials are before the update.
+	oldServer, oldStore := reg.Server, reg.CredentialsStore
+
+	// Patch the settings given by flags only.
+	flags := cmd.Flags()
+	updated := 0
+	for flag, field := range map[string]*string{
+		"server":            &reg.Server,
+		"user":              &reg.User,
+		"password":          &reg.Password,
+		"ca-file":           &reg.CAFile,
+		"cert-file":         &reg.CertFile,
+		"key-file":          &reg.KeyFile,
+		"credentials-store": &reg.CredentialsStore,
+	} {
+		if !flags.Changed(flag) {
+			continue
+		}
+
+		if *field, err = flags.GetString(flag); err != nil {
+			return err
+		}
+		updated++
+	}
+
+	if flags.Changed("verify") {
+		if reg.InsecureSkipTLSVerify, err = flags.GetBool("verify"); err != nil {
+			return err
+		}
+		updated++
+	}
+
+	if updated == 0 {
+		return errors.Errorf("nothing to update, please give the settings to change\n%s", tips)
+	}
+
+	if len(reg.Server) == 0 {
+		return errors.New("server can not be empty")
+	}
+
+	if (len(reg.CertFile) == 0) != (len(reg.KeyFile) == 0) {
+		return errors.New("client certificate and key must be specified together")
+	}
+
+	// The credentials move along with the server and the credentials store, unless a new
+	// password is given.
+	user, password := reg.User, reg.Password
+	moved := reg.CredentialsStore != oldStore || reg.Server != oldServer
+	if moved && !flags.Changed("password") && len(oldStore) > 0 {
+		c, err := credentials.NewStore(oldStore).Get(oldServer)
+		if err != nil {
+			return errors.Wrapf(err, "unable to read credentials of context %q from %s", name, oldStore)
+		}
+		if c != nil {
+			password = c.Secret
+			if !flags.Changed("user") && len(c.Username) > 0 {
+				user = c.Username
+			}
+		}
+	}
+
+	// With a credentials store, the password is kept there rather than in regi's storage. It is
+	// stored first, so that it is never lost.
+	reg.Password = password
+	if len(reg.CredentialsStore) > 0 {
+		if (moved || flags.Changed("password")) && len(password) > 0 {
+			c := credentials.Credentials{Username: user, Secret: password}
+			if err := credentials.NewStore(reg.CredentialsStore).Store(reg.Server, c); err != nil {
+				return errors.Wrapf(err, "unable to store credentials of context %q", name)
+			}
+		}
+		reg.Password = ""
+	}
+
+	if err := o.DB.UpdateContext(reg); err != nil {
+		return err
+	}
+
+	// The credentials left behind are erased once the context is updated, unless they are the
+	// ones just stored.
+	sameEntry := reg.CredentialsStore == oldStore && credentials.DockerServer(reg.Server) == credentials.DockerServer(oldServer)
+	if moved && len(oldStore) > 0 && !sameEntry {
+		if err := credentials.NewStore(oldStore).Erase(oldServer); err != nil {
+			o.ErrOut.Write([]byte(fmt.Sprintf("Warning: unable to erase the credentials of %s from %s: %s\n",
+				oldServer, oldStore, err)))
+		}
+	}
+
+	if !format.IsDefault() {
+		current, err := o.CurrentContext()
+		if err != nil {
+			return err
+		}
+		return format.Print(o.Out, newContextInfo(reg, current))
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf(`Context updated:
+- name: %s
+- server: %s
+- insecure skip TLS verify: %v
+- certificate authority: %s
+- client certificate: %s
+- client key: %s
 - user: %s
 - password: ***
-`, name, server, verify, user)))
+- credentials store: %s
+`, reg.Name, reg.Server, reg.InsecureSkipTLSVerify, reg.CAFile, reg.CertFile, reg.KeyFile, reg.User, reg.CredentialsStore)))
+	return nil
+}
+
+// renameCmdRun renames a context.
+func (o *cmdContextOptions) renameCmdRun(cmd *cobra.Command, args []string) error {
+	tips := fmt.Sprintf(">> tips：please use '%s -h' to get for information about the command.", cmd.CommandPath())
+
+	if len(args) != 2 || len(args[0]) == 0 || len(args[1]) == 0 {
+		return errors.Errorf("old and new context names are required\n%s", tips)
+	}
+
+	if err := o.DB.RenameContext(args[0], args[1]); err != nil {
+		return err
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf("Context %s has been renamed to %s\n", args[0], args[1])))
 	return nil
 }
 
@@ -325,3 +746,19 @@ func (o *cmdContextOptions) deleteCmdRun(cmd *cobra.Command, args []string) erro
 
 	return nil
 }
+
+// migrateSecretsCmdRun encrypts the passwords stored in plain text.
+func (o *cmdContextOptions) migrateSecretsCmdRun() error {
+	n, err := o.DB.MigrateSecrets()
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		o.Out.Write([]byte("No password stored in plain text\n"))
+		return nil
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf("%d passwords encrypted\n", n)))
+	return nil
+}
@@ -0,0 +1,123 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/output"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+)
+
+// exportCmdRun prints the bundle of the given contexts, or of all of them, in YAML unless
+// another output format is asked for.
+func (o *cmdContextOptions) exportCmdRun(cmd *cobra.Command, args []string) error {
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
+	if format.IsDefault() {
+		if format, err = output.ParseFormat(output.FormatYAML); err != nil {
+			return err
+		}
+	}
+
+	withSecrets, err := cmd.Flags().GetBool("with-secrets")
+	if err != nil {
+		return err
+	}
+
+	// Passwords are only exported encrypted, with a passphrase to share with the bundle.
+	passphrase := ""
+	if withSecrets {
+		passphrase = os.Getenv(data.PassphraseEnv)
+		if len(passphrase) == 0 {
+			return errors.Errorf("--with-secrets needs a passphrase to encrypt passwords with, please set %s", data.PassphraseEnv)
+		}
+	}
+
+	bundle, err := o.DB.Export(args, passphrase)
+	if err != nil {
+		return err
+	}
+
+	return format.Print(o.Out, bundle)
+}
+
+// importCmdRun imports the contexts of a bundle file, '-' for stdin.
+func (o *cmdContextOptions) importCmdRun(cmd *cobra.Command, args []string) error {
+	tips := fmt.Sprintf(">> tips：please use '%s -h' to get for information about the command.", cmd.CommandPath())
+
+	if len(args) != 1 {
+		return errors.Errorf("bundle file is missing\n%s", tips)
+	}
+
+	strategy, err := cmd.Flags().GetString("strategy")
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+	if args[0] == "-" {
+		content, err = io.ReadAll(o.In)
+	} else {
+		content, err = os.ReadFile(args[0])
+	}
+	if err != nil {
+		return errors.Wrap(err, "unable to read bundle")
+	}
+
+	bundle, err := decodeBundle(content)
+	if err != nil {
+		return errors.Wrapf(err, "invalid bundle %s", args[0])
+	}
+
+	result, err := o.DB.Import(bundle, strategy, os.Getenv(data.PassphraseEnv))
+	if err != nil {
+		return err
+	}
+
+	for _, name := range result.Added {
+		o.Out.Write([]byte(fmt.Sprintf("added: %s\n", name)))
+	}
+	for _, name := range result.Updated {
+		o.Out.Write([]byte(fmt.Sprintf("updated: %s\n", name)))
+	}
+	for _, name := range result.Skipped {
+		o.Out.Write([]byte(fmt.Sprintf("skipped: %s, already exists\n", name)))
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf("%d added, %d updated, %d skipped\n",
+		len(result.Added), len(result.Updated), len(result.Skipped))))
+	return nil
+}
+
+// decodeBundle decodes a bundle in JSON or YAML. Unknown fields are refused, so that typos
+// are not silently ignored.
+func decodeBundle(content []byte) (*data.Bundle, error) {
+	var bundle data.Bundle
+
+	if trimmed := bytes.TrimSpace(content); len(trimmed) > 0 && trimmed[0] == '{' {
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&bundle); err != nil {
+			return nil, err
+		}
+		return &bundle, nil
+	}
+
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(&bundle); err != nil {
+		if err == io.EOF {
+			return nil, errors.New("bundle is empty")
+		}
+		return nil, err
+	}
+	return &bundle, nil
+}
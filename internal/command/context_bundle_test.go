@@ -0,0 +1,117 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCmdContextExportImport(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(data.PassphraseEnv, "")
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdContext(streams), "add", "-n=dev", "-s=http://localhost:5000", "-u=regi", "-p=s3cret")
+	assert.NoError(t, err)
+	_, err = executeCommand(NewCmdContext(streams), "add", "-n=prod", "-s=https://registry.test", "--ca-file=/etc/regi/ca.pem")
+	assert.NoError(t, err)
+
+	// YAML without secrets.
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "export")
+	assert.NoError(t, err)
+	assert.Equal(t, `version: 1
+contexts:
+  - name: dev
+    server: http://localhost:5000
+    insecure-skip-tls-verify: false
+    user: regi
+  - name: prod
+    server: https://registry.test
+    insecure-skip-tls-verify: false
+    certificate-authority: /etc/regi/ca.pem
+`, out.String())
+	secretFree := out.String()
+
+	// JSON, with secrets encrypted with the passphrase.
+	o := NewCmdContextOptions(mustOpenDB(t), streams)
+	export, _, err := NewCmdContext(streams).Find([]string{"export"})
+	assert.NoError(t, err)
+	assert.NoError(t, export.ParseFlags([]string{"--with-secrets"}))
+	assert.EqualError(t, o.exportCmdRun(export, nil),
+		"--with-secrets needs a passphrase to encrypt passwords with, please set REGI_PASSPHRASE")
+	assert.NoError(t, export.ParseFlags([]string{"--with-secrets=false"}))
+	assert.EqualError(t, o.exportCmdRun(export, []string{"unknown"}), `context "unknown" not found`)
+
+	t.Setenv(data.PassphraseEnv, "bundle passphrase")
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "context", "export", "dev", "-o", "json", "--with-secrets")
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), "s3cret")
+
+	var bundle data.Bundle
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &bundle))
+	assert.Len(t, bundle.Contexts, 1)
+	assert.True(t, data.IsEncrypted(bundle.Contexts[0].Password))
+	withSecrets := out.String()
+
+	// Imported on another machine.
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	file := filepath.Join(home, "contexts.yaml")
+	assert.NoError(t, os.WriteFile(file, []byte(secretFree), 0600))
+
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "import", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "added: dev\nadded: prod\n2 added, 0 updated, 0 skipped\n", out.String())
+
+	out.Reset()
+	streams.In = strings.NewReader(withSecrets)
+	_, err = executeCommand(NewCmdContext(streams), "import", "-", "--strategy=merge")
+	assert.NoError(t, err)
+	assert.Equal(t, "updated: dev\n0 added, 1 updated, 0 skipped\n", out.String())
+
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	dev, err := db.GetContext("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &data.Registry{Name: "dev", Server: "http://localhost:5000", User: "regi", Password: "s3cret"}, dev)
+
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "import", file)
+	assert.NoError(t, err)
+	assert.Equal(t, "skipped: dev, already exists\nskipped: prod, already exists\n0 added, 0 updated, 2 skipped\n", out.String())
+}
+
+func TestDecodeBundle(t *testing.T) {
+	bundle, err := decodeBundle([]byte(`{"version": 1, "contexts": [{"name": "dev", "server": "http://localhost:5000", "insecureSkipTLSVerify": true}]}`))
+	assert.NoError(t, err)
+	insecure := true
+	assert.Equal(t, &data.Bundle{Version: 1, Contexts: []*data.BundleContext{
+		{Name: "dev", Server: "http://localhost:5000", InsecureSkipTLSVerify: &insecure},
+	}}, bundle)
+
+	// Left out, TLS verification is not set.
+	bundle, err = decodeBundle([]byte("version: 1\ncontexts:\n- name: dev\n  server: http://localhost:5000\n"))
+	assert.NoError(t, err)
+	assert.Nil(t, bundle.Contexts[0].InsecureSkipTLSVerify)
+
+	_, err = decodeBundle([]byte("version: 1\ncontexts:\n- name: dev\n  sever: http://localhost:5000\n"))
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "field sever not found")
+
+	_, err = decodeBundle([]byte(`{"version": 1, "contxts": []}`))
+	assert.EqualError(t, err, `json: unknown field "contxts"`)
+
+	_, err = decodeBundle([]byte(""))
+	assert.EqualError(t, err, "bundle is empty")
+}
@@ -0,0 +1,111 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+const (
+	// dockerHubName is the name of the context of Docker Hub.
+	dockerHubName = "docker.io"
+
+	// dockerHubRegistry is the server of the registry API of Docker Hub.
+	dockerHubRegistry = "https://registry-1.docker.io"
+)
+
+// importDockerCmdRun adds a context for each registry of the Docker config file. Credentials
+// are left in the Docker config file, which contexts use as their credentials store.
+func (o *cmdContextOptions) importDockerCmdRun(cmd *cobra.Command) error {
+	path, err := cmd.Flags().GetString("file")
+	if err != nil {
+		return err
+	}
+
+	if len(path) == 0 {
+		path = credentials.DefaultDockerConfigPath()
+	}
+
+	entries, err := credentials.ReadDockerConfig(path)
+	if err != nil {
+		return err
+	}
+
+	if len(entries) == 0 {
+		o.Out.Write([]byte(fmt.Sprintf("No registry found in %s\n", path)))
+		return nil
+	}
+
+	registries, err := o.DB.ListContexts()
+	if err != nil {
+		return err
+	}
+
+	added, skipped, needCredentials := 0, 0, 0
+	for _, entry := range entries {
+		name, server := dockerContext(entry.Server)
+
+		if existing := findContext(registries, name, server); existing != nil {
+			o.Out.Write([]byte(fmt.Sprintf("skipped: %s, already exists as context %q\n", entry.Server, existing.Name)))
+			skipped++
+			continue
+		}
+
+		reg := &data.Registry{Name: name, Server: server, CredentialsStore: credentials.DockerConfig}
+		if entry.Credentials != nil {
+			reg.User = entry.Credentials.Username
+		}
+
+		ok, err := o.DB.Add(reg)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return errors.Errorf("fail to add context, duplicated entry for context %q is not allowed", name)
+		}
+		registries = append(registries, reg)
+
+		switch {
+		case entry.Err != nil:
+			o.Out.Write([]byte(fmt.Sprintf("needs credentials: %s, %s, please run 'regi login %s'\n", name, entry.Err, name)))
+			needCredentials++
+		case entry.Credentials == nil:
+			o.Out.Write([]byte(fmt.Sprintf("needs credentials: %s, none stored, please run 'regi login %s'\n", name, name)))
+			needCredentials++
+		default:
+			o.Out.Write([]byte(fmt.Sprintf("added: %s (%s)\n", name, server)))
+			added++
+		}
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf("%d added, %d need credentials, %d skipped\n", added, needCredentials, skipped)))
+	return nil
+}
+
+// dockerContext returns the context name and server of a registry of the Docker config file,
+// e.g. 'registry.example.com' and 'https://registry.example.com'.
+func dockerContext(key string) (string, string) {
+	host := strings.SplitN(strings.TrimPrefix(strings.TrimPrefix(key, "https://"), "http://"), "/", 2)[0]
+	if credentials.DockerServer(key) == credentials.DockerServer(dockerHubRegistry) {
+		return dockerHubName, dockerHubRegistry
+	}
+
+	if strings.HasPrefix(key, "http://") {
+		return host, "http://" + host
+	}
+	return host, "https://" + host
+}
+
+// findContext returns the context with the name, or of the server, nil if there is none.
+func findContext(registries []*data.Registry, name, server string) *data.Registry {
+	for _, reg := range registries {
+		if reg.Name == name || credentials.DockerServer(reg.Server) == credentials.DockerServer(server) {
+			return reg
+		}
+	}
+	return nil
+}
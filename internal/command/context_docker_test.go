@@ -0,0 +1,81 @@
+package command
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdContextImportDocker(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(home, ".docker"), 0700))
+	assert.NoError(t, os.WriteFile(filepath.Join(home, ".docker", "config.json"), []byte(`{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1Yi1wYXNz"},
+		"localhost:5000": {"auth": "cmVnaTpzM2NyZXQ="},
+		"registry.test": {}
+	},
+	"credHelpers": {"gcr.test": "missing"}
+}`), 0600))
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdContext(streams), "add", "-n=local", "-s=http://localhost:5000")
+	assert.NoError(t, err)
+
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "import-docker")
+	assert.NoError(t, err)
+	assert.Equal(t, `needs credentials: gcr.test, credential helper docker-credential-missing not found in PATH, please run 'regi login gcr.test'
+added: docker.io (https://registry-1.docker.io)
+skipped: localhost:5000, already exists as context "local"
+needs credentials: registry.test, none stored, please run 'regi login registry.test'
+1 added, 2 need credentials, 1 skipped
+`, out.String())
+
+	// Credentials are read from the Docker config file.
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	hub, err := db.GetContext("docker.io")
+	assert.NoError(t, err)
+	assert.Equal(t, &data.Registry{Name: "docker.io", Server: "https://registry-1.docker.io", User: "hub",
+		CredentialsStore: credentials.DockerConfig}, hub)
+
+	user, password, err := contextCredentials(hub)
+	assert.NoError(t, err)
+	assert.Equal(t, "hub", user)
+	assert.Equal(t, "hub-pass", password)
+
+	// Nothing new the second time.
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "import-docker")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "0 added, 0 need credentials, 4 skipped\n")
+
+	// Another file.
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "import-docker", "-f", filepath.Join(home, "missing.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, "No registry found in "+filepath.Join(home, "missing.json")+"\n", out.String())
+}
+
+func TestDockerContext(t *testing.T) {
+	for key, want := range map[string][2]string{
+		"https://index.docker.io/v1/": {"docker.io", "https://registry-1.docker.io"},
+		"registry.test:5000":          {"registry.test:5000", "https://registry.test:5000"},
+		"http://localhost:5000":       {"localhost:5000", "http://localhost:5000"},
+		"https://registry.test/v2/":   {"registry.test", "https://registry.test"},
+	} {
+		name, server := dockerContext(key)
+		assert.Equal(t, want, [2]string{name, server}, key)
+	}
+}
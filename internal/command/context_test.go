@@ -1,9 +1,14 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
 	"github.com/iamharvey/regi/internal/pkg/io"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -29,3 +34,217 @@ func TestCmdContext(t *testing.T) {
 	assert.NoError(t, err)
 
 }
+
+func TestCmdContextOutput(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(newRegiCommand(streams), "context", "add", "-n=ctx-1", "-s=http://localhost:5000", "-u=regi", "-p=secret")
+	assert.NoError(t, err)
+	_, err = executeCommand(newRegiCommand(streams), "context", "add", "-n=ctx-2", "-s=https://registry.test", "--ca-file=/etc/regi/ca.pem")
+	assert.NoError(t, err)
+	_, err = executeCommand(newRegiCommand(streams), "context", "set", "ctx-2")
+	assert.NoError(t, err)
+
+	// JSON.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "context", "list", "-o", "json")
+	assert.NoError(t, err)
+
+	var list contextList
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &list))
+	assert.Equal(t, contextList{Contexts: []contextInfo{
+		{Name: "ctx-1", Server: "http://localhost:5000", User: "regi"},
+		{Name: "ctx-2", Server: "https://registry.test", CAFile: "/etc/regi/ca.pem", Current: true},
+	}}, list)
+	assert.NotContains(t, out.String(), "secret")
+
+	// YAML.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "context", "get", "ctx-1", "--output=yaml")
+	assert.NoError(t, err)
+	assert.Equal(t, `name: ctx-1
+server: http://localhost:5000
+insecure-skip-tls-verify: false
+user: regi
+current: false
+`, out.String())
+
+	// Wide table.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "context", "list", "-o", "wide")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "CURRENT")
+	assert.Contains(t, out.String(), "https://registry.test")
+
+	// Go template.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "context", "list", "-o", "go-template={{range .Contexts}}{{.Name}} {{end}}")
+	assert.NoError(t, err)
+	assert.Equal(t, "ctx-1 ctx-2 ", out.String())
+}
+
+func TestCmdContextMigrateSecrets(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(data.PassphraseEnv, "")
+
+	location := filepath.Join(home, ".regi", "regi.yaml")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(location), 0700))
+	assert.NoError(t, os.WriteFile(location, []byte(`registries:
+- name: legacy
+  server: http://localhost:5000
+  user: regi
+  password: plain
+`), 0644))
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdContext(streams), "migrate-secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, "1 passwords encrypted\n", out.String())
+
+	content, err := os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "plain")
+
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "migrate-secrets")
+	assert.NoError(t, err)
+	assert.Equal(t, "No password stored in plain text\n", out.String())
+}
+
+func TestCmdContextUpdate(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(data.PassphraseEnv, "")
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdContext(streams), "add", "-n=typo", "-s=https://registyr.test", "-u=regi", "-p=s3cret",
+		"--ca-file=/etc/regi/ca.pem")
+	assert.NoError(t, err)
+	_, err = executeCommand(NewCmdContext(streams), "set", "typo")
+	assert.NoError(t, err)
+
+	// Only the given settings change.
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "update", "typo", "-s=https://registry.test", "--verify")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Context updated:\n- name: typo\n- server: https://registry.test\n")
+
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	reg, err := db.GetContext("typo")
+	assert.NoError(t, err)
+	assert.Equal(t, &data.Registry{Name: "typo", Server: "https://registry.test", InsecureSkipTLSVerify: true,
+		User: "regi", Password: "s3cret", CAFile: "/etc/regi/ca.pem"}, reg)
+
+	// Settings are cleared by empty values.
+	_, err = executeCommand(NewCmdContext(streams), "update", "typo", "--ca-file=", "--verify=false")
+	assert.NoError(t, err)
+
+	// Renaming keeps it current.
+	out.Reset()
+	_, err = executeCommand(NewCmdContext(streams), "rename", "typo", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "Context typo has been renamed to prod\n", out.String())
+
+	db, err = data.NewDB()
+	assert.NoError(t, err)
+	current, err := db.CurrentContext()
+	assert.NoError(t, err)
+	assert.Equal(t, &data.Registry{Name: "prod", Server: "https://registry.test", User: "regi", Password: "s3cret"}, current)
+
+	// Errors.
+	o := NewCmdContextOptions(mustOpenDB(t), streams)
+	cmd := NewCmdContext(streams)
+	update, _, err := cmd.Find([]string{"update"})
+	assert.NoError(t, err)
+	assert.EqualError(t, o.updateCmdRun(update, []string{"unknown"}), `context "unknown" not found`)
+	assert.Contains(t, o.updateCmdRun(update, []string{"prod"}).Error(), "nothing to update")
+
+	assert.NoError(t, update.ParseFlags([]string{"--cert-file=/etc/regi/client.pem"}))
+	assert.EqualError(t, o.updateCmdRun(update, []string{"prod"}), "client certificate and key must be specified together")
+
+	rename, _, err := cmd.Find([]string{"rename"})
+	assert.NoError(t, err)
+	assert.Contains(t, o.renameCmdRun(rename, []string{"prod"}).Error(), "old and new context names are required")
+	assert.EqualError(t, o.renameCmdRun(rename, []string{"unknown", "new"}), `context "unknown" not found`)
+}
+
+func TestCmdContextUpdateCredentialsStore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+	t.Setenv(data.PassphraseEnv, "")
+
+	streams := io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr}
+	_, err := executeCommand(NewCmdContext(streams), "add", "-n=prod", "-s=https://registry.test", "-u=regi", "-p=s3cret")
+	assert.NoError(t, err)
+
+	// The stored password moves to the credentials store.
+	_, err = executeCommand(NewCmdContext(streams), "update", "prod", "--credentials-store="+credentials.DockerConfig)
+	assert.NoError(t, err)
+
+	c, err := credentials.NewStore(credentials.DockerConfig).Get("https://registry.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &credentials.Credentials{Username: "regi", Secret: "s3cret"}, c)
+
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	reg, err := db.GetContext("prod")
+	assert.NoError(t, err)
+	assert.Equal(t, &data.Registry{Name: "prod", Server: "https://registry.test", User: "regi",
+		CredentialsStore: credentials.DockerConfig}, reg)
+
+	// And back to regi's storage once the store is cleared.
+	_, err = executeCommand(NewCmdContext(streams), "update", "prod", "--credentials-store=")
+	assert.NoError(t, err)
+
+	db, err = data.NewDB()
+	assert.NoError(t, err)
+	reg, err = db.GetContext("prod")
+	assert.NoError(t, err)
+	assert.Equal(t, &data.Registry{Name: "prod", Server: "https://registry.test", User: "regi", Password: "s3cret"}, reg)
+
+	// Nor left in the credentials store.
+	c, err = credentials.NewStore(credentials.DockerConfig).Get("https://registry.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestCmdContextUpdateServerWithCredentialsStore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+
+	streams := io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr}
+	_, err := executeCommand(NewCmdContext(streams), "add", "-n=prod", "-s=https://registyr.test", "-u=regi", "-p=s3cret",
+		"--credentials-store="+credentials.DockerConfig)
+	assert.NoError(t, err)
+
+	// Fixing the server moves the credentials to it.
+	_, err = executeCommand(NewCmdContext(streams), "update", "prod", "--server=https://registry.test")
+	assert.NoError(t, err)
+
+	store := credentials.NewStore(credentials.DockerConfig)
+	c, err := store.Get("https://registry.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &credentials.Credentials{Username: "regi", Secret: "s3cret"}, c)
+
+	c, err = store.Get("https://registyr.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	// The same host under another scheme keeps its entry.
+	_, err = executeCommand(NewCmdContext(streams), "update", "prod", "--server=registry.test")
+	assert.NoError(t, err)
+
+	c, err = store.Get("registry.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &credentials.Credentials{Username: "regi", Secret: "s3cret"}, c)
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/pkg/errors"
+)
+
+// contextCredentials returns the username and password of the context, read from its
+// credentials store if it has one.
+func contextCredentials(reg *data.Registry) (string, string, error) {
+	if len(reg.CredentialsStore) == 0 {
+		return reg.User, reg.Password, nil
+	}
+
+	c, err := credentials.NewStore(reg.CredentialsStore).Get(reg.Server)
+	if err != nil {
+		return "", "", errors.Wrapf(err, "unable to get credentials of context %q", reg.Name)
+	}
+
+	// Nothing stored yet, the registry may still be reachable anonymously.
+	if c == nil {
+		return reg.User, "", nil
+	}
+	return c.Username, c.Secret, nil
+}
+
+// withCredentials sets the username and password to the client config. An identity token, given
+// as the password of IdentityTokenUser, is exchanged for tokens rather than sent as a password.
+func withCredentials(cfg *rest.ClientConfig, user, password string) *rest.ClientConfig {
+	if (credentials.Credentials{Username: user}).IsIdentityToken() {
+		cfg.IdentityToken = password
+		return cfg
+	}
+
+	cfg.Username, cfg.Password = user, password
+	return cfg
+}
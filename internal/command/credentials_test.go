@@ -0,0 +1,77 @@
+package command
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdCredentialsStore(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+
+	server := registrytest.NewServer(t)
+	server.Username, server.Password = "regi", "s3cret"
+	server.AddImage("app", "1.0", []byte(`{"os":"linux"}`), []byte("layer"))
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdContext(streams), "add", "-n=docker", "-s="+server.URL, "-u=regi", "-p=s3cret",
+		"--credentials-store="+credentials.DockerConfig)
+	assert.NoError(t, err)
+	_, err = executeCommand(NewCmdContext(streams), "set", "docker")
+	assert.NoError(t, err)
+
+	// The password is kept in the Docker config file only.
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	reg, err := db.GetContext("docker")
+	assert.NoError(t, err)
+	assert.Equal(t, credentials.DockerConfig, reg.CredentialsStore)
+	assert.Empty(t, reg.Password)
+
+	c, err := credentials.NewStore(credentials.DockerConfig).Get(server.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, &credentials.Credentials{Username: "regi", Secret: "s3cret"}, c)
+
+	// Images are listed with the stored credentials.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "list")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "app")
+
+	user, password, err := contextCredentials(reg)
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", user)
+	assert.Equal(t, "s3cret", password)
+
+	// Nothing stored, the user of the context is kept.
+	assert.NoError(t, credentials.NewStore(credentials.DockerConfig).Erase(server.URL))
+	user, password, err = contextCredentials(reg)
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", user)
+	assert.Empty(t, password)
+
+	// A missing helper.
+	_, _, err = contextCredentials(&data.Registry{Name: "broken", Server: server.URL, CredentialsStore: "missing"})
+	assert.EqualError(t, err,
+		`unable to get credentials of context "broken": credential helper docker-credential-missing not found in PATH`)
+}
+
+func TestWithCredentials(t *testing.T) {
+	cfg := withCredentials(&rest.ClientConfig{}, "regi", "s3cret")
+	assert.Equal(t, &rest.ClientConfig{Username: "regi", Password: "s3cret"}, cfg)
+
+	// Identity tokens are exchanged for tokens, not sent as a password.
+	cfg = withCredentials(&rest.ClientConfig{}, credentials.IdentityTokenUser, "tok")
+	assert.Equal(t, &rest.ClientConfig{IdentityToken: "tok"}, cfg)
+}
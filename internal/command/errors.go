@@ -0,0 +1,131 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/pkg/errors"
+	"net/http"
+	"os"
+)
+
+const (
+	// exitCodeError is the exit code for errors of any other kind.
+	exitCodeError = 1
+
+	// exitCodeNotFound is the exit code when a repository, tag, manifest or blob is not found.
+	exitCodeNotFound = 3
+
+	// exitCodeUnauthorized is the exit code when the registry refuses the credentials or
+	// the user lacks permission.
+	exitCodeUnauthorized = 4
+
+	// exitCodeUnsupported is the exit code when the registry does not support the operation,
+	// e.g. deletion is disabled.
+	exitCodeUnsupported = 5
+
+	// exitCodeTooManyRequests is the exit code when the registry rate limits the requests.
+	exitCodeTooManyRequests = 6
+)
+
+// cmdError is an error with a message for user, which keeps the cause to tell the exit code.
+type cmdError struct {
+	msg   string
+	cause error
+}
+
+// Error implements error.
+func (e *cmdError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the cause.
+func (e *cmdError) Unwrap() error {
+	return e.cause
+}
+
+// newCmdError returns an error with the given message and cause.
+func newCmdError(cause error, format string, args ...interface{}) error {
+	return &cmdError{msg: fmt.Sprintf(format, args...), cause: cause}
+}
+
+// checkErr prints the error and exits with a code telling the kind of error. It does nothing
+// if the error is nil, just like cobra.CheckErr.
+func checkErr(err error) {
+	if err == nil {
+		return
+	}
+
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(exitCode(err))
+}
+
+// exitCode tells the exit code for the error.
+func exitCode(err error) int {
+	switch {
+	case isNotFound(err):
+		return exitCodeNotFound
+	case isUnauthorized(err):
+		return exitCodeUnauthorized
+	case isUnsupported(err):
+		return exitCodeUnsupported
+	case rest.HasStatus(err, http.StatusTooManyRequests), rest.HasErrorCode(err, rest.ErrorCodeTooManyRequests):
+		return exitCodeTooManyRequests
+	default:
+		return exitCodeError
+	}
+}
+
+// explainRegistryError returns an error with a precise message for common registry errors
+// about the subject, e.g. 'hello-world:latest'. Other errors are returned as they are.
+func explainRegistryError(err error, subject string) error {
+	var e *rest.RegistryError
+	if !errors.As(err, &e) {
+		return err
+	}
+
+	switch {
+	case isNotFound(err):
+		return newCmdError(err, "%s not found%s", subject, registryMessage(e))
+	case rest.HasStatus(err, http.StatusUnauthorized), rest.HasErrorCode(err, rest.ErrorCodeUnauthorized):
+		return newCmdError(err, "unauthorized to access %s, please check the user and password of the context%s",
+			subject, registryMessage(e))
+	case isUnauthorized(err):
+		return newCmdError(err, "access to %s is denied, the user of the context lacks permission%s",
+			subject, registryMessage(e))
+	case isUnsupported(err):
+		return newCmdError(err, "operation on %s is not supported by the registry (UNSUPPORTED)%s",
+			subject, registryMessage(e))
+	default:
+		return err
+	}
+}
+
+// registryMessage formats the message sent by the registry, if any.
+func registryMessage(e *rest.RegistryError) string {
+	if len(e.Code) == 0 {
+		return ""
+	}
+	return fmt.Sprintf(": %s (%s)", e.Message, e.Code)
+}
+
+// isNotFound tells if the registry did not find the requested content.
+func isNotFound(err error) bool {
+	return rest.HasStatus(err, http.StatusNotFound) ||
+		rest.HasErrorCode(err, rest.ErrorCodeManifestUnknown) ||
+		rest.HasErrorCode(err, rest.ErrorCodeNameUnknown) ||
+		rest.HasErrorCode(err, rest.ErrorCodeBlobUnknown)
+}
+
+// isUnauthorized tells if the registry refused the credentials or the user lacks permission.
+func isUnauthorized(err error) bool {
+	return rest.HasStatus(err, http.StatusUnauthorized) ||
+		rest.HasStatus(err, http.StatusForbidden) ||
+		rest.HasErrorCode(err, rest.ErrorCodeUnauthorized) ||
+		rest.HasErrorCode(err, rest.ErrorCodeDenied)
+}
+
+// isUnsupported tells if the registry does not support the operation.
+func isUnsupported(err error) bool {
+	return rest.HasStatus(err, http.StatusMethodNotAllowed) ||
+		rest.HasErrorCode(err, rest.ErrorCodeUnsupported)
+}
@@ -0,0 +1,66 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestExplainRegistryError(t *testing.T) {
+	cases := []struct {
+		name     string
+		err      error
+		expected string
+		code     int
+	}{
+		{
+			name: "manifest unknown",
+			err: &rest.RegistryError{
+				StatusCode:  http.StatusNotFound,
+				ErrorDetail: rest.ErrorDetail{Code: rest.ErrorCodeManifestUnknown, Message: "manifest unknown"},
+				Errors:      []rest.ErrorDetail{{Code: rest.ErrorCodeManifestUnknown, Message: "manifest unknown"}},
+			},
+			expected: "hello-world:latest not found: manifest unknown (MANIFEST_UNKNOWN)",
+			code:     exitCodeNotFound,
+		},
+		{
+			name:     "unauthorized",
+			err:      &rest.RegistryError{StatusCode: http.StatusUnauthorized},
+			expected: "unauthorized to access hello-world:latest, please check the user and password of the context",
+			code:     exitCodeUnauthorized,
+		},
+		{
+			name:     "denied",
+			err:      &rest.RegistryError{StatusCode: http.StatusForbidden},
+			expected: "access to hello-world:latest is denied, the user of the context lacks permission",
+			code:     exitCodeUnauthorized,
+		},
+		{
+			name:     "unsupported",
+			err:      errors.Wrap(&rest.RegistryError{StatusCode: http.StatusMethodNotAllowed}, "wrapped"),
+			expected: "operation on hello-world:latest is not supported by the registry (UNSUPPORTED)",
+			code:     exitCodeUnsupported,
+		},
+		{
+			name:     "too many requests",
+			err:      &rest.RegistryError{StatusCode: http.StatusTooManyRequests, Status: "429 Too Many Requests", URL: "http://registry.test/v2/"},
+			expected: "request to http://registry.test/v2/ failed, server responded 429 Too Many Requests",
+			code:     exitCodeTooManyRequests,
+		},
+		{
+			name:     "other error",
+			err:      fmt.Errorf("connection refused"),
+			expected: "connection refused",
+			code:     exitCodeError,
+		},
+	}
+
+	for _, c := range cases {
+		err := explainRegistryError(c.err, "hello-world:latest")
+		assert.EqualError(t, err, c.expected, c.name)
+		assert.Equal(t, c.code, exitCode(err), c.name)
+	}
+}
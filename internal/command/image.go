@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"github.com/iamharvey/regi/internal/pkg/data"
 	rio "github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/layout"
+	"github.com/iamharvey/regi/internal/pkg/registry"
 	"github.com/iamharvey/regi/internal/pkg/rest"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"os/exec"
+	"os"
+	"runtime"
 	"strings"
 	"time"
 )
@@ -20,45 +23,116 @@ const (
 	msgShortImgListCmd = "ListContexts images on current registry."
 
 	// msgShortImgPullCmd is the short version description for 'image pull' command.
-	msgShortImgPullCmd = "Pull image from current registry."
+	msgShortImgPullCmd = "Pull image from current registry to a tarball or an OCI image layout."
 
 	// msgShortImgPushCmd is the short version description for 'image push' command.
-	msgShortImgPushCmd = "Push image to current registry."
+	msgShortImgPushCmd = "Push image from a tarball or an OCI image layout to current registry."
 
 	// msgShortImgDelCmd is the short version description for 'image delete' command.
 	msgShortImgDelCmd = "DeleteContext image from current registry."
 
-	// termDelSuccess is the key term for successful deletion operation.
-	termDelSuccess = "202 accepted"
+	// msgShortImgInspectCmd is the short version description for 'image inspect' command.
+	msgShortImgInspectCmd = "Show manifest, config and layers of an image on current registry."
 
-	// termDelFailure is the key term for failed deletion operation.
-	// termDelFailure = "404 not found"
+	// msgShortImgCopyCmd is the short version description for 'image copy' command.
+	msgShortImgCopyCmd = "Copy image between the registries of two contexts, without Docker daemon."
+
+	// msgShortImgPruneCmd is the short version description for 'image prune' command.
+	msgShortImgPruneCmd = "Delete tags from current registry by retention rules."
+
+	// msgShortImgTagCmd is the short version description for 'image tag' command.
+	msgShortImgTagCmd = "Tag image on current registry, without pulling it."
+)
+
+const (
+	// imageFormatDocker saves images to tarballs loadable by 'docker load'.
+	imageFormatDocker = "docker"
+
+	// imageFormatOCI saves images to OCI image layout directories.
+	imageFormatOCI = "oci"
 )
 
+// defaultPlatform is the platform pulled from a multi-platform image when none is given.
+var defaultPlatform = "linux/" + runtime.GOARCH
+
+// imageInfo is the output of an image repository.
+type imageInfo struct {
+	Name string   `json:"name" yaml:"name"`
+	Tags []string `json:"tags,omitempty" yaml:"tags,omitempty"`
+
+	// Platforms maps tag to the platforms it supports.
+	Platforms map[string][]string `json:"platforms,omitempty" yaml:"platforms,omitempty"`
+}
+
+// platformsOf formats the platforms of each tag, e.g. 'latest=linux/amd64,linux/arm64'.
+func (i imageInfo) platformsOf() []string {
+	var s []string
+	for _, tag := range i.Tags {
+		if platforms, ok := i.Platforms[tag]; ok {
+			s = append(s, fmt.Sprintf("%s=%s", tag, strings.Join(platforms, ",")))
+		}
+	}
+	return s
+}
+
+// imageList is the output of `image list`.
+type imageList struct {
+	Images []imageInfo `json:"images" yaml:"images"`
+}
+
+// Header implements output.Table.
+func (l imageList) Header() []string {
+	if l.hasPlatforms() {
+		return []string{"REPOSITORY", "TAGS", "PLATFORMS"}
+	}
+	return []string{"REPOSITORY", "TAGS"}
+}
+
+// Rows implements output.Table.
+func (l imageList) Rows() [][]string {
+	var rows [][]string
+	for _, image := range l.Images {
+		row := []string{image.Name, strings.Join(image.Tags, ",")}
+		if l.hasPlatforms() {
+			row = append(row, strings.Join(image.platformsOf(), " "))
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// hasPlatforms tells if the platforms of the tags are listed.
+func (l imageList) hasPlatforms() bool {
+	for _, image := range l.Images {
+		if image.Platforms != nil {
+			return true
+		}
+	}
+	return false
+}
+
 // cmdImageOptions eases access to storage and console io.
 type cmdImageOptions struct {
 	*data.DB
 	rio.Streams
+
+	// tokens caches registry tokens across API calls.
+	tokens *rest.TokenCache
 }
 
-// NewCmdImageOptions returns a new Options for image command.
-func NewCmdImageOptions(streams rio.Streams) (*cmdImageOptions, error) {
-	db, err := data.NewDB()
-	if err != nil {
-		return nil, err
-	}
+// NewCmdImageOptions returns a new Options for image command on the storage db. Commands pass nil, and
+// open the storage given by the global flags once they are parsed.
+func NewCmdImageOptions(db *data.DB, streams rio.Streams) *cmdImageOptions {
 	return &cmdImageOptions{
 		DB:      db,
 		Streams: streams,
-	}, nil
+		tokens:  rest.NewTokenCache(),
+	}
 }
 
 // NewCmdImage creates an image command.
 func NewCmdImage(streams rio.Streams) *cobra.Command {
-	o, err := NewCmdImageOptions(streams)
-	if err != nil {
-		streams.ErrOut.Write([]byte(err.Error()))
-	}
+	o := NewCmdImageOptions(nil, streams)
 
 	// Context root command.
 	cmd := &cobra.Command{
@@ -66,6 +140,11 @@ func NewCmdImage(streams rio.Streams) *cobra.Command {
 		Aliases:               []string{"i", "im", "img"},
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortImageCmd,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			var err error
+			o.DB, err = openDB(cmd)
+			checkErr(err)
+		},
 	}
 
 	// ListContexts all the contexts.
@@ -75,7 +154,7 @@ func NewCmdImage(streams rio.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortImgListCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.listCmdRun(cmd))
+			checkErr(o.listCmdRun(cmd))
 		},
 	}
 
@@ -85,7 +164,7 @@ func NewCmdImage(streams rio.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortImgPullCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.pullCmdRun(args))
+			checkErr(o.pullCmdRun(cmd, args))
 		},
 	}
 
@@ -95,18 +174,59 @@ func NewCmdImage(streams rio.Streams) *cobra.Command {
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortImgPushCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.pushCmdRun(args))
+			checkErr(o.pushCmdRun(cmd, args))
 		},
 	}
 
 	// DeleteContext image from remote registry.
 	delCmd := &cobra.Command{
-		Use:                   "delete",
+		Use:                   "delete <repository> <tag|pattern|digest>... | delete <repository> --all-tags",
 		Aliases:               []string{"d", "del"},
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortImgDelCmd,
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.delCmdRun(args))
+			checkErr(o.delCmdRun(cmd, args))
+		},
+	}
+
+	// Inspect image on remote registry.
+	inspectCmd := &cobra.Command{
+		Use:                   "inspect",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortImgInspectCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.inspectCmdRun(cmd, args))
+		},
+	}
+
+	// Copy image between registries.
+	copyCmd := &cobra.Command{
+		Use:                   "copy <src-context>/<repository>:<tag> <dst-context>/<repository>[:<tag>]",
+		Aliases:               []string{"cp"},
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortImgCopyCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.copyCmdRun(cmd, args))
+		},
+	}
+
+	// Prune tags by retention rules.
+	pruneCmd := &cobra.Command{
+		Use:                   "prune [repository...]",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortImgPruneCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.pruneCmdRun(cmd, args))
+		},
+	}
+
+	// Tag image on remote registry.
+	tagCmd := &cobra.Command{
+		Use:                   "tag <repository>:<tag> <new-tag>...",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortImgTagCmd,
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.tagCmdRun(cmd, args))
 		},
 	}
 
@@ -114,276 +234,413 @@ func NewCmdImage(streams rio.Streams) *cobra.Command {
 	cmd.AddCommand(pullCmd)
 	cmd.AddCommand(pushCmd)
 	cmd.AddCommand(delCmd)
+	cmd.AddCommand(inspectCmd)
+	cmd.AddCommand(copyCmd)
+	cmd.AddCommand(pruneCmd)
+	cmd.AddCommand(tagCmd)
 	listCmd.Flags().BoolP("withTag", "t", true, "show tags")
+	listCmd.Flags().IntP("limit", "l", 0, "maximum number of images to list, 0 means no limit")
+	listCmd.Flags().Bool("platforms", false, "show the platforms of each tag")
+	pullCmd.Flags().StringP("path", "p", "",
+		"file or directory to save the image to, defaults to <image>_<tag>.tar, or <image>_<tag> for oci format")
+	pullCmd.Flags().String("format", imageFormatDocker,
+		"docker for a tarball loadable by 'docker load', or oci for an OCI image layout directory")
+	pullCmd.Flags().String("platform", "",
+		"platform to pull from a multi-platform image, e.g. linux/arm64, defaults to linux/<arch of regi> for docker format")
+	pushCmd.Flags().StringP("path", "p", "",
+		"tarball or OCI image layout directory to push the image from, defaults to <image>_<tag>.tar")
+	pushCmd.Flags().String("platform", "", "platform to push from a multi-platform image, e.g. linux/arm64")
+	delCmd.Flags().BoolP("recursive", "r", false,
+		"delete the platform manifests of a multi-platform index as well")
+	delCmd.Flags().Bool("all-tags", false, "delete the manifests of all the tags of the repository")
+	delCmd.Flags().Bool("regex", false, "take the tags as regexes instead of glob patterns")
+	delCmd.Flags().BoolP("yes", "y", false, "delete without asking for confirmation")
+	inspectCmd.Flags().Bool("raw", false, "print the manifest and config as sent by the registry")
+	inspectCmd.Flags().String("platform", "",
+		"inspect the image for the platform of a multi-platform index, e.g. linux/arm64")
+	pruneCmd.Flags().Int("keep-last", 0, "keep the given number of newest tags of each repository")
+	pruneCmd.Flags().Bool("keep-semver", false, "keep the tags which are semantic versions, e.g. v1.2.3")
+	pruneCmd.Flags().StringArray("keep", nil, "regex of the tags to keep, may be repeated")
+	pruneCmd.Flags().String("older-than", "", "delete only the tags older than the duration, e.g. 30d or 12h")
+	pruneCmd.Flags().Bool("dry-run", false, "show what would be deleted and why, without deleting anything")
 
 	return cmd
 }
 
 // imageCmdRun lists all the images with/without tags for current registry.
 func (o *cmdImageOptions) listCmdRun(cmd *cobra.Command) error {
-	showTags, err := cmd.Flags().GetBool("withTag")
+	format, err := outputFormat(cmd)
 	if err != nil {
 		return err
 	}
 
-	// GetContext current registry.
-	current, err := o.CurrentContext()
+	showTags, err := cmd.Flags().GetBool("withTag")
 	if err != nil {
 		return err
 	}
 
-	if current == nil {
-		return errors.New("context is not set, please set current context with 'regi ctx set <name>' first")
-	}
-
-	// Create REST client for accessing APIs.
-	cliConfig := &rest.ClientConfig{
-		Host:    current.Server,
-		APIPath: "v2/_catalog",
-		ContentConfig: &rest.ContentConfig{
-			ContentType: "application/json",
-		},
-		TLSClientConfig: nil,
-		Timeout:         time.Second * 3,
+	limit, err := cmd.Flags().GetInt("limit")
+	if err != nil {
+		return err
 	}
 
-	client, err := rest.NewClient(cliConfig)
+	showPlatforms, err := cmd.Flags().GetBool("platforms")
 	if err != nil {
 		return err
 	}
 
-	// Execute API call.
-	resp, err := client.
-		Verb("GET").
-		Do()
+	client, err := o.currentRegistryClient()
 	if err != nil {
 		return err
 	}
-	defer resp.Body.Close()
 
-	// Decode response body.
-	rres, err := rest.DecodeResponse(resp)
+	// Walk through all the pages of the catalog.
+	repositories, err := client.Catalog(limit)
 	if err != nil {
-		return err
+		return explainRegistryError(err, "the catalog")
 	}
 
-	// Display all the images.
-	fmt.Println("\nImages:")
-	for _, repo := range rres["repositories"].([]interface{}) {
-		fmt.Printf("- %s ", repo)
+	result := imageList{Images: []imageInfo{}}
+	for _, repo := range repositories {
+		image := imageInfo{Name: repo}
 
 		// Query image tags.
 		if showTags {
-			cliConfig := &rest.ClientConfig{
-				Host:    current.Server,
-				APIPath: fmt.Sprintf("v2/%s/tags/list", repo),
-				ContentConfig: &rest.ContentConfig{
-					ContentType: "application/json",
-				},
-				TLSClientConfig: nil,
-				Timeout:         time.Second * 3,
-			}
-
-			client, err := rest.NewClient(cliConfig)
+			image.Tags, err = client.Tags(repo, 0)
 			if err != nil {
-				return err
+				return explainRegistryError(err, fmt.Sprintf("tags of %s", repo))
 			}
+		}
 
-			resp, err := client.
-				Verb("GET").
-				Do()
-			if err != nil {
-				return err
+		// Query the platforms of each tag, from the index or from the image config.
+		if showTags && showPlatforms {
+			image.Platforms = map[string][]string{}
+			for _, tag := range image.Tags {
+				manifest, err := client.GetManifest(repo, tag)
+				if err != nil {
+					return explainRegistryError(err, imageReference(repo, tag))
+				}
+
+				platforms, err := client.Platforms(repo, manifest)
+				if err != nil {
+					return explainRegistryError(err, imageReference(repo, tag))
+				}
+
+				for _, p := range platforms {
+					image.Platforms[tag] = append(image.Platforms[tag], p.String())
+				}
 			}
+		}
 
-			tres, err := rest.DecodeResponse(resp)
-			if err != nil {
-				return err
-			}
+		result.Images = append(result.Images, image)
+	}
 
-			resp.Body.Close()
+	if !format.IsDefault() {
+		return format.Print(o.Out, result)
+	}
 
-			fmt.Printf(" %s", tres["tags"])
+	// Display all the images.
+	o.Out.Write([]byte("\nImages:\n"))
+	for _, image := range result.Images {
+		o.Out.Write([]byte(fmt.Sprintf("- %s ", image.Name)))
+		if showTags {
+			o.Out.Write([]byte(fmt.Sprintf(" %s", image.Tags)))
+		}
+		o.Out.Write([]byte("\n"))
+		for _, tag := range image.Tags {
+			if platforms, ok := image.Platforms[tag]; ok {
+				o.Out.Write([]byte(fmt.Sprintf("    %s: %s\n", tag, strings.Join(platforms, ", "))))
+			}
 		}
-
-		fmt.Println()
 	}
 
 	return nil
 }
 
-// pullCmdRun pull image from remote registry.
-func (o *cmdImageOptions) pullCmdRun(args []string) error {
-	// Verify arguments.
-	if len(args) == 0 {
-		return errors.New("image and tag is not specified")
+// pullCmdRun pulls image from remote registry to a tarball or an OCI image layout directory,
+// without Docker daemon.
+func (o *cmdImageOptions) pullCmdRun(cmd *cobra.Command, args []string) error {
+	name, tag, err := imageNameAndTag(args)
+	if err != nil {
+		return err
 	}
 
-	if len(args) == 1 {
-		return errors.New("image tag is not specified")
+	format, err := cmd.Flags().GetString("format")
+	if err != nil {
+		return err
 	}
 
-	name := args[0]
-	tag := args[1]
+	if format != imageFormatDocker && format != imageFormatOCI {
+		return errors.Errorf("unknown image format %q, supported formats are %s and %s",
+			format, imageFormatDocker, imageFormatOCI)
+	}
 
-	// GetContext current registry.
-	current, err := o.CurrentContext()
+	path, err := cmd.Flags().GetString("path")
 	if err != nil {
 		return err
 	}
 
-	// Remove prefix for registry server.
-	server := strings.TrimPrefix(strings.TrimPrefix(current.Server, "https://"), "http://")
+	if len(path) == 0 {
+		path = defaultImagePath(name, tag, format)
+	}
 
-	// Execute command.
-	c := exec.Command(
-		"docker",
-		"pull",
-		fmt.Sprintf("%s/%s:%s", server, name, tag),
-	)
+	platform, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		return err
+	}
 
-	out, err := c.CombinedOutput()
+	current, err := o.currentContext()
 	if err != nil {
 		return err
 	}
 
-	o.Streams.Out.Write([]byte(fmt.Sprintf("%s", out)))
+	client, err := o.newTransferClient(current)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := client.GetManifest(name, tag)
+	if err != nil {
+		return explainRegistryError(err, imageReference(name, tag))
+	}
+
+	// A tarball for Docker holds a single image, while an OCI image layout keeps the index with
+	// all the platforms unless one is selected.
+	if len(platform) == 0 && manifest.IsIndex() && format == imageFormatDocker {
+		platform = defaultPlatform
+	}
+
+	if len(platform) > 0 {
+		wanted, err := registry.ParsePlatform(platform)
+		if err != nil {
+			return err
+		}
+
+		manifest, err = client.ResolvePlatform(name, manifest, *wanted)
+		if err != nil {
+			return explainRegistryError(err, imageReference(name, tag))
+		}
+	}
+
+	// Images are named after the registry, as if they were pulled by Docker.
+	fullName := fmt.Sprintf("%s/%s:%s", registryHost(current.Server), name, tag)
 
+	var w layout.Writer
+	if format == imageFormatOCI {
+		w, err = layout.NewDirWriter(path, fullName)
+	} else {
+		w, err = layout.NewTarWriter(path, fullName)
+	}
+	if err != nil {
+		return err
+	}
+
+	err = registry.Copy(client.Repository(name), w, manifest, tag, o.progress(map[registry.BlobStatus]string{
+		registry.BlobCopied: "Pull complete",
+		registry.BlobExists: "Already exists",
+	}))
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		// A partial tarball is of no use.
+		if format == imageFormatDocker {
+			os.Remove(path)
+		}
+		return explainRegistryError(err, imageReference(name, tag))
+	}
+
+	o.Streams.Out.Write([]byte(fmt.Sprintf("Digest: %s\nImage %s:%s is saved to %s\n", manifest.Digest, name, tag, path)))
 	return nil
 }
 
-// pushCmdRun tag and push image to remote registry.
-func (o *cmdImageOptions) pushCmdRun(args []string) error {
-	// Verify arguments.
-	if len(args) == 0 {
-		return errors.New("image and tag is not specified")
+// pushCmdRun pushes image from a tarball or an OCI image layout directory to remote registry,
+// without Docker daemon.
+func (o *cmdImageOptions) pushCmdRun(cmd *cobra.Command, args []string) error {
+	name, tag, err := imageNameAndTag(args)
+	if err != nil {
+		return err
 	}
 
-	if len(args) == 1 {
-		return errors.New("image tag is not specified")
+	path, err := cmd.Flags().GetString("path")
+	if err != nil {
+		return err
 	}
 
-	name := args[0]
-	tag := args[1]
+	if len(path) == 0 {
+		path = defaultImagePath(name, tag, imageFormatDocker)
+	}
 
-	// GetContext current registry.
-	current, err := o.CurrentContext()
+	platform, err := cmd.Flags().GetString("platform")
 	if err != nil {
 		return err
 	}
 
-	// Remove prefix for registry server.
-	server := strings.TrimPrefix(strings.TrimPrefix(current.Server, "https://"), "http://")
-
-	// Tag image.
-	c := exec.Command(
-		"docker",
-		"image",
-		"tag",
-		fmt.Sprintf("%s:%s", name, tag),
-		fmt.Sprintf("%s/%s:%s", server, name, tag))
+	r, err := layout.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return errors.Errorf("%s not found, please give the image tarball or OCI image layout directory with --path", path)
+		}
+		return err
+	}
+	defer r.Close()
 
-	out, err := c.CombinedOutput()
+	manifest, err := r.Image(fmt.Sprintf("%s:%s", name, tag))
 	if err != nil {
 		return err
 	}
 
-	o.Streams.Out.Write([]byte(fmt.Sprintf("%s", out)))
+	if len(platform) > 0 {
+		wanted, err := registry.ParsePlatform(platform)
+		if err != nil {
+			return err
+		}
+
+		manifest, err = registry.ResolvePlatform(r, manifest, *wanted)
+		if err != nil {
+			return err
+		}
+	}
 
-	// Push image.
-	c = exec.Command(
-		"docker",
-		"image",
-		"push",
-		fmt.Sprintf("%s/%s:%s", server, name, tag))
+	current, err := o.currentContext()
+	if err != nil {
+		return err
+	}
 
-	out, err = c.CombinedOutput()
+	client, err := o.newTransferClient(current)
 	if err != nil {
 		return err
 	}
 
-	o.Streams.Out.Write([]byte(fmt.Sprintf("\n%s", out)))
+	err = registry.Copy(r, client.Repository(name), manifest, tag, o.progress(map[registry.BlobStatus]string{
+		registry.BlobCopied: "Pushed",
+		registry.BlobExists: "Layer already exists",
+	}))
+	if err != nil {
+		return explainRegistryError(err, imageReference(name, tag))
+	}
 
+	o.Streams.Out.Write([]byte(fmt.Sprintf("%s: digest: %s size: %d\n", tag, manifest.Digest, len(manifest.Body))))
 	return nil
 }
 
-// delCmdRun delete image from remote registry.
-func (o *cmdImageOptions) delCmdRun(args []string) error {
-	// Verify arguments.
+// progress returns a registry.Progress printing the status of each blob, in the way of Docker.
+func (o *cmdImageOptions) progress(statuses map[registry.BlobStatus]string) registry.Progress {
+	return func(desc registry.Descriptor, status registry.BlobStatus) {
+		o.Streams.Out.Write([]byte(fmt.Sprintf("%s: %s\n", shortDigest(desc.Digest), statuses[status])))
+	}
+}
+
+// imageNameAndTag verifies the arguments giving the image name and tag.
+func imageNameAndTag(args []string) (string, string, error) {
 	if len(args) == 0 {
-		return errors.New("image and tag is not specified")
+		return "", "", errors.New("image and tag is not specified")
 	}
 
 	if len(args) == 1 {
-		return errors.New("image tag is not specified")
+		return "", "", errors.New("image tag is not specified")
 	}
 
-	name := args[0]
-	tag := args[1]
+	if strings.HasPrefix(args[1], "sha256:") {
+		return "", "", errors.Errorf("%s is a digest, please give a tag", args[1])
+	}
 
-	// GetContext current registry.
-	current, err := o.CurrentContext()
-	if err != nil {
-		return err
+	return args[0], args[1], nil
+}
+
+// defaultImagePath returns the default path to save an image to, e.g. 'library_golang_1.17.tar'.
+func defaultImagePath(name, tag, format string) string {
+	p := fmt.Sprintf("%s_%s", strings.ReplaceAll(name, "/", "_"), tag)
+	if format == imageFormatDocker {
+		p += ".tar"
 	}
+	return p
+}
 
-	// First, get the manifest info with desired tag.
-	cliConfig := &rest.ClientConfig{
-		Host:    current.Server,
-		APIPath: fmt.Sprintf("v2/%s/manifests/%s", name, tag),
-		ContentConfig: &rest.ContentConfig{
-			AcceptContentTypes: "application/vnd.docker.distribution.manifest.v2+json",
-		},
-		TLSClientConfig: nil,
-		Timeout:         time.Second * 3,
+// shortDigest shortens a digest to 12 hex characters, e.g. 'sha256:0123456789abcdef' to '0123456789ab'.
+func shortDigest(digest string) string {
+	hex := strings.TrimPrefix(digest, "sha256:")
+	if len(hex) > 12 {
+		return hex[:12]
 	}
+	return hex
+}
 
-	client, err := rest.NewClient(cliConfig)
+// registryHost removes the scheme from the registry server.
+func registryHost(server string) string {
+	return strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+}
+
+// currentRegistryClient creates a registry client for the registry of the current context.
+func (o *cmdImageOptions) currentRegistryClient() (*registry.Client, error) {
+	current, err := o.currentContext()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Execute API call.
-	resp, err := client.
-		Verb("GET").
-		Do()
+	return o.newRegistryClient(current)
+}
+
+// currentContext returns the context to use, which must be set. It is the current context unless
+// another one is given by --context or the environment.
+func (o *cmdImageOptions) currentContext() (*data.Registry, error) {
+	current, err := o.ActiveContext()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Second, we delete that image using the obtained manifest digest.
-	// In most cases, digest can be obtained by resp.Header.GetContext("Docker-Content-Digest").
-	digest := resp.Header.Get("Docker-Content-Digest")
-	cliConfig = &rest.ClientConfig{
-		Host:    current.Server,
-		APIPath: fmt.Sprintf("v2/%s/manifests/%s", name, digest),
-		ContentConfig: &rest.ContentConfig{
-			AcceptContentTypes: "application/vnd.docker.distribution.manifest.v2+json",
-		},
-		TLSClientConfig: nil,
-		Timeout:         time.Second * 3,
+	if current == nil {
+		return nil, errors.New("context is not set, please set current context with 'regi ctx set <name>' first")
 	}
 
-	client, err = rest.NewClient(cliConfig)
+	return current, nil
+}
+
+// newRegistryClient creates a registry client for the registry of the context.
+// Credentials of the context are used whenever the registry asks for authentication.
+func (o *cmdImageOptions) newRegistryClient(reg *data.Registry) (*registry.Client, error) {
+	return o.registryClient(reg, time.Second*3)
+}
+
+// newTransferClient creates a registry client to transfer images from or to the registry of
+// the context. Requests are not timed out, as large blobs may take long.
+func (o *cmdImageOptions) newTransferClient(reg *data.Registry) (*registry.Client, error) {
+	return o.registryClient(reg, 0)
+}
+
+// registryClient creates a registry client with the timeout of each request, 0 means no timeout.
+func (o *cmdImageOptions) registryClient(reg *data.Registry, timeout time.Duration) (*registry.Client, error) {
+	user, password, err := contextCredentials(reg)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Execute API call.
-	resp, err = client.
-		Verb("DELETE").
-		Do()
-	if err != nil {
-		return err
+	return registry.NewClient(withCredentials(&rest.ClientConfig{
+		Host:            reg.Server,
+		TLSClientConfig: tlsClientConfig(reg),
+		Timeout:         timeout,
+		TokenCache:      o.tokens,
+	}, user, password))
+}
+
+// imageReference formats the reference of an image, e.g. 'golang:1.17' or 'golang@sha256:...'.
+func imageReference(name, reference string) string {
+	if strings.HasPrefix(reference, "sha256:") {
+		return name + "@" + reference
 	}
-	resp.Body.Close()
+	return name + ":" + reference
+}
 
-	if strings.Contains(strings.ToLower(fmt.Sprintf("%v", resp)), termDelSuccess) {
-		o.Streams.Out.Write([]byte(fmt.Sprintf("image %s:%s is deleted\n", name, tag)))
+// tlsClientConfig returns the TLS settings of the context, or nil if the context has none.
+func tlsClientConfig(reg *data.Registry) *rest.TLSClientConfig {
+	if !reg.InsecureSkipTLSVerify && len(reg.CAFile) == 0 && len(reg.CertFile) == 0 && len(reg.KeyFile) == 0 {
 		return nil
-	} else {
-		return errors.Errorf(
-			"unable to perform deletion on %s:%s, the manifest is either not found or has been deleted already\n",
-			name, tag)
+	}
+
+	return &rest.TLSClientConfig{
+		Insecure: reg.InsecureSkipTLSVerify,
+		CAFile:   reg.CAFile,
+		CertFile: reg.CertFile,
+		KeyFile:  reg.KeyFile,
 	}
 }
@@ -0,0 +1,153 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"strings"
+)
+
+// imageLocation is an image of a context, given as '<context>/<repository>:<tag>' or
+// '<context>/<repository>@<digest>'.
+type imageLocation struct {
+	context   string
+	name      string
+	reference string
+}
+
+// String implements fmt.Stringer.
+func (l imageLocation) String() string {
+	return l.context + "/" + imageReference(l.name, l.reference)
+}
+
+// parseImageLocation parses an image of a context. The reference may be omitted, e.g. for the
+// destination of a copy which keeps the tag of the source.
+func parseImageLocation(s string) (*imageLocation, error) {
+	i := strings.Index(s, "/")
+	if i <= 0 || i == len(s)-1 {
+		return nil, errors.Errorf("invalid image %q, expected <context>/<repository>:<tag>", s)
+	}
+
+	l := &imageLocation{context: s[:i], name: s[i+1:]}
+	if j := strings.LastIndex(l.name, "@"); j >= 0 {
+		l.name, l.reference = l.name[:j], l.name[j+1:]
+		if !strings.HasPrefix(l.reference, "sha256:") {
+			return nil, errors.Errorf("invalid image %q, %q is not a sha256 digest", s, l.reference)
+		}
+	} else if j := strings.LastIndex(l.name, ":"); j > strings.LastIndex(l.name, "/") {
+		l.name, l.reference = l.name[:j], l.name[j+1:]
+		if len(l.reference) == 0 {
+			return nil, errors.Errorf("invalid image %q, tag is empty", s)
+		}
+	}
+
+	if len(l.name) == 0 {
+		return nil, errors.Errorf("invalid image %q, repository is empty", s)
+	}
+	return l, nil
+}
+
+// copyCmdRun copies an image from a registry to another one, or within a registry, streaming
+// the blobs the destination does not hold yet. The digest of the manifest is preserved.
+func (o *cmdImageOptions) copyCmdRun(cmd *cobra.Command, args []string) error {
+	// Verify arguments.
+	if len(args) != 2 {
+		return errors.New("source and destination are not specified, " +
+			"please give them as <context>/<repository>:<tag>")
+	}
+
+	src, err := parseImageLocation(args[0])
+	if err != nil {
+		return err
+	}
+	if len(src.reference) == 0 {
+		return errors.Errorf("tag or digest of %s is not specified", args[0])
+	}
+
+	dst, err := parseImageLocation(args[1])
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(dst.reference, "sha256:") {
+		return errors.Errorf("%s is a digest, the digest of the source is preserved, please give a tag", dst.reference)
+	}
+
+	// The destination keeps the tag of the source, a source given by digest is copied untagged.
+	tag := dst.reference
+	if len(tag) == 0 && !strings.HasPrefix(src.reference, "sha256:") {
+		tag = src.reference
+	}
+
+	srcReg, err := o.contextOf(src.context)
+	if err != nil {
+		return err
+	}
+
+	dstReg, err := o.contextOf(dst.context)
+	if err != nil {
+		return err
+	}
+
+	srcClient, err := o.newTransferClient(srcReg)
+	if err != nil {
+		return err
+	}
+
+	dstClient, err := o.newTransferClient(dstReg)
+	if err != nil {
+		return err
+	}
+
+	manifest, err := srcClient.GetManifest(src.name, src.reference)
+	if err != nil {
+		return explainRegistryError(err, src.String())
+	}
+
+	// Blobs are mounted from the source repository when both are on the same registry.
+	target := dstClient.Repository(dst.name)
+	if registryHost(srcReg.Server) == registryHost(dstReg.Server) {
+		target.MountFrom(src.name)
+	}
+
+	err = registry.Copy(srcClient.Repository(src.name), target, manifest, tag, o.progress(map[registry.BlobStatus]string{
+		registry.BlobCopied:  "Copied",
+		registry.BlobExists:  "Already exists",
+		registry.BlobMounted: "Mounted from " + src.name,
+	}))
+	if err != nil {
+		return explainRegistryError(err, dst.String())
+	}
+
+	// Make sure the registry stored the manifest as it is.
+	reference := tag
+	if len(reference) == 0 {
+		reference = manifest.Digest
+	}
+	desc, err := dstClient.HeadManifest(dst.name, reference)
+	if err != nil {
+		return explainRegistryError(err, dst.String())
+	}
+	if desc.Digest != manifest.Digest {
+		return errors.Errorf("digest of %s is %s, expected %s of the source",
+			imageReference(dst.name, reference), desc.Digest, manifest.Digest)
+	}
+
+	dst.reference = reference
+	o.Streams.Out.Write([]byte(fmt.Sprintf("Digest: %s\nImage %s is copied to %s\n", manifest.Digest, src, dst)))
+	return nil
+}
+
+// contextOf returns the context with the name, which must exist.
+func (o *cmdImageOptions) contextOf(name string) (*data.Registry, error) {
+	reg, err := o.GetContext(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if reg == nil {
+		return nil, errors.Errorf("context %q not found, please add it with 'regi ctx add' first", name)
+	}
+	return reg, nil
+}
@@ -0,0 +1,92 @@
+package command
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParseImageLocation(t *testing.T) {
+	for s, expected := range map[string]*imageLocation{
+		"staging/hello-world:latest":     {context: "staging", name: "hello-world", reference: "latest"},
+		"staging/library/hello-world":    {context: "staging", name: "library/hello-world"},
+		"staging/hello-world@sha256:abc": {context: "staging", name: "hello-world", reference: "sha256:abc"},
+	} {
+		l, err := parseImageLocation(s)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, l)
+	}
+
+	for _, s := range []string{"hello-world:latest", "/hello-world", "staging/", "staging/hello-world:", "staging/hello@latest"} {
+		_, err := parseImageLocation(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestCmdImageCopy(t *testing.T) {
+	staging := registrytest.NewServer(t)
+	digest := staging.AddIndex("hello-world", "v1", "linux/amd64", "linux/arm64")
+	prod := registrytest.NewServer(t)
+	useTestRegistry(t, staging)
+	addTestContext(t, "prod", prod)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// Between registries, keeping the tag and the digest.
+	_, err := executeCommand(NewCmdImage(streams), "copy", "test/hello-world:v1", "prod/library/hello-world")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"v1": digest}, prod.Tags("library/hello-world"))
+	assert.Equal(t, 4, strings.Count(out.String(), ": Copied\n"))
+	assert.Contains(t, out.String(), "Image test/hello-world:v1 is copied to prod/library/hello-world:v1")
+	assert.Equal(t, 4, prod.Uploaded())
+
+	// Blobs already there are skipped.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "cp", "test/hello-world:v1", "prod/library/hello-world:stable")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"v1": digest, "stable": digest}, prod.Tags("library/hello-world"))
+	assert.Equal(t, 4, strings.Count(out.String(), ": Already exists\n"))
+	assert.Equal(t, 4, prod.Uploaded())
+
+	// Within a registry, blobs are mounted.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "copy", "test/hello-world@"+digest, "test/promoted:v1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"v1": digest}, staging.Tags("promoted"))
+	assert.Equal(t, 4, strings.Count(out.String(), ": Mounted from hello-world\n"))
+	assert.Equal(t, 4, staging.Mounted())
+	assert.Equal(t, 0, staging.Uploaded())
+}
+
+func TestCmdImageCopyErrors(t *testing.T) {
+	staging := registrytest.NewServer(t)
+	staging.AddIndex("hello-world", "v1", "linux/amd64")
+	useTestRegistry(t, staging)
+
+	o := NewCmdImageOptions(mustOpenDB(t), io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr})
+
+	err := o.copyCmdRun(nil, []string{"test/hello-world:v1", "prod/hello-world:v1"})
+	assert.EqualError(t, err, `context "prod" not found, please add it with 'regi ctx add' first`)
+
+	err = o.copyCmdRun(nil, []string{"test/hello-world", "test/other:v1"})
+	assert.EqualError(t, err, "tag or digest of test/hello-world is not specified")
+
+	err = o.copyCmdRun(nil, []string{"test/hello-world:v2", "test/other:v1"})
+	assert.Equal(t, exitCodeNotFound, exitCode(err))
+}
+
+// addTestContext adds a context for the test registry.
+func addTestContext(t *testing.T, name string, server *registrytest.Server) {
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&data.Registry{Name: name, Server: server.URL})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
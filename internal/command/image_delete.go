@@ -0,0 +1,413 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// deleteTarget is a manifest to delete, with the tags pointing to it which were selected.
+type deleteTarget struct {
+	digest    string
+	mediaType string
+	tags      []string
+
+	// others are the tags pointing to the manifest which were not selected, deleted along.
+	others []string
+}
+
+// describe formats the target within the repository, e.g. 'golang:1.17, golang:latest' or
+// 'golang@sha256:...'.
+func (t *deleteTarget) describe(name string) string {
+	if len(t.tags) == 0 {
+		return imageReference(name, t.digest)
+	}
+
+	var refs []string
+	for _, tag := range t.tags {
+		refs = append(refs, imageReference(name, tag))
+	}
+	return strings.Join(refs, ", ")
+}
+
+// deleteSelection selects the manifests to delete in a repository.
+type deleteSelection struct {
+	targets []*deleteTarget
+
+	// failures are the references which could not be resolved, e.g. an unknown tag.
+	failures []error
+
+	// bulk tells if more than exactly one tag or digest is selected, which needs confirmation.
+	bulk bool
+}
+
+// isTagPattern tells if a tag argument is a glob pattern, e.g. '1.0.*'.
+func isTagPattern(ref string) bool {
+	return strings.ContainsAny(ref, "*?[")
+}
+
+// delCmdRun deletes images from remote registry, given by tags, tag patterns or digests, or
+// all the tags of the repository.
+func (o *cmdImageOptions) delCmdRun(cmd *cobra.Command, args []string) error {
+	allTags, err := cmd.Flags().GetBool("all-tags")
+	if err != nil {
+		return err
+	}
+
+	// Verify arguments.
+	if len(args) == 0 {
+		return errors.New("image and tag is not specified")
+	}
+
+	if len(args) == 1 && !allTags {
+		return errors.New("image tag is not specified, give tags, patterns or digests, or use --all-tags")
+	}
+
+	if len(args) > 1 && allTags {
+		return errors.New("tags can not be given together with --all-tags")
+	}
+
+	name := args[0]
+
+	recursive, err := cmd.Flags().GetBool("recursive")
+	if err != nil {
+		return err
+	}
+
+	useRegex, err := cmd.Flags().GetBool("regex")
+	if err != nil {
+		return err
+	}
+
+	yes, err := cmd.Flags().GetBool("yes")
+	if err != nil {
+		return err
+	}
+
+	client, err := o.currentRegistryClient()
+	if err != nil {
+		return err
+	}
+
+	selection, err := selectDeleteTargets(client, name, args[1:], allTags, useRegex)
+	if err != nil {
+		return err
+	}
+
+	if !selection.bulk && len(selection.failures) > 0 {
+		return selection.failures[0]
+	}
+
+	if selection.bulk {
+		for _, err := range selection.failures {
+			o.Streams.Out.Write([]byte(fmt.Sprintf("skipped: %s\n", err)))
+		}
+
+		if len(selection.targets) == 0 {
+			return errors.Errorf("nothing to delete in repository %q", name)
+		}
+	}
+
+	referenced, err := findSharedManifests(client, name, selection.targets, recursive)
+	if err != nil {
+		return err
+	}
+
+	// A single tag or digest is deleted as it is, without report, unless other tags go along.
+	if !selection.bulk {
+		t := selection.targets[0]
+		if len(t.others) > 0 && !yes {
+			if ok, err := o.confirmDelete(name, selection.targets); err != nil || !ok {
+				return err
+			}
+		}
+		return o.deleteTarget(client, name, t, recursive, referenced)
+	}
+
+	if !yes {
+		if ok, err := o.confirmDelete(name, selection.targets); err != nil || !ok {
+			return err
+		}
+	}
+
+	// Go on with the other manifests when one fails, and report all of them.
+	var failed []error
+	for _, t := range selection.targets {
+		if err := o.deleteTarget(client, name, t, recursive, referenced); err != nil {
+			o.Streams.Out.Write([]byte(fmt.Sprintf("failed: %s\n", err)))
+			failed = append(failed, err)
+		}
+	}
+
+	o.Streams.Out.Write([]byte(fmt.Sprintf("\n%d deleted, %d failed, %d skipped\n",
+		len(selection.targets)-len(failed), len(failed), len(selection.failures))))
+
+	// The exit code tells the first failure.
+	failed = append(selection.failures, failed...)
+	if len(failed) > 0 {
+		return newCmdError(failed[0], "%d of %d items failed to delete", len(failed),
+			len(selection.targets)+len(selection.failures))
+	}
+	return nil
+}
+
+// selectDeleteTargets resolves tags, tag patterns and digests to the manifests to delete.
+// Tags pointing to the same manifest are grouped, as deleting it deletes all of them.
+func selectDeleteTargets(client *registry.Client, name string, refs []string, allTags, useRegex bool) (*deleteSelection, error) {
+	selection := &deleteSelection{bulk: allTags || len(refs) > 1}
+
+	// Expand the patterns against the tags of the repository.
+	var tags []string
+	var resolved []string
+	for _, ref := range refs {
+		if strings.HasPrefix(ref, "sha256:") || (!useRegex && !isTagPattern(ref)) {
+			resolved = append(resolved, ref)
+			continue
+		}
+		selection.bulk = true
+
+		if tags == nil {
+			var err error
+			if tags, err = client.Tags(name, 0); err != nil {
+				return nil, explainRegistryError(err, "tags of "+name)
+			}
+		}
+
+		matched, err := matchTags(tags, ref, useRegex)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			selection.failures = append(selection.failures, errors.Errorf("no tag of %s matches %q", name, ref))
+		}
+		resolved = append(resolved, matched...)
+	}
+
+	if allTags {
+		var err error
+		if resolved, err = client.Tags(name, 0); err != nil {
+			return nil, explainRegistryError(err, "tags of "+name)
+		}
+	}
+
+	byDigest := map[string]*deleteTarget{}
+	seen := map[string]bool{}
+	for _, ref := range resolved {
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+
+		desc, err := client.HeadManifest(name, ref)
+		if err != nil {
+			if isNotFound(err) && !strings.HasPrefix(ref, "sha256:") {
+				err = newCmdError(err, "unable to delete %s:%s, tag %q not found in repository %q", name, ref, ref, name)
+			} else {
+				err = explainRegistryError(err, imageReference(name, ref))
+			}
+			selection.failures = append(selection.failures, err)
+			continue
+		}
+
+		t, ok := byDigest[desc.Digest]
+		if !ok {
+			t = &deleteTarget{digest: desc.Digest, mediaType: desc.MediaType}
+			byDigest[desc.Digest] = t
+			selection.targets = append(selection.targets, t)
+		}
+		if !strings.HasPrefix(ref, "sha256:") {
+			t.tags = append(t.tags, ref)
+		}
+	}
+	return selection, nil
+}
+
+// findSharedManifests finds the tags which were not selected but point to the manifests to
+// delete, as deleting a manifest deletes all its tags. If recursive, it returns the manifests
+// which the tags to keep refer to, directly or by their index, as those must not be deleted.
+func findSharedManifests(client *registry.Client, name string, targets []*deleteTarget, recursive bool) (map[string]bool, error) {
+	tags, err := client.Tags(name, 0)
+	if err != nil {
+		return nil, explainRegistryError(err, "tags of "+name)
+	}
+
+	byDigest := map[string]*deleteTarget{}
+	for _, t := range targets {
+		byDigest[t.digest] = t
+	}
+
+	referenced := map[string]bool{}
+	for _, tag := range tags {
+		desc, err := client.HeadManifest(name, tag)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, explainRegistryError(err, imageReference(name, tag))
+		}
+
+		if t, ok := byDigest[desc.Digest]; ok {
+			if !containsString(t.tags, tag) {
+				t.others = append(t.others, tag)
+			}
+			continue
+		}
+
+		if !recursive {
+			continue
+		}
+		referenced[desc.Digest] = true
+		if !registry.IsIndexMediaType(desc.MediaType) {
+			continue
+		}
+
+		raw, err := client.GetManifest(name, desc.Digest)
+		if err != nil {
+			return nil, explainRegistryError(err, imageReference(name, tag))
+		}
+		index, err := raw.Index()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range index.Manifests {
+			referenced[m.Digest] = true
+		}
+	}
+	return referenced, nil
+}
+
+// confirmDelete lists the manifests to delete with all their tags, and asks to go on.
+func (o *cmdImageOptions) confirmDelete(name string, targets []*deleteTarget) (bool, error) {
+	o.Streams.Out.Write([]byte(fmt.Sprintf("The following manifests of %s will be deleted:\n", name)))
+	for _, t := range targets {
+		o.Streams.Out.Write([]byte(fmt.Sprintf("- %s (%s)\n", t.describe(name), t.digest)))
+		if len(t.others) > 0 {
+			var refs []string
+			for _, tag := range t.others {
+				refs = append(refs, imageReference(name, tag))
+			}
+			o.Streams.Out.Write([]byte(fmt.Sprintf("  with %s, which point to the same manifest\n",
+				strings.Join(refs, ", "))))
+		}
+	}
+
+	ok, err := confirm(o.Streams, "Do you want to continue?")
+	if err != nil {
+		return false, err
+	}
+	if !ok {
+		o.Streams.Out.Write([]byte("Deletion is cancelled.\n"))
+	}
+	return ok, nil
+}
+
+// containsString tells if the list holds the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTags returns the tags matching a glob pattern, or a regex.
+func matchTags(tags []string, pattern string, useRegex bool) ([]string, error) {
+	var re *regexp.Regexp
+	if useRegex {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return nil, errors.Wrapf(err, "invalid regex %q", pattern)
+		}
+	}
+
+	var matched []string
+	for _, tag := range tags {
+		if re != nil {
+			if re.MatchString(tag) {
+				matched = append(matched, tag)
+			}
+			continue
+		}
+
+		ok, err := path.Match(pattern, tag)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid pattern %q", pattern)
+		}
+		if ok {
+			matched = append(matched, tag)
+		}
+	}
+	return matched, nil
+}
+
+// deleteTarget deletes a manifest, and the platform manifests of an index if recursive, but
+// those referenced by manifests which are kept.
+func (o *cmdImageOptions) deleteTarget(client *registry.Client, name string, t *deleteTarget, recursive bool,
+	referenced map[string]bool) error {
+	subject := t.describe(name)
+
+	// The platform manifests of an index must be known before the index is gone.
+	isIndex := registry.IsIndexMediaType(t.mediaType)
+	var platforms []registry.Descriptor
+	if isIndex && recursive {
+		raw, err := client.GetManifest(name, t.digest)
+		if err != nil {
+			return explainRegistryError(err, subject)
+		}
+
+		index, err := raw.Index()
+		if err != nil {
+			return err
+		}
+		platforms = index.Manifests
+	}
+
+	// Delete the manifest by digest, which deletes all the tags pointing to it.
+	if err := client.DeleteManifest(name, t.digest); err != nil {
+		if isUnsupported(err) {
+			return newCmdError(err, "unable to delete %s, deletion is disabled on the registry (UNSUPPORTED)", subject)
+		}
+		return explainRegistryError(err, subject)
+	}
+
+	if len(t.tags) == 0 {
+		o.Streams.Out.Write([]byte(fmt.Sprintf("manifest %s is deleted\n", subject)))
+	}
+	for _, tag := range append(t.tags, t.others...) {
+		o.Streams.Out.Write([]byte(fmt.Sprintf("image %s:%s is deleted\n", name, tag)))
+	}
+
+	// Delete the platform manifests of the index. Those already gone are skipped.
+	for _, m := range platforms {
+		platform := "unknown platform"
+		if m.Platform != nil {
+			platform = m.Platform.String()
+		}
+
+		if referenced[m.Digest] {
+			o.Streams.Out.Write([]byte(fmt.Sprintf("manifest %s for %s is kept, other tags refer to it\n", m.Digest, platform)))
+			continue
+		}
+
+		if err := client.DeleteManifest(name, m.Digest); err != nil {
+			if isNotFound(err) {
+				continue
+			}
+			return explainRegistryError(err, imageReference(name, m.Digest))
+		}
+		o.Streams.Out.Write([]byte(fmt.Sprintf("manifest %s for %s is deleted\n", m.Digest, platform)))
+	}
+
+	if isIndex && !recursive {
+		o.Streams.Out.Write([]byte(fmt.Sprintf(
+			"%s is a multi-platform index, its platform manifests are kept, use --recursive to delete them as well\n",
+			subject)))
+	}
+
+	return nil
+}
@@ -0,0 +1,163 @@
+package command
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCmdImageDeleteBulk(t *testing.T) {
+	server := registrytest.NewServer(t)
+	for _, tag := range []string{"1.0.0", "1.0.1", "1.1.0", "2.0.0"} {
+		server.AddImage("app", tag, []byte(`{"os":"linux"}`), []byte("layer "+tag))
+	}
+	latest := server.AddImage("app", "latest", []byte(`{"os":"linux"}`), []byte("layer 2.0.0"))
+	dev := server.AddImage("app", "dev", []byte(`{"os":"linux"}`), []byte("layer dev"))
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: strings.NewReader("n\n"), Out: out, ErrOut: os.Stderr}
+
+	// Declined confirmation.
+	_, err := executeCommand(NewCmdImage(streams), "delete", "app", "1.0.*")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "- app:1.0.0 (sha256:")
+	assert.Contains(t, out.String(), "Do you want to continue? [y/N]: Deletion is cancelled.")
+	assert.Equal(t, 6, len(server.Tags("app")))
+
+	// Glob pattern, confirmed.
+	out.Reset()
+	streams.In = strings.NewReader("y\n")
+	_, err = executeCommand(NewCmdImage(streams), "delete", "app", "1.0.*")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "image app:1.0.0 is deleted")
+	assert.Contains(t, out.String(), "image app:1.0.1 is deleted")
+	assert.Contains(t, out.String(), "2 deleted, 0 failed, 0 skipped")
+	assert.Equal(t, 4, len(server.Tags("app")))
+
+	// Tags and digest, some of them unknown. Tags of the same manifest are deleted together.
+	out.Reset()
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	delCmd, _, err := NewCmdImage(streams).Find([]string{"delete"})
+	assert.NoError(t, err)
+	assert.NoError(t, delCmd.Flags().Set("yes", "true"))
+	err = o.delCmdRun(delCmd, []string{"app", "2.0.0", "unknown", dev})
+	assert.EqualError(t, err, "1 of 3 items failed to delete")
+	assert.Equal(t, exitCodeNotFound, exitCode(err))
+	assert.Contains(t, out.String(), `skipped: unable to delete app:unknown, tag "unknown" not found in repository "app"`)
+	assert.Contains(t, out.String(), "image app:2.0.0 is deleted")
+	assert.Contains(t, out.String(), "image app:latest is deleted")
+	assert.Contains(t, out.String(), "manifest app@"+dev+" is deleted")
+	assert.Contains(t, out.String(), "2 deleted, 0 failed, 1 skipped")
+	assert.False(t, server.HasManifest("app", latest))
+	assert.Equal(t, []string{"1.1.0"}, keys(server.Tags("app")))
+
+	// All the tags.
+	server.AddImage("app", "2.1.0", []byte(`{"os":"linux"}`), []byte("layer 2.1.0"))
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "delete", "app", "--all-tags", "-y")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "2 deleted, 0 failed, 0 skipped")
+	assert.Empty(t, server.Tags("app"))
+}
+
+func TestCmdImageDeleteRegex(t *testing.T) {
+	server := registrytest.NewServer(t)
+	for _, tag := range []string{"pr-1", "pr-2", "main"} {
+		server.AddImage("app", tag, []byte(`{"os":"linux"}`), []byte("layer "+tag))
+	}
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdImage(streams), "delete", "app", "^pr-[0-9]+$", "--regex", "--yes")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"main"}, keys(server.Tags("app")))
+
+	// Deletion disabled, every failure is reported.
+	server.AddImage("app", "pr-3", []byte(`{"os":"linux"}`), []byte("layer pr-3"))
+	server.DeleteDisabled = true
+	out.Reset()
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	delCmd, _, err := NewCmdImage(streams).Find([]string{"delete"})
+	assert.NoError(t, err)
+	assert.NoError(t, delCmd.Flags().Set("all-tags", "true"))
+	assert.NoError(t, delCmd.Flags().Set("yes", "true"))
+	err = o.delCmdRun(delCmd, []string{"app"})
+	assert.EqualError(t, err, "2 of 2 items failed to delete")
+	assert.Equal(t, exitCodeUnsupported, exitCode(err))
+	assert.Equal(t, 2, strings.Count(out.String(), "failed: unable to delete app:"))
+
+	assert.EqualError(t, o.delCmdRun(delCmd, []string{"app", "main"}), "tags can not be given together with --all-tags")
+}
+
+func TestCmdImageDeleteSharedTags(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.AddImage("app", "1.0", []byte(`{"os":"linux"}`), []byte("layer 1.0"))
+	server.AddImage("app", "latest", []byte(`{"os":"linux"}`), []byte("layer 1.0"))
+	server.AddImage("app", "dev", []byte(`{"os":"linux"}`), []byte("layer dev"))
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: strings.NewReader("n\n"), Out: out, ErrOut: os.Stderr}
+
+	// The tags deleted along are shown, and must be confirmed.
+	_, err := executeCommand(NewCmdImage(streams), "delete", "app", "1.0")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "- app:1.0 (sha256:")
+	assert.Contains(t, out.String(), "  with app:latest, which point to the same manifest\n")
+	assert.Contains(t, out.String(), "Deletion is cancelled.")
+	assert.Equal(t, 3, len(server.Tags("app")))
+
+	out.Reset()
+	streams.In = strings.NewReader("y\n")
+	_, err = executeCommand(NewCmdImage(streams), "delete", "app", "1.0")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "image app:1.0 is deleted")
+	assert.Contains(t, out.String(), "image app:latest is deleted")
+	assert.Equal(t, []string{"dev"}, keys(server.Tags("app")))
+
+	// A tag of its own is deleted without confirmation.
+	out.Reset()
+	streams.In = strings.NewReader("")
+	_, err = executeCommand(NewCmdImage(streams), "delete", "app", "dev")
+	assert.NoError(t, err)
+	assert.NotContains(t, out.String(), "Do you want to continue?")
+	assert.Empty(t, server.Tags("app"))
+}
+
+func TestCmdImageDeleteSharedPlatforms(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.AddIndex("app", "kept", "linux/amd64", "linux/arm64")
+	server.AddIndex("app", "old", "linux/amd64", "linux/arm/v7")
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// The platform manifests another index refers to are kept.
+	_, err := executeCommand(NewCmdImage(streams), "delete", "app", "old", "--recursive")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "for linux/amd64 is kept, other tags refer to it")
+	assert.Contains(t, out.String(), "for linux/arm/v7 is deleted")
+
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "delete", "app", "kept", "--recursive")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "for linux/amd64 is deleted")
+	assert.Contains(t, out.String(), "for linux/arm64 is deleted")
+}
+
+// keys returns the keys of a map.
+func keys(m map[string]string) []string {
+	var k []string
+	for key := range m {
+		k = append(k, key)
+	}
+	return k
+}
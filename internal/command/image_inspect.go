@@ -0,0 +1,248 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/output"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// layerInfo is the output of an image layer.
+type layerInfo struct {
+	Digest    string `json:"digest" yaml:"digest"`
+	MediaType string `json:"mediaType" yaml:"mediaType"`
+	Size      int64  `json:"size" yaml:"size"`
+}
+
+// platformInfo is the output of a platform specific manifest of an index.
+type platformInfo struct {
+	Platform  string `json:"platform" yaml:"platform"`
+	Digest    string `json:"digest" yaml:"digest"`
+	MediaType string `json:"mediaType" yaml:"mediaType"`
+	Size      int64  `json:"size" yaml:"size"`
+}
+
+// imageInspect is the output of `image inspect`.
+type imageInspect struct {
+	Name       string            `json:"name" yaml:"name"`
+	Digest     string            `json:"digest" yaml:"digest"`
+	MediaType  string            `json:"mediaType" yaml:"mediaType"`
+	Platform   string            `json:"platform,omitempty" yaml:"platform,omitempty"`
+	Created    *time.Time        `json:"created,omitempty" yaml:"created,omitempty"`
+	Entrypoint []string          `json:"entrypoint,omitempty" yaml:"entrypoint,omitempty"`
+	Cmd        []string          `json:"cmd,omitempty" yaml:"cmd,omitempty"`
+	Env        []string          `json:"env,omitempty" yaml:"env,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Layers     []layerInfo       `json:"layers,omitempty" yaml:"layers,omitempty"`
+	Manifests  []platformInfo    `json:"manifests,omitempty" yaml:"manifests,omitempty"`
+}
+
+// Header implements output.Table. An index is printed as its platform manifests, an image as its layers.
+func (i imageInspect) Header() []string {
+	if len(i.Manifests) > 0 {
+		return []string{"PLATFORM", "DIGEST", "MEDIA TYPE", "SIZE"}
+	}
+	return []string{"DIGEST", "MEDIA TYPE", "SIZE"}
+}
+
+// Rows implements output.Table.
+func (i imageInspect) Rows() [][]string {
+	var rows [][]string
+	for _, m := range i.Manifests {
+		rows = append(rows, []string{m.Platform, m.Digest, m.MediaType, strconv.FormatInt(m.Size, 10)})
+	}
+	for _, l := range i.Layers {
+		rows = append(rows, []string{l.Digest, l.MediaType, strconv.FormatInt(l.Size, 10)})
+	}
+	return rows
+}
+
+// inspectCmdRun shows the manifest, config and layers of an image, or the platforms of an index
+// unless a platform is selected.
+func (o *cmdImageOptions) inspectCmdRun(cmd *cobra.Command, args []string) error {
+	// Verify arguments.
+	if len(args) == 0 {
+		return errors.New("image and tag is not specified")
+	}
+
+	if len(args) == 1 {
+		return errors.New("image tag or digest is not specified")
+	}
+
+	name := args[0]
+	ref := args[1]
+
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
+	raw, err := cmd.Flags().GetBool("raw")
+	if err != nil {
+		return err
+	}
+
+	platform, err := cmd.Flags().GetString("platform")
+	if err != nil {
+		return err
+	}
+
+	client, err := o.currentRegistryClient()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := client.GetManifest(name, ref)
+	if err != nil {
+		return explainRegistryError(err, imageReference(name, ref))
+	}
+
+	// Pick the image of the platform out of an index.
+	if len(platform) > 0 {
+		wanted, err := registry.ParsePlatform(platform)
+		if err != nil {
+			return err
+		}
+
+		manifest, err = client.ResolvePlatform(name, manifest, *wanted)
+		if err != nil {
+			return explainRegistryError(err, imageReference(name, ref))
+		}
+	}
+
+	result := imageInspect{
+		Name:      imageReference(name, ref),
+		Digest:    manifest.Digest,
+		MediaType: manifest.MediaType,
+	}
+
+	// An index has no config, list the platforms it points to.
+	if manifest.IsIndex() {
+		if raw {
+			return o.writeRaw(manifest.Body)
+		}
+
+		index, err := manifest.Index()
+		if err != nil {
+			return err
+		}
+
+		for _, m := range index.Manifests {
+			platform := "unknown"
+			if m.Platform != nil {
+				platform = m.Platform.String()
+			}
+			result.Manifests = append(result.Manifests, platformInfo{
+				Platform:  platform,
+				Digest:    m.Digest,
+				MediaType: m.MediaType,
+				Size:      m.Size,
+			})
+		}
+		return o.printInspect(format, result)
+	}
+
+	image, err := manifest.Manifest()
+	if err != nil {
+		return err
+	}
+
+	configBlob, err := client.ReadBlob(name, image.Config.Digest)
+	if err != nil {
+		return explainRegistryError(err, fmt.Sprintf("config of %s", imageReference(name, ref)))
+	}
+
+	if raw {
+		return o.writeRaw(manifest.Body, configBlob)
+	}
+
+	var config registry.ImageConfig
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		return errors.Wrapf(err, "unable to decode image config %s", image.Config.Digest)
+	}
+
+	result.Platform = config.Platform().String()
+	result.Created = config.Created
+	result.Entrypoint = config.Config.Entrypoint
+	result.Cmd = config.Config.Cmd
+	result.Env = config.Config.Env
+	result.Labels = config.Config.Labels
+	result.Layers = []layerInfo{}
+	for _, l := range image.Layers {
+		result.Layers = append(result.Layers, layerInfo{Digest: l.Digest, MediaType: l.MediaType, Size: l.Size})
+	}
+
+	return o.printInspect(format, result)
+}
+
+// writeRaw writes JSON documents as they are, one after another.
+func (o *cmdImageOptions) writeRaw(docs ...[]byte) error {
+	for _, doc := range docs {
+		if _, err := o.Out.Write(append(doc, '\n')); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// printInspect prints the result of `image inspect`.
+func (o *cmdImageOptions) printInspect(format *output.Format, result imageInspect) error {
+	if !format.IsDefault() {
+		return format.Print(o.Out, result)
+	}
+
+	var b strings.Builder
+	b.WriteString(fmt.Sprintf("\nImage %q:\n", result.Name))
+	b.WriteString(fmt.Sprintf("- digest: %s\n", result.Digest))
+	b.WriteString(fmt.Sprintf("- media type: %s\n", result.MediaType))
+
+	if len(result.Manifests) > 0 {
+		b.WriteString("- platforms:\n")
+		for _, m := range result.Manifests {
+			b.WriteString(fmt.Sprintf("  - %s: %s (%d bytes)\n", m.Platform, m.Digest, m.Size))
+		}
+		o.Out.Write([]byte(b.String()))
+		return nil
+	}
+
+	created := ""
+	if result.Created != nil {
+		created = result.Created.Format(time.RFC3339)
+	}
+	b.WriteString(fmt.Sprintf("- platform: %s\n", result.Platform))
+	b.WriteString(fmt.Sprintf("- created: %s\n", created))
+	b.WriteString(fmt.Sprintf("- entrypoint: %s\n", strings.Join(result.Entrypoint, " ")))
+	b.WriteString(fmt.Sprintf("- cmd: %s\n", strings.Join(result.Cmd, " ")))
+
+	b.WriteString("- env:\n")
+	for _, env := range result.Env {
+		b.WriteString(fmt.Sprintf("  - %s\n", env))
+	}
+
+	b.WriteString("- labels:\n")
+	var keys []string
+	for k := range result.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		b.WriteString(fmt.Sprintf("  - %s=%s\n", k, result.Labels[k]))
+	}
+
+	b.WriteString("- layers:\n")
+	var total int64
+	for _, l := range result.Layers {
+		b.WriteString(fmt.Sprintf("  - %s (%d bytes)\n", l.Digest, l.Size))
+		total += l.Size
+	}
+	b.WriteString(fmt.Sprintf("- size: %d bytes\n", total))
+
+	o.Out.Write([]byte(b.String()))
+	return nil
+}
@@ -0,0 +1,116 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestCmdImageInspect(t *testing.T) {
+	server := registrytest.NewServer(t)
+	config := []byte(`{"created":"2022-03-01T10:00:00Z","architecture":"amd64","os":"linux",` +
+		`"config":{"Entrypoint":["/entrypoint.sh"],"Cmd":["/hello"],"Env":["PATH=/bin"],"Labels":{"maintainer":"regi"}}}`)
+	digest := server.AddImage("hello-world", "latest", config, []byte("layer-1"), []byte("layer-22"))
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// Default output.
+	_, err := executeCommand(NewCmdImage(streams), "inspect", "hello-world", "latest")
+	assert.NoError(t, err)
+	for _, s := range []string{
+		`Image "hello-world:latest":`,
+		"- digest: " + digest,
+		"- media type: " + registry.MediaTypeDockerManifest,
+		"- platform: linux/amd64",
+		"- created: 2022-03-01T10:00:00Z",
+		"- entrypoint: /entrypoint.sh",
+		"- cmd: /hello",
+		"  - PATH=/bin",
+		"  - maintainer=regi",
+		"  - " + registry.Digest([]byte("layer-22")) + " (8 bytes)",
+		"- size: 15 bytes",
+	} {
+		assert.Contains(t, out.String(), s)
+	}
+
+	// By digest, as JSON.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "image", "inspect", "hello-world", digest, "-o", "json")
+	assert.NoError(t, err)
+
+	var result imageInspect
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &result))
+	assert.Equal(t, "hello-world@"+digest, result.Name)
+	assert.Equal(t, []string{"/hello"}, result.Cmd)
+	assert.Equal(t, 2, len(result.Layers))
+	assert.Equal(t, int64(7), result.Layers[0].Size)
+
+	// Raw manifest and config.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "inspect", "hello-world", "latest", "--raw")
+	assert.NoError(t, err)
+
+	docs := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	assert.Equal(t, 2, len(docs))
+	assert.Equal(t, digest, registry.Digest([]byte(docs[0])))
+	assert.Equal(t, string(config), docs[1])
+
+	// Unknown tag.
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	inspectCmd, _, err := NewCmdImage(streams).Find([]string{"inspect"})
+	assert.NoError(t, err)
+	err = o.inspectCmdRun(inspectCmd, []string{"hello-world", "unknown"})
+	assert.Error(t, err)
+	assert.Equal(t, exitCodeNotFound, exitCode(err))
+}
+
+func TestCmdImageInspectIndex(t *testing.T) {
+	server := registrytest.NewServer(t)
+	amd64 := server.AddImage("hello-world", "", []byte(`{"architecture":"amd64","os":"linux"}`))
+	arm64 := server.AddImage("hello-world", "", []byte(`{"architecture":"arm64","os":"linux","variant":"v8"}`))
+	index := fmt.Sprintf(`{"schemaVersion":2,"mediaType":%q,"manifests":[`+
+		`{"mediaType":%q,"digest":%q,"size":100,"platform":{"architecture":"amd64","os":"linux"}},`+
+		`{"mediaType":%q,"digest":%q,"size":200,"platform":{"architecture":"arm64","os":"linux","variant":"v8"}}]}`,
+		registry.MediaTypeOCIIndex, registry.MediaTypeDockerManifest, amd64, registry.MediaTypeDockerManifest, arm64)
+	digest := server.AddManifest("hello-world", "latest", registry.MediaTypeOCIIndex, []byte(index))
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdImage(streams), "inspect", "hello-world", "latest")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "- digest: "+digest)
+	assert.Contains(t, out.String(), "- media type: "+registry.MediaTypeOCIIndex)
+	assert.Contains(t, out.String(), fmt.Sprintf("  - linux/amd64: %s (100 bytes)", amd64))
+	assert.Contains(t, out.String(), fmt.Sprintf("  - linux/arm64/v8: %s (200 bytes)", arm64))
+
+	// Selected platform.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "inspect", "hello-world", "latest", "--platform", "linux/arm64")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "- digest: "+arm64)
+	assert.Contains(t, out.String(), "- platform: linux/arm64/v8")
+
+	inspectCmd, _, err := NewCmdImage(streams).Find([]string{"inspect"})
+	assert.NoError(t, err)
+	assert.NoError(t, inspectCmd.Flags().Set("platform", "linux/s390x"))
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	err = o.inspectCmdRun(inspectCmd, []string{"hello-world", "latest"})
+	assert.EqualError(t, err, "platform linux/s390x not found, available platforms are: linux/amd64, linux/arm64/v8")
+
+	// Raw index.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "inspect", "hello-world", "latest", "--raw")
+	assert.NoError(t, err)
+	assert.Equal(t, index+"\n", out.String())
+}
@@ -0,0 +1,419 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/output"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// pruneActionKeep keeps a tag.
+	pruneActionKeep = "keep"
+
+	// pruneActionDelete deletes a tag.
+	pruneActionDelete = "delete"
+)
+
+// semverRegexp matches semantic versions, with or without the 'v' prefix, e.g. 'v1.2.3' or '1.2.3-rc.1'.
+var semverRegexp = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+// pruneItem is the output of a tag evaluated against the retention rules.
+type pruneItem struct {
+	Repository string     `json:"repository" yaml:"repository"`
+	Tag        string     `json:"tag" yaml:"tag"`
+	Digest     string     `json:"digest" yaml:"digest"`
+	Created    *time.Time `json:"created,omitempty" yaml:"created,omitempty"`
+	Action     string     `json:"action" yaml:"action"`
+	Reason     string     `json:"reason" yaml:"reason"`
+	Error      string     `json:"error,omitempty" yaml:"error,omitempty"`
+
+	// children are the platform manifests of an index, which the tag refers to as well.
+	children []string
+}
+
+// pruneReport is the output of `image prune`.
+type pruneReport struct {
+	DryRun  bool        `json:"dryRun" yaml:"dryRun"`
+	Kept    int         `json:"kept" yaml:"kept"`
+	Deleted int         `json:"deleted" yaml:"deleted"`
+	Failed  int         `json:"failed" yaml:"failed"`
+	Items   []pruneItem `json:"items" yaml:"items"`
+}
+
+// deletesIndex tells if any of the tags to delete is an index, whose platform manifests are not
+// deleted along.
+func (r pruneReport) deletesIndex() bool {
+	for _, item := range r.Items {
+		if item.Action == pruneActionDelete && len(item.Error) == 0 && len(item.children) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Header implements output.Table.
+func (r pruneReport) Header() []string {
+	return []string{"REPOSITORY", "TAG", "CREATED", "ACTION", "REASON", "ERROR"}
+}
+
+// Rows implements output.Table.
+func (r pruneReport) Rows() [][]string {
+	var rows [][]string
+	for _, item := range r.Items {
+		created := "unknown"
+		if item.Created != nil {
+			created = item.Created.Format(time.RFC3339)
+		}
+		rows = append(rows, []string{item.Repository, item.Tag, created, item.Action, item.Reason, item.Error})
+	}
+	return rows
+}
+
+// retention holds the retention rules of `image prune`.
+type retention struct {
+	// keepLast keeps the given number of newest tags, 0 means no such rule.
+	keepLast int
+
+	// keepSemver keeps tags which are semantic versions.
+	keepSemver bool
+
+	// keep keeps tags matching any of the regexes.
+	keep []*regexp.Regexp
+
+	// olderThan deletes only the tags older than the duration, 0 means no such rule.
+	olderThan time.Duration
+}
+
+// newRetention reads the retention rules from the flags. At least one rule must tell which
+// tags to delete, so that a repository is never emptied by mistake.
+func newRetention(cmd *cobra.Command) (*retention, error) {
+	r := &retention{}
+
+	var err error
+	if r.keepLast, err = cmd.Flags().GetInt("keep-last"); err != nil {
+		return nil, err
+	}
+	if r.keepLast < 0 {
+		return nil, errors.Errorf("invalid --keep-last %d, it must not be negative", r.keepLast)
+	}
+
+	if r.keepSemver, err = cmd.Flags().GetBool("keep-semver"); err != nil {
+		return nil, err
+	}
+
+	exprs, err := cmd.Flags().GetStringArray("keep")
+	if err != nil {
+		return nil, err
+	}
+	for _, expr := range exprs {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid regex %q of --keep", expr)
+		}
+		r.keep = append(r.keep, re)
+	}
+
+	olderThan, err := cmd.Flags().GetString("older-than")
+	if err != nil {
+		return nil, err
+	}
+	if len(olderThan) > 0 {
+		if r.olderThan, err = parseAge(olderThan); err != nil {
+			return nil, err
+		}
+	}
+
+	if r.keepLast == 0 && r.olderThan == 0 {
+		return nil, errors.New("no retention rule given, please give --keep-last and/or --older-than")
+	}
+	return r, nil
+}
+
+// parseAge parses a duration which may be given in days as well, e.g. '30d' or '12h'.
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil && days > 0 {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	} else if d, err := time.ParseDuration(s); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, errors.Errorf("invalid duration %q, expected e.g. 30d or 12h", s)
+}
+
+// apply tells whether to keep or delete each tag of a repository, and why. Tags whose manifest
+// is referred to by a kept tag are kept, as deleting a manifest deletes all its tags. The newest
+// tags of --keep-last are counted among the tags which --keep and --keep-semver do not keep.
+func (r *retention) apply(items []pruneItem, now time.Time) {
+	// Newest first, tags of unknown creation time last.
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i].Created, items[j].Created
+		if a == nil || b == nil {
+			return a != nil
+		}
+		return a.After(*b)
+	})
+
+	newest := 0
+	for i := range items {
+		item := &items[i]
+		item.Action = pruneActionKeep
+		switch {
+		case r.matchKeep(item.Tag) != nil:
+			item.Reason = fmt.Sprintf("matches --keep %s", r.matchKeep(item.Tag))
+		case r.keepSemver && semverRegexp.MatchString(item.Tag):
+			item.Reason = "semantic version"
+		case item.Created == nil:
+			item.Reason = "creation time unknown"
+		case newest < r.keepLast:
+			newest++
+			item.Reason = fmt.Sprintf("one of the %d newest", r.keepLast)
+		case r.olderThan > 0 && now.Sub(*item.Created) < r.olderThan:
+			item.Reason = fmt.Sprintf("newer than %s", formatAge(r.olderThan))
+		default:
+			item.Action = pruneActionDelete
+			if r.olderThan > 0 {
+				item.Reason = fmt.Sprintf("older than %s", formatAge(r.olderThan))
+			} else {
+				item.Reason = fmt.Sprintf("not one of the %d newest", r.keepLast)
+			}
+		}
+	}
+
+	// A manifest referred to by a kept tag, directly or by its index, is never deleted.
+	referrers := map[string]string{}
+	for _, item := range items {
+		if item.Action == pruneActionKeep {
+			for _, digest := range append([]string{item.Digest}, item.children...) {
+				if _, ok := referrers[digest]; !ok {
+					referrers[digest] = item.Tag
+				}
+			}
+		}
+	}
+	for i := range items {
+		if tag, ok := referrers[items[i].Digest]; ok && items[i].Action == pruneActionDelete {
+			items[i].Action = pruneActionKeep
+			items[i].Reason = fmt.Sprintf("referenced by kept tag %s", tag)
+		}
+	}
+}
+
+// matchKeep returns the --keep regex matching the tag, if any.
+func (r *retention) matchKeep(tag string) *regexp.Regexp {
+	for _, re := range r.keep {
+		if re.MatchString(tag) {
+			return re
+		}
+	}
+	return nil
+}
+
+// formatAge formats a duration in days if it is a whole number of days, e.g. '30d'.
+func formatAge(d time.Duration) string {
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return d.String()
+}
+
+// pruneCmdRun deletes the tags of the repositories of the current registry, or of the whole
+// registry, which the retention rules do not keep.
+func (o *cmdImageOptions) pruneCmdRun(cmd *cobra.Command, args []string) error {
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	rules, err := newRetention(cmd)
+	if err != nil {
+		return err
+	}
+
+	client, err := o.currentRegistryClient()
+	if err != nil {
+		return err
+	}
+
+	repositories := args
+	if len(repositories) == 0 {
+		repositories, err = client.Catalog(0)
+		if err != nil {
+			return explainRegistryError(err, "the catalog")
+		}
+	}
+
+	// Go on with the other tags and repositories when one fails, and report all of them.
+	report := pruneReport{DryRun: dryRun, Items: []pruneItem{}}
+	var failed []error
+	now := time.Now()
+	for _, repo := range repositories {
+		items, err := pruneItems(client, repo)
+		if err != nil {
+			if format.IsDefault() {
+				o.Out.Write([]byte(fmt.Sprintf("failed: %s\n", err)))
+			}
+			report.Failed++
+			report.Items = append(report.Items, pruneItem{Repository: repo, Error: err.Error()})
+			failed = append(failed, err)
+			continue
+		}
+		rules.apply(items, now)
+
+		// A manifest is deleted once, whatever the number of its tags.
+		deleted := map[string]error{}
+		for _, item := range items {
+			if !dryRun && item.Action == pruneActionDelete {
+				err, done := deleted[item.Digest]
+				if !done {
+					err = o.pruneManifest(client, repo, item)
+					deleted[item.Digest] = err
+					if err != nil {
+						failed = append(failed, err)
+					}
+				}
+
+				if err != nil {
+					item.Error = err.Error()
+				}
+				if format.IsDefault() {
+					o.Out.Write([]byte(pruneMessage(item)))
+				}
+			}
+
+			switch {
+			case len(item.Error) > 0:
+				report.Failed++
+			case item.Action == pruneActionKeep:
+				report.Kept++
+			default:
+				report.Deleted++
+			}
+			report.Items = append(report.Items, item)
+		}
+	}
+
+	if !format.IsDefault() {
+		if err := format.Print(o.Out, report); err != nil {
+			return err
+		}
+	} else {
+		o.printPruneReport(report)
+	}
+
+	// The exit code tells the first failure.
+	if len(failed) > 0 {
+		return newCmdError(failed[0], "%d of %d items failed to prune", report.Failed, len(report.Items))
+	}
+	return nil
+}
+
+// pruneManifest deletes the manifest of a tag, which deletes all its tags.
+func (o *cmdImageOptions) pruneManifest(client *registry.Client, repo string, item pruneItem) error {
+	err := client.DeleteManifest(repo, item.Digest)
+	switch {
+	case err == nil || isNotFound(err):
+		return nil
+	case isUnsupported(err):
+		return newCmdError(err, "unable to delete %s:%s, deletion is disabled on the registry (UNSUPPORTED)",
+			repo, item.Tag)
+	default:
+		return explainRegistryError(err, imageReference(repo, item.Tag))
+	}
+}
+
+// pruneMessage tells the result of the deletion of a tag.
+func pruneMessage(item pruneItem) string {
+	if len(item.Error) > 0 {
+		return fmt.Sprintf("failed: %s\n", item.Error)
+	}
+	return fmt.Sprintf("image %s:%s is deleted (%s)\n", item.Repository, item.Tag, item.Reason)
+}
+
+// printPruneReport prints the plan of a dry run, and the summary.
+func (o *cmdImageOptions) printPruneReport(report pruneReport) {
+	if report.DryRun {
+		if len(report.Items) > 0 {
+			output.PrintTable(o.Out, report)
+		}
+		o.Out.Write([]byte(fmt.Sprintf("\nDry run: %d tags would be deleted, %d kept, %d failed\n",
+			report.Deleted, report.Kept, report.Failed)))
+		if report.deletesIndex() {
+			o.Out.Write([]byte("Platform manifests of the deleted indexes would not be deleted, " +
+				"the registry garbage collection removes them\n"))
+		}
+		return
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf("\n%d tags deleted, %d kept, %d failed\n", report.Deleted, report.Kept, report.Failed)))
+	if report.deletesIndex() {
+		o.Out.Write([]byte("Platform manifests of the deleted indexes are not deleted, " +
+			"the registry garbage collection removes them\n"))
+	}
+}
+
+// pruneItems reads the digest and the creation time of each tag of a repository. The creation
+// time of an index is the one of its newest platform image.
+func pruneItems(client *registry.Client, repo string) ([]pruneItem, error) {
+	tags, err := client.Tags(repo, 0)
+	if err != nil {
+		return nil, explainRegistryError(err, "tags of "+repo)
+	}
+
+	var items []pruneItem
+	for _, tag := range tags {
+		manifest, err := client.GetManifest(repo, tag)
+		if err != nil {
+			return nil, explainRegistryError(err, imageReference(repo, tag))
+		}
+
+		item := pruneItem{Repository: repo, Tag: tag, Digest: manifest.Digest}
+		images := []*registry.RawManifest{manifest}
+		if manifest.IsIndex() {
+			index, err := manifest.Index()
+			if err != nil {
+				return nil, err
+			}
+
+			images = nil
+			for _, desc := range index.Manifests {
+				item.children = append(item.children, desc.Digest)
+				child, err := client.GetManifest(repo, desc.Digest)
+				if err != nil {
+					return nil, explainRegistryError(err, imageReference(repo, desc.Digest))
+				}
+				images = append(images, child)
+			}
+		}
+
+		for _, m := range images {
+			image, err := m.Manifest()
+			if err != nil {
+				return nil, err
+			}
+
+			config, err := client.GetImageConfig(repo, image)
+			if err != nil {
+				return nil, explainRegistryError(err, fmt.Sprintf("config of %s", imageReference(repo, tag)))
+			}
+			if config.Created != nil && (item.Created == nil || config.Created.After(*item.Created)) {
+				item.Created = config.Created
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
@@ -0,0 +1,190 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// addAgedImage stores an image created the given number of days ago.
+func addAgedImage(server *registrytest.Server, repo, tag string, days int) string {
+	created := time.Now().Add(-time.Duration(days) * 24 * time.Hour).UTC().Format(time.RFC3339)
+	config := fmt.Sprintf(`{"created":%q,"architecture":"amd64","os":"linux"}`, created)
+	return server.AddImage(repo, tag, []byte(config), []byte(fmt.Sprintf("layer of %d days", days)))
+}
+
+func TestCmdImagePrune(t *testing.T) {
+	server := registrytest.NewServer(t)
+	addAgedImage(server, "app", "v1.0.0", 100)
+	old := addAgedImage(server, "app", "old", 90)
+	addAgedImage(server, "app", "nightly-1", 40)
+	addAgedImage(server, "app", "stable", 60)
+	addAgedImage(server, "app", "previous", 60)
+	recent := addAgedImage(server, "app", "recent", 1)
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+	args := []string{"image", "prune", "app", "--keep-semver", "--keep", "^stable$", "--older-than", "30d"}
+
+	// Dry run.
+	_, err := executeCommand(newRegiCommand(streams), append(args, "--dry-run", "-o", "json")...)
+	assert.NoError(t, err)
+
+	var report pruneReport
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	reasons := map[string]string{}
+	for _, item := range report.Items {
+		reasons[item.Tag] = item.Action + ": " + item.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"recent":    "keep: newer than 30d",
+		"nightly-1": "delete: older than 30d",
+		"stable":    "keep: matches --keep ^stable$",
+		"previous":  "keep: referenced by kept tag stable",
+		"old":       "delete: older than 30d",
+		"v1.0.0":    "keep: semantic version",
+	}, reasons)
+	assert.Equal(t, "recent", report.Items[0].Tag)
+	assert.Equal(t, 6, len(server.Tags("app")))
+
+	// Table of the dry run.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), append(args, "--dry-run")...)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "REPOSITORY   TAG")
+	assert.Contains(t, out.String(), "Dry run: 2 tags would be deleted, 4 kept")
+
+	// Deletion.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), args...)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "image app:old is deleted (older than 30d)")
+	assert.Contains(t, out.String(), "2 tags deleted, 4 kept")
+	assert.Equal(t, 4, len(server.Tags("app")))
+	assert.False(t, server.HasManifest("app", old))
+
+	// Keep the newest tags only.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "image", "prune", "--keep-last", "1")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"recent": recent}, server.Tags("app"))
+}
+
+func TestCmdImagePruneFailures(t *testing.T) {
+	server := registrytest.NewServer(t)
+	failing := addAgedImage(server, "app", "old-1", 90)
+	addAgedImage(server, "app", "old-2", 80)
+	addAgedImage(server, "app", "recent", 1)
+	useTestRegistry(t, server)
+
+	// The deletion of the manifest of old-1 fails.
+	server.Fail = func(r *http.Request) bool {
+		return r.Method == http.MethodDelete && strings.HasSuffix(r.URL.Path, "/manifests/"+failing)
+	}
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+	cmd, _, err := NewCmdImage(streams).Find([]string{"prune"})
+	assert.NoError(t, err)
+	assert.NoError(t, cmd.Flags().Set("older-than", "30d"))
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+
+	// The other tags are still deleted, and the prune fails in the end.
+	err = o.pruneCmdRun(cmd, []string{"app"})
+	assert.EqualError(t, err, "1 of 3 items failed to prune")
+	assert.Contains(t, out.String(), "failed: ")
+	assert.Contains(t, out.String(), "image app:old-2 is deleted (older than 30d)")
+	assert.Contains(t, out.String(), "1 tags deleted, 1 kept, 1 failed")
+	assert.Equal(t, []string{"old-1", "recent"}, sortedKeys(server.Tags("app")))
+
+	// Deletion disabled, the exit code tells it.
+	server.Fail = nil
+	server.DeleteDisabled = true
+	out.Reset()
+	err = o.pruneCmdRun(cmd, []string{"app"})
+	assert.EqualError(t, err, "1 of 2 items failed to prune")
+	assert.Equal(t, exitCodeUnsupported, exitCode(err))
+	assert.Contains(t, out.String(), "deletion is disabled on the registry (UNSUPPORTED)")
+
+	// The platform manifests of deleted indexes are not deleted along.
+	out.Reset()
+	o.printPruneReport(pruneReport{Deleted: 1, Items: []pruneItem{
+		{Repository: "app", Tag: "multi", Action: pruneActionDelete, children: []string{"sha256:1"}},
+	}})
+	assert.Contains(t, out.String(), "Platform manifests of the deleted indexes are not deleted")
+}
+
+func TestCmdImagePruneRules(t *testing.T) {
+	useTestRegistry(t, registrytest.NewServer(t))
+
+	c, _, err := NewCmdImage(io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr}).Find([]string{"prune"})
+	assert.NoError(t, err)
+
+	_, err = newRetention(c)
+	assert.EqualError(t, err, "no retention rule given, please give --keep-last and/or --older-than")
+
+	assert.NoError(t, c.Flags().Set("older-than", "30 days"))
+	_, err = newRetention(c)
+	assert.EqualError(t, err, `invalid duration "30 days", expected e.g. 30d or 12h`)
+
+	for s, expected := range map[string]time.Duration{"30d": 30 * 24 * time.Hour, "12h": 12 * time.Hour} {
+		d, err := parseAge(s)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, d)
+	}
+	assert.Equal(t, "30d", formatAge(30*24*time.Hour))
+	assert.Equal(t, "12h0m0s", formatAge(12*time.Hour))
+}
+
+func TestRetentionApply(t *testing.T) {
+	now := time.Now()
+	created := func(days int) *time.Time {
+		c := now.Add(-time.Duration(days) * 24 * time.Hour)
+		return &c
+	}
+	items := []pruneItem{
+		{Tag: "stable", Digest: "sha256:1", Created: created(1)},
+		{Tag: "v1.0.0", Digest: "sha256:2", Created: created(2)},
+		{Tag: "nightly-3", Digest: "sha256:3", Created: created(3)},
+		{Tag: "nightly-4", Digest: "sha256:4", Created: created(4)},
+		{Tag: "nightly-5", Digest: "sha256:5", Created: created(5), children: []string{"sha256:6"}},
+	}
+
+	// Tags kept by --keep and --keep-semver are not counted among the newest.
+	rules := &retention{keepLast: 2, keepSemver: true, keep: []*regexp.Regexp{regexp.MustCompile("^stable$")}}
+	rules.apply(items, now)
+
+	reasons := map[string]string{}
+	for _, item := range items {
+		reasons[item.Tag] = item.Action + ": " + item.Reason
+	}
+	assert.Equal(t, map[string]string{
+		"stable":    "keep: matches --keep ^stable$",
+		"v1.0.0":    "keep: semantic version",
+		"nightly-3": "keep: one of the 2 newest",
+		"nightly-4": "keep: one of the 2 newest",
+		"nightly-5": "delete: not one of the 2 newest",
+	}, reasons)
+
+	// Platform manifests of a deleted index are not deleted along.
+	assert.True(t, pruneReport{Items: items}.deletesIndex())
+	assert.False(t, pruneReport{Items: items[:4]}.deletesIndex())
+}
+
+// sortedKeys returns the keys of a map, sorted.
+func sortedKeys(m map[string]string) []string {
+	k := keys(m)
+	sort.Strings(k)
+	return k
+}
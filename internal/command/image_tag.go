@@ -0,0 +1,73 @@
+package command
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"regexp"
+	"strings"
+)
+
+// tagRegexp matches valid tags, as defined by the distribution API.
+var tagRegexp = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_.-]{0,127}$`)
+
+// parseImageReference parses an image given as '<repository>:<tag>' or '<repository>@<digest>'.
+func parseImageReference(s string) (string, string, error) {
+	if i := strings.LastIndex(s, "@"); i > 0 {
+		return s[:i], s[i+1:], nil
+	}
+
+	if i := strings.LastIndex(s, ":"); i > 0 && i > strings.LastIndex(s, "/") && i < len(s)-1 {
+		return s[:i], s[i+1:], nil
+	}
+	return "", "", errors.Errorf("invalid image %q, expected <repository>:<tag> or <repository>@<digest>", s)
+}
+
+// tagCmdRun tags an image of the current registry with new tags, without pulling it. The
+// manifest is fetched as it is and put under the new tags, so the digest is preserved.
+func (o *cmdImageOptions) tagCmdRun(cmd *cobra.Command, args []string) error {
+	// Verify arguments.
+	if len(args) < 2 {
+		return errors.New("image and new tag are not specified, please give <repository>:<tag> <new-tag>")
+	}
+
+	name, ref, err := parseImageReference(args[0])
+	if err != nil {
+		return err
+	}
+
+	tags := args[1:]
+	for _, tag := range tags {
+		if !tagRegexp.MatchString(tag) {
+			return errors.Errorf("invalid tag %q, a tag is made of up to 128 letters, digits, '_', '.' and '-'", tag)
+		}
+	}
+
+	client, err := o.currentRegistryClient()
+	if err != nil {
+		return err
+	}
+
+	// The registry serves the manifest with its own media type, which is sent back as it is.
+	manifest, err := client.GetManifest(name, ref)
+	if err != nil {
+		return explainRegistryError(err, imageReference(name, ref))
+	}
+
+	for _, tag := range tags {
+		digest, err := client.PutManifest(name, tag, manifest)
+		if err != nil {
+			return explainRegistryError(err, imageReference(name, tag))
+		}
+
+		if digest != manifest.Digest {
+			return errors.Errorf("digest of %s is %s, expected %s of %s",
+				imageReference(name, tag), digest, manifest.Digest, imageReference(name, ref))
+		}
+
+		o.Streams.Out.Write([]byte(fmt.Sprintf("image %s is tagged as %s, digest: %s\n",
+			imageReference(name, ref), imageReference(name, tag), digest)))
+	}
+
+	return nil
+}
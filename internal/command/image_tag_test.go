@@ -0,0 +1,52 @@
+package command
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+)
+
+func TestCmdImageTag(t *testing.T) {
+	server := registrytest.NewServer(t)
+	image := server.AddImage("app", "build-42", []byte(`{"os":"linux"}`), []byte("layer"))
+	index := server.AddIndex("tools", "build-7", "linux/amd64", "linux/arm64")
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// An image, with several tags.
+	_, err := executeCommand(NewCmdImage(streams), "tag", "app:build-42", "stable", "prod")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"build-42": image, "stable": image, "prod": image}, server.Tags("app"))
+	assert.Contains(t, out.String(), "image app:build-42 is tagged as app:prod, digest: "+image)
+
+	// An index by digest, keeping its media type.
+	_, err = executeCommand(NewCmdImage(streams), "tag", "tools@"+index, "stable")
+	assert.NoError(t, err)
+	assert.Equal(t, index, server.Tags("tools")["stable"])
+
+	client, err := registry.NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+	desc, err := client.HeadManifest("tools", "stable")
+	assert.NoError(t, err)
+	assert.Equal(t, registry.MediaTypeOCIIndex, desc.MediaType)
+
+	// Errors.
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	assert.EqualError(t, o.tagCmdRun(nil, []string{"app"}),
+		"image and new tag are not specified, please give <repository>:<tag> <new-tag>")
+	assert.EqualError(t, o.tagCmdRun(nil, []string{"app", "stable"}),
+		`invalid image "app", expected <repository>:<tag> or <repository>@<digest>`)
+	assert.EqualError(t, o.tagCmdRun(nil, []string{"app:build-42", "app:stable"}),
+		`invalid tag "app:stable", a tag is made of up to 128 letters, digits, '_', '.' and '-'`)
+
+	err = o.tagCmdRun(nil, []string{"app:unknown", "stable"})
+	assert.EqualError(t, err, "app:unknown not found: manifest unknown (MANIFEST_UNKNOWN)")
+	assert.Equal(t, exitCodeNotFound, exitCode(err))
+}
@@ -1,44 +1,237 @@
 package command
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/data"
 	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/layout"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
 	"github.com/stretchr/testify/assert"
 	"os"
-	"os/exec"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestCmdImage(t *testing.T) {
-	streams := io.Streams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
+	server := registrytest.NewServer(t)
+	digest := server.AddImage("hello-world", "latest", []byte(`{"architecture":"amd64","os":"linux"}`), []byte("layer-1"))
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
 	imgCmd := NewCmdImage(streams)
 	assert.NotNil(t, imgCmd)
+	dir := t.TempDir()
 
-	// Docker pull hello-world image for later push test.
-	// Execute command.
-	c := exec.Command(
-		"docker",
-		"pull",
-		"hello-world:latest",
-	)
-	_, err := c.CombinedOutput()
-	if err != nil {
-		t.Fatal(err)
-	}
+	// Pull to a tarball.
+	tarball := filepath.Join(dir, "hello-world.tar")
+	_, err := executeCommand(imgCmd, "pull", "hello-world", "latest", "--path", tarball)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Digest: "+digest)
+	assert.FileExists(t, tarball)
+
+	// Push from the tarball under a new tag.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "push", "hello-world", "v1", "--path", tarball)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Layer already exists")
+	assert.Contains(t, out.String(), "v1: digest: "+digest)
+	assert.Equal(t, digest, server.Tags("hello-world")["v1"])
 
-	// Push.
-	_, err = executeCommand(imgCmd, "push", "hello-world", "latest")
+	// Pull to an OCI image layout, and push to another repository.
+	layoutDir := filepath.Join(dir, "hello-world")
+	_, err = executeCommand(NewCmdImage(streams), "pull", "hello-world", "latest", "--path", layoutDir, "--format", "oci")
 	assert.NoError(t, err)
+	assert.FileExists(t, filepath.Join(layoutDir, "index.json"))
 
-	// Pull.
-	_, err = executeCommand(imgCmd, "pull", "hello-world", "latest")
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "push", "hello", "latest", "--path", layoutDir)
 	assert.NoError(t, err)
+	assert.Equal(t, 2, strings.Count(out.String(), ": Pushed"))
+	assert.Equal(t, digest, server.Tags("hello")["latest"])
 
 	// ListContexts.
-	_, err = executeCommand(imgCmd, "list", "withTag=true")
+	_, err = executeCommand(NewCmdImage(streams), "list", "withTag=true")
 	assert.NoError(t, err)
 
 	// DeleteContext.
-	_, err = executeCommand(imgCmd, "delete", "hello-world", "latest")
+	_, err = executeCommand(NewCmdImage(streams), "delete", "hello-world", "latest")
+	assert.NoError(t, err)
+}
+
+func TestCmdImagePullPlatform(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.AddIndex("hello-world", "latest", "linux/amd64", "linux/arm64")
+	useTestRegistry(t, server)
+
+	streams := io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr}
+	dir := t.TempDir()
+
+	// A tarball holds the image of a single platform.
+	tarball := filepath.Join(dir, "hello-world.tar")
+	_, err := executeCommand(NewCmdImage(streams), "pull", "hello-world", "latest", "--path", tarball, "--platform", "linux/arm64")
+	assert.NoError(t, err)
+
+	r, err := layout.Open(tarball)
+	assert.NoError(t, err)
+	m, err := r.Image("")
+	assert.NoError(t, err)
+	assert.False(t, m.IsIndex())
+	platforms, err := registry.Platforms(r, m)
+	assert.NoError(t, err)
+	assert.Equal(t, "linux/arm64", platforms[0].String())
+
+	// An OCI image layout keeps all the platforms.
+	layoutDir := filepath.Join(dir, "hello-world")
+	_, err = executeCommand(NewCmdImage(streams), "pull", "hello-world", "latest", "--path", layoutDir, "--format", "oci")
 	assert.NoError(t, err)
 
+	r, err = layout.Open(layoutDir)
+	assert.NoError(t, err)
+	m, err = r.Image("")
+	assert.NoError(t, err)
+	assert.True(t, m.IsIndex())
+
+	// Unknown platform.
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	pullCmd, _, err := NewCmdImage(streams).Find([]string{"pull"})
+	assert.NoError(t, err)
+	assert.NoError(t, pullCmd.Flags().Set("platform", "linux/s390x"))
+	assert.NoError(t, pullCmd.Flags().Set("path", filepath.Join(dir, "s390x.tar")))
+	err = o.pullCmdRun(pullCmd, []string{"hello-world", "latest"})
+	assert.Error(t, err)
+	assert.NoFileExists(t, filepath.Join(dir, "s390x.tar"))
+}
+
+func TestCmdImageList(t *testing.T) {
+	server := registrytest.NewServer(t)
+	for i := 0; i < 150; i++ {
+		server.AddImage(fmt.Sprintf("repo-%03d", i), "latest", []byte(fmt.Sprintf(`{"id":%d}`, i)))
+	}
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// All the pages are walked through.
+	_, err := executeCommand(NewCmdImage(streams), "list")
+	assert.NoError(t, err)
+	assert.Equal(t, 150, strings.Count(out.String(), "[latest]"))
+	assert.Contains(t, out.String(), "- repo-149  [latest]")
+
+	// Listing stops at the limit.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "list", "--limit=120")
+	assert.NoError(t, err)
+	assert.Equal(t, 120, strings.Count(out.String(), "[latest]"))
+
+	// Structured output.
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "image", "list", "--limit=2", "-o", "json")
+	assert.NoError(t, err)
+
+	var list imageList
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &list))
+	assert.Equal(t, imageList{Images: []imageInfo{
+		{Name: "repo-000", Tags: []string{"latest"}},
+		{Name: "repo-001", Tags: []string{"latest"}},
+	}}, list)
+}
+
+func TestCmdImageDelete(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.AddImage("hello-world", "latest", []byte(`{}`))
+	useTestRegistry(t, server)
+
+	streams := io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdImage(streams), "delete", "hello-world", "latest")
+	assert.NoError(t, err)
+	assert.Empty(t, server.Tags("hello-world"))
+
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+	delCmd, _, err := NewCmdImage(streams).Find([]string{"delete"})
+	assert.NoError(t, err)
+
+	// Unknown tag.
+	err = o.delCmdRun(delCmd, []string{"hello-world", "latest"})
+	assert.EqualError(t, err, `unable to delete hello-world:latest, tag "latest" not found in repository "hello-world"`)
+	assert.Equal(t, exitCodeNotFound, exitCode(err))
+
+	// Deletion disabled.
+	server.AddImage("hello-world", "latest", []byte(`{}`))
+	server.DeleteDisabled = true
+	err = o.delCmdRun(delCmd, []string{"hello-world", "latest"})
+	assert.EqualError(t, err, "unable to delete hello-world:latest, deletion is disabled on the registry (UNSUPPORTED)")
+	assert.Equal(t, exitCodeUnsupported, exitCode(err))
+}
+
+func TestCmdImageDeleteIndex(t *testing.T) {
+	server := registrytest.NewServer(t)
+	kept := server.AddIndex("hello-world", "kept", "linux/amd64", "linux/arm64")
+	deleted := server.AddIndex("hello-world", "deleted", "linux/amd64", "linux/arm/v7")
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// Without --recursive, the platform manifests are kept.
+	_, err := executeCommand(NewCmdImage(streams), "delete", "hello-world", "kept")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "its platform manifests are kept")
+	assert.False(t, server.HasManifest("hello-world", kept))
+
+	// With --recursive, they are deleted as well.
+	out.Reset()
+	_, err = executeCommand(NewCmdImage(streams), "delete", "hello-world", "deleted", "--recursive")
+	assert.NoError(t, err)
+	assert.False(t, server.HasManifest("hello-world", deleted))
+	assert.Contains(t, out.String(), "image hello-world:deleted is deleted")
+	assert.Contains(t, out.String(), "for linux/arm/v7 is deleted")
+	assert.Equal(t, 1, strings.Count(out.String(), "for linux/amd64 is deleted"))
+}
+
+func TestCmdImageListPlatforms(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.AddIndex("hello-world", "latest", "linux/amd64", "linux/arm64")
+	server.AddImage("hello-world", "amd64", []byte(`{"architecture":"amd64","os":"linux"}`))
+	useTestRegistry(t, server)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	_, err := executeCommand(NewCmdImage(streams), "list", "--platforms")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "    amd64: linux/amd64\n")
+	assert.Contains(t, out.String(), "    latest: linux/amd64, linux/arm64\n")
+
+	out.Reset()
+	_, err = executeCommand(newRegiCommand(streams), "image", "list", "--platforms", "-o", "wide")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "PLATFORMS")
+	assert.Contains(t, out.String(), "amd64=linux/amd64 latest=linux/amd64,linux/arm64")
+}
+
+// useTestRegistry points the current context of a fresh storage at the test registry.
+func useTestRegistry(t *testing.T, server *registrytest.Server) {
+	t.Setenv("HOME", t.TempDir())
+
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&data.Registry{Name: "test", Server: server.URL})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, db.SetCurrentContext("test"))
+}
+
+// mustOpenDB opens the storage of the test.
+func mustOpenDB(t *testing.T) *data.DB {
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	return db
 }
@@ -1,84 +1,224 @@
 package command
 
 import (
+	"bufio"
 	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
 	"github.com/iamharvey/regi/internal/pkg/data"
-	"github.com/iamharvey/regi/internal/pkg/io"
+	rio "github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/iamharvey/regi/internal/pkg/rest"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"os/exec"
+	"io"
+	"net/http"
+	"strings"
+	"time"
 )
 
 const (
 	// msgShortLoginCmd is the short version description for login root command.
 	msgShortLoginCmd = "Login to current Docker registry."
+
+	// msgExamplesLoginCmd is the example description for login command.
+	msgExamplesLoginCmd = `
+  # Login to the registry of the current context, with its credentials or asking for them.
+  regi login
+
+  # Login to the registry of a context, reading the password from stdin.
+  cat password.txt | regi login prod --username=ci --password-stdin
+
+  # Login, and save the credentials to the Docker config file for docker to use.
+  regi login --save-docker-config
+`
 )
 
 // cmdLoginOptions eases access to storage and console io.
 type cmdLoginOptions struct {
 	*data.DB
-	io.Streams
+	rio.Streams
 }
 
-// NewCmdLoginOptions returns a new Options for login command.
-func NewCmdLoginOptions(streams io.Streams) (*cmdLoginOptions, error) {
-	db, err := data.NewDB()
-	if err != nil {
-		return nil, err
-	}
+// NewCmdLoginOptions returns a new Options for login command on the storage db. Commands pass nil, and
+// open the storage given by the global flags once they are parsed.
+func NewCmdLoginOptions(db *data.DB, streams rio.Streams) *cmdLoginOptions {
 	return &cmdLoginOptions{
 		DB:      db,
 		Streams: streams,
-	}, nil
+	}
 }
 
 // NewCmdLogin creates a login command.
-func NewCmdLogin(streams io.Streams) *cobra.Command {
-	o, err := NewCmdLoginOptions(streams)
-	if err != nil {
-		streams.ErrOut.Write([]byte(err.Error()))
-	}
+func NewCmdLogin(streams rio.Streams) *cobra.Command {
+	o := NewCmdLoginOptions(nil, streams)
 
 	// Context root command.
 	cmd := &cobra.Command{
-		Use:                   "login",
+		Use:                   "login [context]",
 		DisableFlagsInUseLine: true,
 		Short:                 msgShortLoginCmd,
+		Example:               msgExamplesLoginCmd,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			var err error
+			o.DB, err = openDB(cmd)
+			checkErr(err)
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			cobra.CheckErr(o.loginCmdRun())
+			checkErr(o.loginCmdRun(cmd, args))
 		},
 	}
 
+	cmd.Flags().StringP("username", "u", "", "username, the user of the context by default")
+	cmd.Flags().Bool("password-stdin", false, "read the password from stdin")
+	cmd.Flags().Bool("save-docker-config", false, "save the credentials to the Docker config file as well")
+
 	return cmd
 }
 
-// loginCmdRun lists all the registries.
-func (o *cmdLoginOptions) loginCmdRun() error {
-	reg, err := o.CurrentContext()
+// loginCmdRun verifies the credentials of the context against the registry. Credentials given
+// by the user are kept for the context once the registry accepts them.
+func (o *cmdLoginOptions) loginCmdRun(cmd *cobra.Command, args []string) error {
+	reg, err := o.loginContext(args)
 	if err != nil {
 		return err
 	}
 
-	if reg == nil {
-		return errors.New("context is not set, please set current context with 'regi ctx set <name>' first")
+	username, err := cmd.Flags().GetString("username")
+	if err != nil {
+		return err
+	}
+
+	passwordStdin, err := cmd.Flags().GetBool("password-stdin")
+	if err != nil {
+		return err
+	}
+
+	saveDockerConfig, err := cmd.Flags().GetBool("save-docker-config")
+	if err != nil {
+		return err
+	}
+
+	user, password, err := contextCredentials(reg)
+	if err != nil {
+		return err
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf("Connecting Docker registry with context [%s](%s)\n", reg.Name, reg.Server)))
+
+	// Credentials of the context are used unless others are given.
+	given := passwordStdin || (len(username) > 0 && username != user) || len(password) == 0
+	if len(username) > 0 {
+		user = username
 	}
 
-	o.Out.Write([]byte(fmt.Sprintf("\nConnecting Docker registry with current context [%s](%s)",
-		reg.Name, reg.Server)))
+	in := bufio.NewReader(o.In)
+	switch {
+	case passwordStdin:
+		if len(user) == 0 {
+			return errors.New("username is required with --password-stdin, please give it with --username")
+		}
 
-	c := exec.Command(
-		"docker",
-		"login",
-		"-u", reg.User,
-		"-p", reg.Password,
-		reg.Server)
+		content, err := io.ReadAll(in)
+		if err != nil {
+			return errors.Wrap(err, "unable to read password from stdin")
+		}
+		password = strings.TrimRight(string(content), "\r\n")
+	case given:
+		if len(user) == 0 {
+			if user, err = prompt(o.Out, in, "Username"); err != nil {
+				return err
+			}
+		}
 
-	out, err := c.CombinedOutput()
+		if password, err = prompt(o.Out, in, "Password"); err != nil {
+			return err
+		}
+	default:
+		o.Out.Write([]byte("Authenticating with existing credentials...\n"))
+	}
+
+	if len(password) == 0 {
+		return errors.New("password is empty")
+	}
+
+	client, err := registry.NewClient(withCredentials(&rest.ClientConfig{
+		Host:            reg.Server,
+		TLSClientConfig: tlsClientConfig(reg),
+		Timeout:         time.Second * 3,
+	}, user, password))
 	if err != nil {
 		return err
 	}
 
-	o.Streams.Out.Write([]byte(fmt.Sprintf("\n%s", out)))
+	if err := client.Ping(); err != nil {
+		if rest.HasStatus(err, http.StatusUnauthorized) || rest.HasErrorCode(err, rest.ErrorCodeUnauthorized) {
+			return newCmdError(err, "login to %s failed, the registry refuses the username or password", reg.Server)
+		}
+		return errors.Wrapf(err, "login to %s failed", reg.Server)
+	}
+
+	if given {
+		if err := o.saveCredentials(reg, user, password); err != nil {
+			return err
+		}
+	}
 
+	if saveDockerConfig {
+		path := credentials.DefaultDockerConfigPath()
+		err := credentials.NewDockerConfigStore(path).Store(credentials.DockerServer(reg.Server),
+			credentials.Credentials{Username: user, Secret: password})
+		if err != nil {
+			return errors.Wrapf(err, "unable to save credentials to %s", path)
+		}
+		o.Out.Write([]byte(fmt.Sprintf("Credentials saved to %s\n", path)))
+	}
+
+	o.Out.Write([]byte("Login Succeeded\n"))
 	return nil
 }
+
+// loginContext returns the context with the given name, or the one to use: the current context
+// unless another one is given by --context or the environment.
+func (o *cmdLoginOptions) loginContext(args []string) (*data.Registry, error) {
+	if len(args) == 0 {
+		reg, err := o.ActiveContext()
+		if err != nil {
+			return nil, err
+		}
+
+		if reg == nil {
+			return nil, errors.New("context is not set, please set current context with 'regi ctx set <name>' first")
+		}
+		return reg, nil
+	}
+
+	reg, err := o.GetContext(args[0])
+	if err != nil {
+		return nil, err
+	}
+
+	if reg == nil {
+		return nil, errors.Errorf("context %q not found", args[0])
+	}
+	return reg, nil
+}
+
+// saveCredentials keeps the credentials for the context, in its credentials store if it has one.
+func (o *cmdLoginOptions) saveCredentials(reg *data.Registry, user, password string) error {
+	// Credentials of an ephemeral context are given by the environment each time.
+	if reg.Ephemeral {
+		return nil
+	}
+
+	if len(reg.CredentialsStore) == 0 {
+		return o.SetCredentials(reg.Name, user, password)
+	}
+
+	err := credentials.NewStore(reg.CredentialsStore).Store(reg.Server, credentials.Credentials{Username: user, Secret: password})
+	if err != nil {
+		return errors.Wrapf(err, "unable to store credentials of context %q", reg.Name)
+	}
+
+	// The user is kept in the context, for display.
+	return o.SetCredentials(reg.Name, user, "")
+}
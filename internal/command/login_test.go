@@ -1,19 +1,107 @@
 package command
 
 import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
 	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
 	"github.com/stretchr/testify/assert"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestCmdLogin(t *testing.T) {
-	streams := io.Streams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
-	ctxCmd := NewCmdLogin(streams)
-	assert.NotNil(t, ctxCmd)
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
 
-	// Login.
-	_, err := executeCommand(ctxCmd)
+	server := registrytest.NewServer(t)
+	server.Username, server.Password = "regi", "s3cret"
+
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	ok, err := db.Add(&data.Registry{Name: "test", Server: server.URL, User: "regi"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, db.SetCurrentContext("test"))
+
+	// The password is read from stdin, and kept for the context.
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: strings.NewReader("s3cret\n"), Out: out, ErrOut: os.Stderr}
+	_, err = executeCommand(NewCmdLogin(streams), "--password-stdin")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Login Succeeded\n")
+
+	db, err = data.NewDB()
+	assert.NoError(t, err)
+	reg, err := db.GetContext("test")
 	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", reg.Password)
+
+	// The credentials of the context are used once kept, and saved for docker on demand.
+	out.Reset()
+	streams.In = strings.NewReader("")
+	_, err = executeCommand(NewCmdLogin(streams), "test", "--save-docker-config")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Authenticating with existing credentials...\n")
+	assert.Contains(t, out.String(), "Credentials saved to "+filepath.Join(home, ".docker", "config.json"))
+
+	c, err := credentials.NewStore(credentials.DockerConfig).Get(credentials.DockerServer(server.URL))
+	assert.NoError(t, err)
+	assert.Equal(t, &credentials.Credentials{Username: "regi", Secret: "s3cret"}, c)
+}
+
+func TestCmdLoginPrompt(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	server := registrytest.NewServer(t)
+	server.Username, server.Password = "regi", "s3cret"
 
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	ok, err := db.Add(&data.Registry{Name: "test", Server: server.URL})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Username and password are asked for.
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: strings.NewReader("regi\ns3cret\n"), Out: out, ErrOut: os.Stderr}
+	_, err = executeCommand(NewCmdLogin(streams), "test")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Username: Password: Login Succeeded\n")
+
+	db, err = data.NewDB()
+	assert.NoError(t, err)
+	reg, err := db.GetContext("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", reg.User)
+	assert.Equal(t, "s3cret", reg.Password)
+
+	// Refused credentials are not kept.
+	streams.In = strings.NewReader("wrong\n")
+	o := NewCmdLoginOptions(mustOpenDB(t), streams)
+	cmd := NewCmdLogin(streams)
+	assert.NoError(t, cmd.ParseFlags([]string{"--username=other"}))
+	err = o.loginCmdRun(cmd, []string{"test"})
+	assert.EqualError(t, err, "login to "+server.URL+" failed, the registry refuses the username or password")
+	assert.Equal(t, exitCodeUnauthorized, exitCode(err))
+
+	db, err = data.NewDB()
+	assert.NoError(t, err)
+	reg, err = db.GetContext("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", reg.User)
+	assert.Equal(t, "s3cret", reg.Password)
+
+	// Errors.
+	assert.NoError(t, cmd.ParseFlags([]string{"--username=", "--password-stdin"}))
+	_, err = db.Add(&data.Registry{Name: "anonymous", Server: server.URL})
+	assert.NoError(t, err)
+	o = NewCmdLoginOptions(mustOpenDB(t), streams)
+	assert.EqualError(t, o.loginCmdRun(cmd, []string{"anonymous"}),
+		"username is required with --password-stdin, please give it with --username")
+	assert.EqualError(t, o.loginCmdRun(cmd, []string{"unknown"}), `context "unknown" not found`)
 }
@@ -0,0 +1,107 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	rio "github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+const (
+	// msgShortLogoutCmd is the short version description for logout command.
+	msgShortLogoutCmd = "Logout from a Docker registry, removing the stored credentials of the context."
+
+	// msgExamplesLogoutCmd is the example description for logout command.
+	msgExamplesLogoutCmd = `
+  # Logout from the registry of the current context.
+  regi logout
+
+  # Logout from the registry of a context.
+  regi logout prod
+
+  # Logout from the registries of all the contexts.
+  regi logout --all
+`
+)
+
+// NewCmdLogout creates a logout command.
+func NewCmdLogout(streams rio.Streams) *cobra.Command {
+	o := NewCmdLoginOptions(nil, streams)
+
+	cmd := &cobra.Command{
+		Use:                   "logout [context]",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortLogoutCmd,
+		Example:               msgExamplesLogoutCmd,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			var err error
+			o.DB, err = openDB(cmd)
+			checkErr(err)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.logoutCmdRun(cmd, args))
+		},
+	}
+
+	cmd.Flags().Bool("all", false, "logout from the registries of all the contexts")
+
+	return cmd
+}
+
+// logoutCmdRun removes the credentials of the context from regi's storage, its credentials
+// store and the Docker config file. The user of the context is kept.
+func (o *cmdLoginOptions) logoutCmdRun(cmd *cobra.Command, args []string) error {
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return err
+	}
+
+	var registries []*data.Registry
+	if all {
+		if len(args) > 0 {
+			return errors.New("context name and --all can not be given together")
+		}
+
+		if registries, err = o.ListContexts(); err != nil {
+			return err
+		}
+	} else {
+		reg, err := o.loginContext(args)
+		if err != nil {
+			return err
+		}
+		registries = append(registries, reg)
+	}
+
+	for _, reg := range registries {
+		if err := o.logout(reg); err != nil {
+			return err
+		}
+		o.Out.Write([]byte(fmt.Sprintf("Removed login credentials of context [%s](%s)\n", reg.Name, reg.Server)))
+	}
+	return nil
+}
+
+// logout removes the credentials of a context wherever they are stored.
+func (o *cmdLoginOptions) logout(reg *data.Registry) error {
+	if !reg.Ephemeral {
+		if err := o.SetCredentials(reg.Name, reg.User, ""); err != nil {
+			return err
+		}
+	}
+
+	if len(reg.CredentialsStore) > 0 {
+		if err := credentials.NewStore(reg.CredentialsStore).Erase(reg.Server); err != nil {
+			return errors.Wrapf(err, "unable to erase credentials of context %q", reg.Name)
+		}
+	}
+
+	// Saved by 'login --save-docker-config' or 'docker login'.
+	path := credentials.DefaultDockerConfigPath()
+	if err := credentials.NewDockerConfigStore(path).Erase(reg.Server); err != nil {
+		return errors.Wrapf(err, "unable to erase credentials of context %q from %s", reg.Name, path)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package command
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/credentials"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCmdLogout(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+
+	db, err := data.NewDB()
+	assert.NoError(t, err)
+	for _, reg := range []*data.Registry{
+		{Name: "local", Server: "http://localhost:5000", User: "regi", Password: "s3cret"},
+		{Name: "prod", Server: "https://registry.test", User: "ci", CredentialsStore: credentials.DockerConfig},
+	} {
+		ok, err := db.Add(reg)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	assert.NoError(t, db.SetCurrentContext("local"))
+
+	docker := credentials.NewStore(credentials.DockerConfig)
+	assert.NoError(t, docker.Store("localhost:5000", credentials.Credentials{Username: "regi", Secret: "s3cret"}))
+	assert.NoError(t, docker.Store("https://registry.test", credentials.Credentials{Username: "ci", Secret: "token"}))
+
+	// The current context.
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+	_, err = executeCommand(NewCmdLogout(streams))
+	assert.NoError(t, err)
+	assert.Equal(t, "Removed login credentials of context [local](http://localhost:5000)\n", out.String())
+
+	db, err = data.NewDB()
+	assert.NoError(t, err)
+	reg, err := db.GetContext("local")
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", reg.User)
+	assert.Empty(t, reg.Password)
+
+	c, err := docker.Get("localhost:5000")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+	c, err = docker.Get("registry.test")
+	assert.NoError(t, err)
+	assert.NotNil(t, c)
+
+	// All the contexts.
+	out.Reset()
+	_, err = executeCommand(NewCmdLogout(streams), "--all")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Removed login credentials of context [prod](https://registry.test)\n")
+
+	c, err = docker.Get("registry.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	// Errors.
+	o := NewCmdLoginOptions(mustOpenDB(t), streams)
+	cmd := NewCmdLogout(streams)
+	assert.EqualError(t, o.logoutCmdRun(cmd, []string{"unknown"}), `context "unknown" not found`)
+	assert.NoError(t, cmd.ParseFlags([]string{"--all"}))
+	assert.EqualError(t, o.logoutCmdRun(cmd, []string{"local"}), "context name and --all can not be given together")
+}
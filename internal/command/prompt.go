@@ -0,0 +1,43 @@
+package command
+
+import (
+	"bufio"
+	"fmt"
+	rio "github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+)
+
+// confirm asks a yes/no question and reads the answer from the input. Anything but 'y' or
+// 'yes' is a no, including the end of the input.
+func confirm(streams rio.Streams, question string) (bool, error) {
+	streams.Out.Write([]byte(fmt.Sprintf("%s [y/N]: ", question)))
+
+	answer, err := bufio.NewReader(streams.In).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
+
+// prompt asks for a value and reads a line of the input, without the line break. The reader
+// is shared by successive prompts, so that none of the input is lost.
+func prompt(out io.Writer, in *bufio.Reader, label string) (string, error) {
+	out.Write([]byte(label + ": "))
+
+	line, err := in.ReadString('\n')
+	if err != nil && (err != io.EOF || len(line) == 0) {
+		if err == io.EOF {
+			return "", errors.Errorf("%s is not given", strings.ToLower(label))
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
@@ -2,7 +2,9 @@ package command
 
 import (
 	"flag"
+	"github.com/iamharvey/regi/internal/pkg/data"
 	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/output"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -24,6 +26,12 @@ For more information about Regi, please visit:
 
 // NewRegiCommand creates Regi root command.
 func NewRegiCommand() *cobra.Command {
+	// Initialize an io stream with standard io reader and writers.
+	return newRegiCommand(io.Streams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr})
+}
+
+// newRegiCommand creates Regi root command with the given io streams.
+func newRegiCommand(streams io.Streams) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "regi",
 		Short: msgShort,
@@ -31,16 +39,23 @@ func NewRegiCommand() *cobra.Command {
 		Run:   runHelp,
 	}
 
-	// Initialize an io stream with standard io reader and writers.
-	streams := io.Streams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
-
 	// Add sub commands.
 	cmd.AddCommand(
 		NewCmdContext(streams),
 		NewCmdLogin(streams),
+		NewCmdLogout(streams),
 		NewCmdImage(streams),
+		NewCmdSync(streams),
 	)
 
+	// Add global flags.
+	cmd.PersistentFlags().StringP("output", "o", "",
+		"output format, one of json, yaml, wide or go-template=<template>")
+	cmd.PersistentFlags().String("context", "",
+		"context to use instead of the current one, REGI_CONTEXT by default")
+	cmd.PersistentFlags().String("config", "",
+		"path of the storage file, REGI_CONFIG or ~/.regi/regi.yaml by default")
+
 	// Add go flag set.
 	cmd.PersistentFlags().AddGoFlagSet(flag.CommandLine)
 
@@ -51,3 +66,25 @@ func NewRegiCommand() *cobra.Command {
 func runHelp(cmd *cobra.Command, _ []string) {
 	cmd.Help()
 }
+
+// outputFormat returns the output format given by the global --output flag.
+func outputFormat(cmd *cobra.Command) (*output.Format, error) {
+	f := cmd.Flag("output")
+	if f == nil {
+		return output.ParseFormat("")
+	}
+	return output.ParseFormat(f.Value.String())
+}
+
+// openDB opens the storage given by the global --config and --context flags. It is called once
+// the flags are parsed, so commands never touch a storage file they are told not to use.
+func openDB(cmd *cobra.Command) (*data.DB, error) {
+	var opts data.Options
+	if f := cmd.Flag("config"); f != nil {
+		opts.Location = f.Value.String()
+	}
+	if f := cmd.Flag("context"); f != nil {
+		opts.Context = f.Value.String()
+	}
+	return data.NewDBWithOptions(opts)
+}
@@ -1,7 +1,14 @@
 package command
 
 import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/data"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -9,12 +16,14 @@ func TestCmdRoot(t *testing.T) {
 	rootCmd := NewRegiCommand()
 	assert.NotNil(t, rootCmd)
 
-	/*  There are actually 3 commands:
+	/*  There are actually 5 commands:
 	- context		Manage connection settings of multiple Docker registries.
 	- image			Pull, push, delete and list images over Docker registry
 	- login			Login to current Docker registry.
+	- logout		Logout from a Docker registry, removing the stored credentials of the context.
+	- sync			Mirror repositories from the registry of a context to the registry of another one.
 	*/
-	assert.Equal(t, 3, len(rootCmd.Commands()))
+	assert.Equal(t, 5, len(rootCmd.Commands()))
 	assert.Equal(t, msgShort, rootCmd.Short)
 	assert.Equal(t, msgLong, rootCmd.Long)
 
@@ -22,3 +31,68 @@ func TestCmdRoot(t *testing.T) {
 	_, err := executeCommand(rootCmd)
 	assert.NoError(t, err)
 }
+
+func TestCmdRootContextAndConfig(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+	t.Setenv(data.ContextEnv, "")
+	t.Setenv(data.ServerEnv, "")
+
+	server := registrytest.NewServer(t)
+	server.Username, server.Password = "regi", "s3cret"
+
+	location := filepath.Join(t.TempDir(), "regi.yaml")
+	db, err := data.NewDBWithOptions(data.Options{Location: location})
+	assert.NoError(t, err)
+	for _, reg := range []*data.Registry{
+		{Name: "current", Server: "https://current.test"},
+		{Name: "test", Server: server.URL, User: "regi", Password: "s3cret"},
+	} {
+		_, err := db.Add(reg)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.SetCurrentContext("current"))
+
+	// The context is used for this invocation only.
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: strings.NewReader(""), Out: out, ErrOut: os.Stderr}
+	_, err = executeCommand(newRegiCommand(streams), "login", "--config", location, "--context", "test")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Connecting Docker registry with context [test]")
+	assert.Contains(t, out.String(), "Login Succeeded\n")
+
+	db, err = data.NewDBWithOptions(data.Options{Location: location})
+	assert.NoError(t, err)
+	current, err := db.CurrentContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "current", current.Name)
+
+	// The default storage file is left untouched.
+	_, err = os.Stat(filepath.Join(home, ".regi", "regi.yaml"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCmdRootServerEnv(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("DOCKER_CONFIG", filepath.Join(home, ".docker"))
+	t.Setenv(data.ContextEnv, "")
+
+	server := registrytest.NewServer(t)
+	server.Username, server.Password = "regi", "s3cret"
+
+	t.Setenv(data.ServerEnv, server.URL)
+	t.Setenv(data.UserEnv, "regi")
+	t.Setenv(data.PasswordEnv, "s3cret")
+
+	// No storage file is needed, nor created.
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: strings.NewReader(""), Out: out, ErrOut: os.Stderr}
+	_, err := executeCommand(newRegiCommand(streams), "login")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Login Succeeded\n")
+
+	_, err = os.Stat(filepath.Join(home, ".regi", "regi.yaml"))
+	assert.True(t, os.IsNotExist(err))
+}
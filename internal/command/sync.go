@@ -0,0 +1,368 @@
+package command
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/output"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"regexp"
+)
+
+const (
+	// msgShortSyncCmd is the short version description for sync command.
+	msgShortSyncCmd = "Mirror repositories from the registry of a context to the registry of another one."
+)
+
+const (
+	// syncActionCreate copies a tag missing in the destination.
+	syncActionCreate = "create"
+
+	// syncActionUpdate copies a tag pointing to another digest in the destination.
+	syncActionUpdate = "update"
+
+	// syncActionSkip leaves a tag up to date in the destination.
+	syncActionSkip = "skip"
+)
+
+// syncItem is the output of a tag to sync.
+type syncItem struct {
+	Repository string `json:"repository" yaml:"repository"`
+	Tag        string `json:"tag" yaml:"tag"`
+	Digest     string `json:"digest" yaml:"digest"`
+	Action     string `json:"action" yaml:"action"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// syncReport is the output of `sync`.
+type syncReport struct {
+	Source       string     `json:"source" yaml:"source"`
+	Destination  string     `json:"destination" yaml:"destination"`
+	DryRun       bool       `json:"dryRun" yaml:"dryRun"`
+	Repositories int        `json:"repositories" yaml:"repositories"`
+	Created      int        `json:"created" yaml:"created"`
+	Updated      int        `json:"updated" yaml:"updated"`
+	UpToDate     int        `json:"upToDate" yaml:"upToDate"`
+	Failed       int        `json:"failed" yaml:"failed"`
+	Items        []syncItem `json:"items" yaml:"items"`
+}
+
+// Header implements output.Table.
+func (r syncReport) Header() []string {
+	return []string{"REPOSITORY", "TAG", "ACTION", "DIGEST", "ERROR"}
+}
+
+// Rows implements output.Table.
+func (r syncReport) Rows() [][]string {
+	var rows [][]string
+	for _, item := range r.Items {
+		rows = append(rows, []string{item.Repository, item.Tag, item.Action, item.Digest, item.Error})
+	}
+	return rows
+}
+
+// count counts an item by its action, once it is done.
+func (r *syncReport) count(item syncItem) {
+	switch {
+	case len(item.Error) > 0:
+		r.Failed++
+	case item.Action == syncActionCreate:
+		r.Created++
+	case item.Action == syncActionUpdate:
+		r.Updated++
+	default:
+		r.UpToDate++
+	}
+}
+
+// syncFilter selects repositories and tags by regexes. A name is selected if it matches any
+// of the include regexes, or if there is none, and matches none of the exclude regexes.
+type syncFilter struct {
+	include []*regexp.Regexp
+	exclude []*regexp.Regexp
+}
+
+// newSyncFilter compiles the include and exclude regexes given by the flags.
+func newSyncFilter(cmd *cobra.Command, includeFlag, excludeFlag string) (*syncFilter, error) {
+	f := &syncFilter{}
+	for _, v := range []struct {
+		flag    string
+		regexps *[]*regexp.Regexp
+	}{
+		{flag: includeFlag, regexps: &f.include},
+		{flag: excludeFlag, regexps: &f.exclude},
+	} {
+		exprs, err := cmd.Flags().GetStringArray(v.flag)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, expr := range exprs {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid regex %q of --%s", expr, v.flag)
+			}
+			*v.regexps = append(*v.regexps, re)
+		}
+	}
+	return f, nil
+}
+
+// match tells if the name is selected.
+func (f *syncFilter) match(name string) bool {
+	for _, re := range f.exclude {
+		if re.MatchString(name) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, re := range f.include {
+		if re.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the selected names.
+func (f *syncFilter) filter(names []string) []string {
+	var selected []string
+	for _, name := range names {
+		if f.match(name) {
+			selected = append(selected, name)
+		}
+	}
+	return selected
+}
+
+// NewCmdSync creates a sync command.
+func NewCmdSync(streams io.Streams) *cobra.Command {
+	o := NewCmdImageOptions(nil, streams)
+
+	cmd := &cobra.Command{
+		Use:                   "sync <src-context> <dst-context> [repository...]",
+		DisableFlagsInUseLine: true,
+		Short:                 msgShortSyncCmd,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			var err error
+			o.DB, err = openDB(cmd)
+			checkErr(err)
+		},
+		Run: func(cmd *cobra.Command, args []string) {
+			checkErr(o.syncCmdRun(cmd, args))
+		},
+	}
+
+	cmd.Flags().StringArray("include", nil, "regex of the repositories to sync, may be repeated")
+	cmd.Flags().StringArray("exclude", nil, "regex of the repositories not to sync, may be repeated")
+	cmd.Flags().StringArray("include-tag", nil, "regex of the tags to sync, may be repeated")
+	cmd.Flags().StringArray("exclude-tag", nil, "regex of the tags not to sync, may be repeated")
+	cmd.Flags().Bool("dry-run", false, "show what would be synced without copying anything")
+
+	return cmd
+}
+
+// syncCmdRun mirrors the repositories of the source context, or the given ones, to the
+// destination context. Only the tags missing in the destination, or pointing to another
+// digest there, are copied.
+func (o *cmdImageOptions) syncCmdRun(cmd *cobra.Command, args []string) error {
+	// Verify arguments.
+	if len(args) < 2 {
+		return errors.New("source and destination contexts are not specified")
+	}
+
+	format, err := outputFormat(cmd)
+	if err != nil {
+		return err
+	}
+
+	dryRun, err := cmd.Flags().GetBool("dry-run")
+	if err != nil {
+		return err
+	}
+
+	repoFilter, err := newSyncFilter(cmd, "include", "exclude")
+	if err != nil {
+		return err
+	}
+
+	tagFilter, err := newSyncFilter(cmd, "include-tag", "exclude-tag")
+	if err != nil {
+		return err
+	}
+
+	srcReg, err := o.contextOf(args[0])
+	if err != nil {
+		return err
+	}
+
+	dstReg, err := o.contextOf(args[1])
+	if err != nil {
+		return err
+	}
+
+	srcClient, err := o.newTransferClient(srcReg)
+	if err != nil {
+		return err
+	}
+
+	dstClient, err := o.newTransferClient(dstReg)
+	if err != nil {
+		return err
+	}
+
+	// The whole registry is synced unless repositories are given.
+	repositories := args[2:]
+	if len(repositories) == 0 {
+		repositories, err = srcClient.Catalog(0)
+		if err != nil {
+			return explainRegistryError(err, "the catalog of "+args[0])
+		}
+	}
+
+	report := syncReport{Source: args[0], Destination: args[1], DryRun: dryRun, Items: []syncItem{}}
+	for _, repo := range repoFilter.filter(repositories) {
+		report.Repositories++
+
+		// A repository which can not be planned fails as a whole, the others are still synced.
+		items, err := syncPlan(srcClient, dstClient, repo, tagFilter)
+		if err != nil {
+			item := syncItem{Repository: repo, Error: err.Error()}
+			if format.IsDefault() && !dryRun {
+				o.Out.Write([]byte(syncMessage(item)))
+			}
+			report.count(item)
+			report.Items = append(report.Items, item)
+			continue
+		}
+
+		for _, item := range items {
+			// Tags which failed to be planned are reported without being copied.
+			copied := !dryRun && len(item.Error) == 0 && item.Action != syncActionSkip
+			if copied {
+				if err := syncTag(srcClient, dstClient, item); err != nil {
+					item.Error = err.Error()
+				}
+			}
+			if format.IsDefault() && !dryRun && (copied || len(item.Error) > 0) {
+				o.Out.Write([]byte(syncMessage(item)))
+			}
+			report.count(item)
+			report.Items = append(report.Items, item)
+		}
+	}
+
+	if !format.IsDefault() {
+		if err := format.Print(o.Out, report); err != nil {
+			return err
+		}
+	} else {
+		o.printSyncReport(report)
+	}
+
+	if report.Failed > 0 {
+		return errors.Errorf("%d of %d items failed to sync", report.Failed, len(report.Items))
+	}
+	return nil
+}
+
+// syncPlan tells what to do with each selected tag of the repository, comparing the digests
+// of the source and the destination. A tag which can not be compared is given with its error,
+// the other tags are still planned.
+func syncPlan(src, dst *registry.Client, repo string, tagFilter *syncFilter) ([]syncItem, error) {
+	tags, err := src.Tags(repo, 0)
+	if err != nil {
+		return nil, explainRegistryError(err, "tags of "+repo)
+	}
+
+	// A repository missing in the destination is created by the first copy.
+	dstTags, err := dst.Tags(repo, 0)
+	if err != nil && !isNotFound(err) {
+		return nil, explainRegistryError(err, "tags of "+repo)
+	}
+
+	existing := map[string]bool{}
+	for _, tag := range dstTags {
+		existing[tag] = true
+	}
+
+	var items []syncItem
+	for _, tag := range tagFilter.filter(tags) {
+		item := syncItem{Repository: repo, Tag: tag, Action: syncActionCreate}
+		desc, err := src.HeadManifest(repo, tag)
+		if err != nil {
+			item.Error = explainRegistryError(err, imageReference(repo, tag)).Error()
+			items = append(items, item)
+			continue
+		}
+
+		item.Digest = desc.Digest
+		if existing[tag] {
+			current, err := dst.HeadManifest(repo, tag)
+			switch {
+			case err != nil && !isNotFound(err):
+				item.Action = syncActionUpdate
+				item.Error = explainRegistryError(err, imageReference(repo, tag)).Error()
+			case err == nil && current.Digest == desc.Digest:
+				item.Action = syncActionSkip
+			case err == nil:
+				item.Action = syncActionUpdate
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// syncTag copies a tag. The copy fails if the source changed since the plan was made.
+func syncTag(src, dst *registry.Client, item syncItem) error {
+	manifest, err := src.GetManifest(item.Repository, item.Digest)
+	if err != nil {
+		return explainRegistryError(err, imageReference(item.Repository, item.Digest))
+	}
+
+	err = registry.Copy(src.Repository(item.Repository), dst.Repository(item.Repository), manifest, item.Tag, nil)
+	if err != nil {
+		return explainRegistryError(err, imageReference(item.Repository, item.Tag))
+	}
+	return nil
+}
+
+// syncMessage tells the result of the sync of a tag, or of a repository failing as a whole.
+func syncMessage(item syncItem) string {
+	ref := item.Repository
+	if len(item.Tag) > 0 {
+		ref = imageReference(item.Repository, item.Tag)
+	}
+	switch {
+	case len(item.Error) > 0:
+		return fmt.Sprintf("%s: failed: %s\n", ref, item.Error)
+	case item.Action == syncActionCreate:
+		return fmt.Sprintf("%s: created %s\n", ref, item.Digest)
+	default:
+		return fmt.Sprintf("%s: updated to %s\n", ref, item.Digest)
+	}
+}
+
+// printSyncReport prints the plan of a dry run, and the summary.
+func (o *cmdImageOptions) printSyncReport(report syncReport) {
+	if report.DryRun {
+		if len(report.Items) > 0 {
+			output.PrintTable(o.Out, report)
+		}
+		o.Out.Write([]byte(fmt.Sprintf(
+			"\nDry run of %s to %s: %d to create, %d to update, %d up to date, %d failed, in %d repositories\n",
+			report.Source, report.Destination, report.Created, report.Updated, report.UpToDate, report.Failed,
+			report.Repositories)))
+		return
+	}
+
+	o.Out.Write([]byte(fmt.Sprintf(
+		"\nSynced %s to %s: %d created, %d updated, %d up to date, %d failed, in %d repositories\n",
+		report.Source, report.Destination, report.Created, report.Updated, report.UpToDate, report.Failed,
+		report.Repositories)))
+}
@@ -0,0 +1,138 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/io"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestCmdSync(t *testing.T) {
+	main := registrytest.NewServer(t)
+	v1 := main.AddImage("app", "v1", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app v1"))
+	v2 := main.AddImage("app", "v2", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app v2"))
+	main.AddImage("app", "dev", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app dev"))
+	tools := main.AddIndex("tools", "latest", "linux/amd64", "linux/arm64")
+	main.AddImage("scratch/test", "latest", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("test"))
+
+	mirror := registrytest.NewServer(t)
+	mirror.AddImage("app", "v1", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app v1"))
+	mirror.AddImage("app", "v2", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("outdated"))
+
+	useTestRegistry(t, main)
+	addTestContext(t, "mirror", mirror)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+
+	// The plan of a dry run, nothing is copied.
+	_, err := executeCommand(newRegiCommand(streams), "sync", "test", "mirror",
+		"--exclude", "^scratch/", "--exclude-tag", "^dev$", "--dry-run", "-o", "json")
+	assert.NoError(t, err)
+
+	var report syncReport
+	assert.NoError(t, json.Unmarshal(out.Bytes(), &report))
+	assert.Equal(t, []syncItem{
+		{Repository: "app", Tag: "v1", Digest: v1, Action: syncActionSkip},
+		{Repository: "app", Tag: "v2", Digest: v2, Action: syncActionUpdate},
+		{Repository: "tools", Tag: "latest", Digest: tools, Action: syncActionCreate},
+	}, report.Items)
+	assert.Equal(t, 2, report.Repositories)
+	assert.Equal(t, 0, mirror.Uploaded())
+
+	// Only the missing and changed tags are copied.
+	out.Reset()
+	_, err = executeCommand(NewCmdSync(streams), "test", "mirror", "--exclude", "^scratch/", "--exclude-tag", "^dev$")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "app:v2: updated to "+v2)
+	assert.Contains(t, out.String(), "tools:latest: created "+tools)
+	assert.Contains(t, out.String(), "Synced test to mirror: 1 created, 1 updated, 1 up to date, 0 failed, in 2 repositories")
+	assert.Equal(t, map[string]string{"v1": v1, "v2": v2}, mirror.Tags("app"))
+	assert.Equal(t, map[string]string{"latest": tools}, mirror.Tags("tools"))
+	assert.False(t, mirror.HasManifest("scratch/test", tools))
+
+	// Selected repositories and tags, everything else is up to date.
+	out.Reset()
+	_, err = executeCommand(NewCmdSync(streams), "test", "mirror", "app", "--include-tag", "^v", "--dry-run")
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Dry run of test to mirror: 0 to create, 0 to update, 2 up to date, 0 failed, in 1 repositories")
+}
+
+func TestCmdSyncPlanFailure(t *testing.T) {
+	main := registrytest.NewServer(t)
+	main.AddImage("app", "v1", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app v1"))
+	v2 := main.AddImage("app", "v2", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app v2"))
+	tools := main.AddImage("tools", "latest", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("tools"))
+
+	// The mirror fails to tell the digest of app:v1.
+	mirror := registrytest.NewServer(t)
+	mirror.AddImage("app", "v1", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("outdated"))
+	mirror.Fail = func(r *http.Request) bool {
+		return r.Method == http.MethodHead && r.URL.Path == "/v2/app/manifests/v1"
+	}
+
+	useTestRegistry(t, main)
+	addTestContext(t, "mirror", mirror)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+	cmd, _, err := NewCmdSync(streams).Find(nil)
+	assert.NoError(t, err)
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+
+	// The other tags are still synced, and the sync fails in the end.
+	err = o.syncCmdRun(cmd, []string{"test", "mirror"})
+	assert.EqualError(t, err, "1 of 3 items failed to sync")
+	assert.Contains(t, out.String(), "app:v1: failed: ")
+	assert.Contains(t, out.String(), "app:v2: created "+v2)
+	assert.Contains(t, out.String(), "tools:latest: created "+tools)
+	assert.Contains(t, out.String(), "Synced test to mirror: 2 created, 0 updated, 0 up to date, 1 failed, in 2 repositories")
+	assert.Equal(t, map[string]string{"latest": tools}, mirror.Tags("tools"))
+}
+
+func TestCmdSyncRepositoryFailure(t *testing.T) {
+	main := registrytest.NewServer(t)
+	main.AddImage("app", "v1", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("app v1"))
+	tools := main.AddImage("tools", "latest", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("tools"))
+
+	// The mirror fails to list the tags of app.
+	mirror := registrytest.NewServer(t)
+	mirror.Fail = func(r *http.Request) bool {
+		return r.URL.Path == "/v2/app/tags/list"
+	}
+
+	useTestRegistry(t, main)
+	addTestContext(t, "mirror", mirror)
+
+	out := new(bytes.Buffer)
+	streams := io.Streams{In: os.Stdin, Out: out, ErrOut: os.Stderr}
+	cmd, _, err := NewCmdSync(streams).Find(nil)
+	assert.NoError(t, err)
+	o := NewCmdImageOptions(mustOpenDB(t), streams)
+
+	// The other repositories are still synced, and the sync fails in the end.
+	err = o.syncCmdRun(cmd, []string{"test", "mirror"})
+	assert.EqualError(t, err, "1 of 2 items failed to sync")
+	assert.Contains(t, out.String(), "app: failed: ")
+	assert.Contains(t, out.String(), "tools:latest: created "+tools)
+	assert.Contains(t, out.String(), "Synced test to mirror: 1 created, 0 updated, 0 up to date, 1 failed, in 2 repositories")
+	assert.Equal(t, map[string]string{"latest": tools}, mirror.Tags("tools"))
+}
+
+func TestCmdSyncErrors(t *testing.T) {
+	useTestRegistry(t, registrytest.NewServer(t))
+
+	cmd := NewCmdSync(io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr})
+	o := NewCmdImageOptions(mustOpenDB(t), io.Streams{In: os.Stdin, Out: new(bytes.Buffer), ErrOut: os.Stderr})
+
+	assert.EqualError(t, o.syncCmdRun(cmd, []string{"test"}), "source and destination contexts are not specified")
+	assert.EqualError(t, o.syncCmdRun(cmd, []string{"test", "mirror"}),
+		`context "mirror" not found, please add it with 'regi ctx add' first`)
+
+	assert.NoError(t, cmd.Flags().Set("include", "("))
+	assert.Error(t, o.syncCmdRun(cmd, []string{"test", "test"}))
+}
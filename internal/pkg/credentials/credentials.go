@@ -0,0 +1,95 @@
+// Package credentials keeps registry credentials out of regi's storage, either in a Docker
+// credential helper or in the Docker config file.
+package credentials
+
+import (
+	"strings"
+)
+
+const (
+	// DockerConfig names the store which is the Docker config file, e.g. ~/.docker/config.json.
+	// Its entries may be kept by credential helpers, as set by credsStore and credHelpers.
+	DockerConfig = "docker-config"
+
+	// dockerHubServer is the key Docker keeps the credentials of Docker Hub under.
+	dockerHubServer = "https://index.docker.io/v1/"
+
+	// IdentityTokenUser is the username Docker and credential helpers give with an identity
+	// token, in place of a password.
+	IdentityTokenUser = "<token>"
+)
+
+// Credentials are the username and the secret, e.g. a password or an identity token, of a registry.
+type Credentials struct {
+	Username string
+	Secret   string
+}
+
+// IsIdentityToken tells if the secret is an identity token, which is an OAuth2 refresh token
+// to exchange at the token server rather than a password.
+func (c Credentials) IsIdentityToken() bool {
+	return c.Username == IdentityTokenUser
+}
+
+// Store keeps the credentials of registries, by server address.
+type Store interface {
+	// Get returns the credentials of the server, or nil if there are none.
+	Get(server string) (*Credentials, error)
+
+	// Store keeps the credentials of the server.
+	Store(server string, c Credentials) error
+
+	// Erase forgets the credentials of the server. Erasing missing credentials is not an error.
+	Erase(server string) error
+}
+
+// NewStore returns the store with the name, either DockerConfig or the name of a Docker
+// credential helper, e.g. 'osxkeychain' for the program 'docker-credential-osxkeychain'.
+func NewStore(name string) Store {
+	if name == DockerConfig {
+		return NewDockerConfigStore(DefaultDockerConfigPath())
+	}
+	return NewHelperStore(name)
+}
+
+// DockerServer returns the key 'docker login' keeps the credentials of the server under, the
+// host of the server, or the legacy index address for Docker Hub.
+func DockerServer(server string) string {
+	host := serverHost(server)
+	if isDockerHub(host) {
+		return dockerHubServer
+	}
+	return host
+}
+
+// serverKeys returns the keys a server may be known by, e.g. 'registry.example.com',
+// 'https://registry.example.com' and 'http://registry.example.com', the given one first.
+func serverKeys(server string) []string {
+	host := serverHost(server)
+	keys := []string{server}
+	for _, k := range []string{host, "https://" + host, "http://" + host} {
+		if k != server {
+			keys = append(keys, k)
+		}
+	}
+
+	// Docker Hub goes by many names.
+	if isDockerHub(host) {
+		keys = append(keys, dockerHubServer)
+	}
+	return keys
+}
+
+// serverHost returns the host of the server address, without scheme and path.
+func serverHost(server string) string {
+	host := strings.TrimPrefix(strings.TrimPrefix(server, "https://"), "http://")
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	return host
+}
+
+// isDockerHub tells whether the host is one of the names of Docker Hub.
+func isDockerHub(host string) bool {
+	return host == "docker.io" || host == "index.docker.io" || host == "registry-1.docker.io"
+}
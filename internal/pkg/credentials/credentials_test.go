@@ -0,0 +1,28 @@
+package credentials
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestServerKeys(t *testing.T) {
+	assert.Equal(t, []string{"registry.test", "https://registry.test", "http://registry.test"},
+		serverKeys("registry.test"))
+	assert.Equal(t, []string{"https://registry.test/", "registry.test", "https://registry.test", "http://registry.test"},
+		serverKeys("https://registry.test/"))
+	assert.Equal(t, []string{"docker.io", "https://docker.io", "http://docker.io", dockerHubServer},
+		serverKeys("docker.io"))
+}
+
+func TestDockerServer(t *testing.T) {
+	assert.Equal(t, "registry.test:5000", DockerServer("http://registry.test:5000/"))
+	assert.Equal(t, "registry.test", DockerServer("registry.test"))
+	assert.Equal(t, dockerHubServer, DockerServer("https://registry-1.docker.io"))
+	assert.Equal(t, dockerHubServer, DockerServer(dockerHubServer))
+}
+
+func TestNewStore(t *testing.T) {
+	t.Setenv(dockerConfigEnv, "/etc/docker")
+	assert.Equal(t, &dockerConfigStore{path: "/etc/docker/config.json"}, NewStore(DockerConfig))
+	assert.Equal(t, &helperStore{program: "docker-credential-pass"}, NewStore("pass"))
+}
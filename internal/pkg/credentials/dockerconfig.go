@@ -0,0 +1,298 @@
+package credentials
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	// dockerConfigEnv overrides the directory of the Docker config file.
+	dockerConfigEnv = "DOCKER_CONFIG"
+)
+
+// DefaultDockerConfigPath returns the path of the Docker config file, ~/.docker/config.json
+// unless DOCKER_CONFIG tells another directory.
+func DefaultDockerConfigPath() string {
+	if dir := os.Getenv(dockerConfigEnv); len(dir) > 0 {
+		return filepath.Join(dir, "config.json")
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	return filepath.Join(home, ".docker", "config.json")
+}
+
+// dockerAuth is an entry of the auths of the Docker config file.
+type dockerAuth struct {
+	Auth          string `json:"auth,omitempty"`
+	Username      string `json:"username,omitempty"`
+	Password      string `json:"password,omitempty"`
+	IdentityToken string `json:"identitytoken,omitempty"`
+}
+
+// dockerConfig is the Docker config file. Settings other than credentials are kept as they are.
+type dockerConfig struct {
+	raw map[string]json.RawMessage
+
+	Auths       map[string]dockerAuth
+	CredsStore  string
+	CredHelpers map[string]string
+}
+
+// DockerConfigEntry is a registry known by the Docker config file.
+type DockerConfigEntry struct {
+	// Server is the key of the registry, e.g. 'registry.example.com' or 'https://index.docker.io/v1/'.
+	Server string
+
+	// Helper is the name of the credential helper keeping the credentials, if any.
+	Helper string
+
+	// Credentials are the credentials of the registry, nil if there are none.
+	Credentials *Credentials
+
+	// Err tells why the credentials could not be read, e.g. the helper is missing.
+	Err error
+}
+
+// ReadDockerConfig returns the registries of the Docker config file at the path, with their
+// credentials, sorted by server. A missing file has none.
+func ReadDockerConfig(p string) ([]DockerConfigEntry, error) {
+	s := &dockerConfigStore{path: p}
+	config, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	servers := map[string]bool{}
+	for server := range config.Auths {
+		servers[server] = true
+	}
+	for server := range config.CredHelpers {
+		servers[server] = true
+	}
+
+	var entries []DockerConfigEntry
+	for server := range servers {
+		entry := DockerConfigEntry{Server: server}
+		if helper := config.helperName(server); len(helper) > 0 {
+			entry.Helper = helper
+			entry.Credentials, entry.Err = NewHelperStore(helper).Get(server)
+		} else {
+			entry.Credentials, entry.Err = config.Auths[server].credentials()
+			if entry.Err != nil {
+				entry.Err = errors.Wrapf(entry.Err, "invalid auth of %s in %s", server, p)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Server < entries[j].Server
+	})
+	return entries, nil
+}
+
+// dockerConfigStore keeps credentials in the Docker config file, or in the credential helpers
+// it sets, the same way as 'docker login' does.
+type dockerConfigStore struct {
+	path string
+}
+
+// NewDockerConfigStore returns a Store using the Docker config file at the path.
+func NewDockerConfigStore(p string) Store {
+	return &dockerConfigStore{path: p}
+}
+
+// Get implements Store.
+func (s *dockerConfigStore) Get(server string) (*Credentials, error) {
+	config, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if helper := config.helper(server); helper != nil {
+		return helper.Get(server)
+	}
+
+	for _, key := range serverKeys(server) {
+		if auth, ok := config.Auths[key]; ok {
+			c, err := auth.credentials()
+			if err != nil {
+				return nil, errors.Wrapf(err, "invalid auth of %s in %s", key, s.path)
+			}
+			if c != nil {
+				return c, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+// Store implements Store.
+func (s *dockerConfigStore) Store(server string, c Credentials) error {
+	config, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if helper := config.helper(server); helper != nil {
+		if err := helper.Store(server, c); err != nil {
+			return err
+		}
+
+		// Docker lists the servers whose credentials are in a helper as empty auths.
+		config.Auths[server] = dockerAuth{}
+		return s.save(config)
+	}
+
+	if c.IsIdentityToken() {
+		config.Auths[server] = dockerAuth{IdentityToken: c.Secret}
+	} else {
+		config.Auths[server] = dockerAuth{Auth: base64.StdEncoding.EncodeToString([]byte(c.Username + ":" + c.Secret))}
+	}
+	return s.save(config)
+}
+
+// Erase implements Store.
+func (s *dockerConfigStore) Erase(server string) error {
+	config, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	if helper := config.helper(server); helper != nil {
+		if err := helper.Erase(server); err != nil {
+			return err
+		}
+	}
+
+	erased := false
+	for _, key := range serverKeys(server) {
+		if _, ok := config.Auths[key]; ok {
+			delete(config.Auths, key)
+			erased = true
+		}
+	}
+
+	if !erased {
+		return nil
+	}
+	return s.save(config)
+}
+
+// helper returns the credential helper keeping the credentials of the server, if any.
+func (c *dockerConfig) helper(server string) Store {
+	if name := c.helperName(server); len(name) > 0 {
+		return NewHelperStore(name)
+	}
+	return nil
+}
+
+// helperName returns the name of the credential helper keeping the credentials of the server,
+// empty if there is none.
+func (c *dockerConfig) helperName(server string) string {
+	for _, key := range serverKeys(server) {
+		if name, ok := c.CredHelpers[key]; ok {
+			return name
+		}
+	}
+	return c.CredsStore
+}
+
+// credentials decodes an auth entry, nil if it holds no credentials.
+func (a dockerAuth) credentials() (*Credentials, error) {
+	switch {
+	case len(a.IdentityToken) > 0:
+		return &Credentials{Username: IdentityTokenUser, Secret: a.IdentityToken}, nil
+	case len(a.Auth) > 0:
+		decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+		if err != nil {
+			return nil, err
+		}
+
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return nil, errors.New("auth must be base64 of <username>:<password>")
+		}
+		return &Credentials{Username: parts[0], Secret: parts[1]}, nil
+	case len(a.Username) > 0:
+		return &Credentials{Username: a.Username, Secret: a.Password}, nil
+	default:
+		return nil, nil
+	}
+}
+
+// load reads the Docker config file. A missing file is an empty config.
+func (s *dockerConfigStore) load() (*dockerConfig, error) {
+	config := &dockerConfig{raw: map[string]json.RawMessage{}, Auths: map[string]dockerAuth{}}
+
+	content, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &config.raw); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", s.path)
+	}
+
+	for key, v := range map[string]interface{}{
+		"auths":       &config.Auths,
+		"credsStore":  &config.CredsStore,
+		"credHelpers": &config.CredHelpers,
+	} {
+		if raw, ok := config.raw[key]; ok {
+			if err := json.Unmarshal(raw, v); err != nil {
+				return nil, errors.Wrapf(err, "unable to decode %s of %s", key, s.path)
+			}
+		}
+	}
+
+	if config.Auths == nil {
+		config.Auths = map[string]dockerAuth{}
+	}
+	return config, nil
+}
+
+// save writes the Docker config file, for the user only, replacing it at once.
+func (s *dockerConfigStore) save(config *dockerConfig) error {
+	auths, err := json.Marshal(config.Auths)
+	if err != nil {
+		return err
+	}
+	config.raw["auths"] = auths
+
+	content, err := json.MarshalIndent(config.raw, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(s.path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".config.json.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,163 @@
+package credentials
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDefaultDockerConfigPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(dockerConfigEnv, "")
+	assert.Equal(t, filepath.Join(home, ".docker", "config.json"), DefaultDockerConfigPath())
+
+	t.Setenv(dockerConfigEnv, "/etc/docker")
+	assert.Equal(t, "/etc/docker/config.json", DefaultDockerConfigPath())
+}
+
+func TestDockerConfigStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1Yi1wYXNz"},
+		"plain.test": {"username": "plain", "password": "plain-pass"},
+		"token.test": {"identitytoken": "tok"}
+	},
+	"proxies": {"default": {"httpProxy": "http://proxy.test"}}
+}`), 0600))
+	store := NewDockerConfigStore(path)
+
+	for server, want := range map[string]*Credentials{
+		"docker.io":          {Username: "hub", Secret: "hub-pass"},
+		"https://plain.test": {Username: "plain", Secret: "plain-pass"},
+		"token.test":         {Username: "<token>", Secret: "tok"},
+		"unknown.test":       nil,
+	} {
+		c, err := store.Get(server)
+		assert.NoError(t, err)
+		assert.Equal(t, want, c, server)
+	}
+
+	// Stored as base64 auth, other settings are kept.
+	assert.NoError(t, store.Store("registry.test", Credentials{Username: "regi", Secret: "s3cret"}))
+	c, err := store.Get("https://registry.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "regi", Secret: "s3cret"}, c)
+
+	var config struct {
+		Auths   map[string]dockerAuth
+		Proxies json.RawMessage
+	}
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, json.Unmarshal(content, &config))
+	assert.Equal(t, dockerAuth{Auth: "cmVnaTpzM2NyZXQ="}, config.Auths["registry.test"])
+	assert.JSONEq(t, `{"default": {"httpProxy": "http://proxy.test"}}`, string(config.Proxies))
+
+	// Identity tokens are stored as such, not as a password.
+	assert.NoError(t, store.Store("token.test", Credentials{Username: IdentityTokenUser, Secret: "tok2"}))
+	c, err = store.Get("token.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: IdentityTokenUser, Secret: "tok2"}, c)
+
+	info, err := os.Stat(path)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	assert.NoError(t, store.Erase("https://plain.test"))
+	c, err = store.Get("plain.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+}
+
+func TestDockerConfigStore_Helpers(t *testing.T) {
+	installFakeHelper(t)
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"credHelpers": {"registry.test": "fake"}}`), 0600))
+	store := NewDockerConfigStore(path)
+
+	// Kept by the helper of the server, listed as an empty auth.
+	assert.NoError(t, store.Store("registry.test", Credentials{Username: "regi", Secret: "s3cret"}))
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cret")
+
+	c, err := store.Get("registry.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "regi", Secret: "s3cret"}, c)
+
+	// Servers without helper use the file.
+	assert.NoError(t, store.Store("other.test", Credentials{Username: "other", Secret: "other-pass"}))
+	c, err = store.Get("other.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "other", Secret: "other-pass"}, c)
+
+	assert.NoError(t, store.Erase("registry.test"))
+	c, err = store.Get("registry.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	// The default helper keeps all of them.
+	assert.NoError(t, os.WriteFile(path, []byte(`{"credsStore": "fake"}`), 0600))
+	assert.NoError(t, store.Store("other.test", Credentials{Username: "other", Secret: "other-pass"}))
+	content, err = os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "other-pass")
+
+	c, err = store.Get("https://other.test")
+	assert.NoError(t, err)
+	assert.Equal(t, &Credentials{Username: "other", Secret: "other-pass"}, c)
+}
+
+func TestDockerConfigStore_Invalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{"auths": {"registry.test": {"auth": "!!"}}}`), 0600))
+
+	_, err := NewDockerConfigStore(path).Get("registry.test")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid auth of registry.test in "+path)
+
+	assert.NoError(t, os.WriteFile(path, []byte(`not json`), 0600))
+	_, err = NewDockerConfigStore(path).Get("registry.test")
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to decode "+path)
+}
+
+func TestReadDockerConfig(t *testing.T) {
+	dir := installFakeHelper(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "helper.test"),
+		[]byte(`{"ServerURL":"helper.test","Username":"regi","Secret":"s3cret"}`), 0600))
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	assert.NoError(t, os.WriteFile(path, []byte(`{
+	"auths": {
+		"https://index.docker.io/v1/": {"auth": "aHViOmh1Yi1wYXNz"},
+		"empty.test": {},
+		"broken.test": {"auth": "!!"}
+	},
+	"credHelpers": {"helper.test": "fake", "missing.test": "missing"}
+}`), 0600))
+
+	entries, err := ReadDockerConfig(path)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 5)
+
+	assert.Equal(t, "broken.test", entries[0].Server)
+	assert.Error(t, entries[0].Err)
+	assert.Equal(t, DockerConfigEntry{Server: "empty.test"}, entries[1])
+	assert.Equal(t, DockerConfigEntry{Server: "helper.test", Helper: "fake",
+		Credentials: &Credentials{Username: "regi", Secret: "s3cret"}}, entries[2])
+	assert.Equal(t, DockerConfigEntry{Server: dockerHubServer,
+		Credentials: &Credentials{Username: "hub", Secret: "hub-pass"}}, entries[3])
+	assert.Equal(t, "missing.test", entries[4].Server)
+	assert.EqualError(t, entries[4].Err, "credential helper docker-credential-missing not found in PATH")
+
+	// A missing file has no registry.
+	entries, err = ReadDockerConfig(filepath.Join(t.TempDir(), "config.json"))
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+}
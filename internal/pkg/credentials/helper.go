@@ -0,0 +1,128 @@
+package credentials
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// helperPrefix prefixes the programs of Docker credential helpers.
+const helperPrefix = "docker-credential-"
+
+// errCredentialsNotFound matches what helpers answer when they have no credentials for a server,
+// e.g. 'credentials not found in native keychain' as the ErrCredentialsNotFound of
+// docker-credential-helpers, or 'The specified item could not be found in the keychain.'
+var errCredentialsNotFound = regexp.MustCompile(`(?i)\bnot (be )?found\b`)
+
+// helperCredentials is the message of the protocol of Docker credential helpers.
+type helperCredentials struct {
+	ServerURL string `json:"ServerURL"`
+	Username  string `json:"Username"`
+	Secret    string `json:"Secret"`
+}
+
+// helperStore keeps credentials with a Docker credential helper, a program speaking the get,
+// store and erase protocol over stdin and stdout.
+type helperStore struct {
+	program string
+}
+
+// NewHelperStore returns a Store using the Docker credential helper with the name, e.g.
+// 'pass' for the program 'docker-credential-pass' found in PATH.
+func NewHelperStore(name string) Store {
+	return &helperStore{program: helperPrefix + name}
+}
+
+// Get implements Store.
+func (s *helperStore) Get(server string) (*Credentials, error) {
+	for _, key := range serverKeys(server) {
+		out, err := s.run("get", key)
+		if isNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var c helperCredentials
+		if err := json.Unmarshal(out, &c); err != nil {
+			return nil, errors.Wrapf(err, "unable to decode the answer of %s", s.program)
+		}
+		return &Credentials{Username: c.Username, Secret: c.Secret}, nil
+	}
+	return nil, nil
+}
+
+// Store implements Store.
+func (s *helperStore) Store(server string, c Credentials) error {
+	in, err := json.Marshal(helperCredentials{ServerURL: server, Username: c.Username, Secret: c.Secret})
+	if err != nil {
+		return err
+	}
+
+	_, err = s.run("store", string(in))
+	return err
+}
+
+// Erase implements Store.
+func (s *helperStore) Erase(server string) error {
+	for _, key := range serverKeys(server) {
+		if _, err := s.run("erase", key); err != nil && !isNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// run runs the helper with the action, writing the input to its stdin, and returns its stdout.
+func (s *helperStore) run(action, input string) ([]byte, error) {
+	cmd := exec.Command(s.program, action)
+	cmd.Stdin = strings.NewReader(input)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.Error); ok {
+			return nil, errors.Errorf("credential helper %s not found in PATH", s.program)
+		}
+
+		// Helpers tell what went wrong on stdout.
+		return nil, &helperError{
+			program: s.program,
+			action:  action,
+			output:  strings.TrimSpace(stdout.String() + " " + stderr.String()),
+			err:     err,
+		}
+	}
+	return stdout.Bytes(), nil
+}
+
+// helperError is a failed run of a credential helper.
+type helperError struct {
+	program string
+	action  string
+	output  string
+	err     error
+}
+
+// Error implements error.
+func (e *helperError) Error() string {
+	msg := e.output
+	if len(msg) == 0 {
+		msg = e.err.Error()
+	}
+	return fmt.Sprintf("%s %s failed: %s", e.program, e.action, msg)
+}
+
+// isNotFound tells if the error is a helper failing because it has no credentials for a server.
+// Helpers do not agree on the message, so any failure telling something is not found is.
+func isNotFound(err error) bool {
+	e, ok := err.(*helperError)
+	return ok && errCredentialsNotFound.MatchString(e.output)
+}
@@ -0,0 +1,112 @@
+package credentials
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeHelper is a Docker credential helper keeping credentials in files, one per server. It
+// answers FAKE_HELPER_NOT_FOUND for missing credentials, as helpers do not agree on the message.
+const fakeHelper = `#!/bin/sh
+key() { echo "$1" | tr '/:' '__'; }
+not_found() {
+	echo "${FAKE_HELPER_NOT_FOUND:-credentials not found in native keychain}"
+	exit 1
+}
+case "$1" in
+get)
+	read -r server
+	f="$FAKE_HELPER_DIR/$(key "$server")"
+	[ -f "$f" ] || not_found
+	cat "$f"
+	;;
+store)
+	input=$(cat)
+	server=$(echo "$input" | sed 's/.*"ServerURL":"\([^"]*\)".*/\1/')
+	echo "$input" > "$FAKE_HELPER_DIR/$(key "$server")"
+	;;
+erase)
+	read -r server
+	f="$FAKE_HELPER_DIR/$(key "$server")"
+	[ -f "$f" ] || not_found
+	rm "$f"
+	;;
+*)
+	echo "unknown action $1"
+	exit 1
+	;;
+esac
+`
+
+// installFakeHelper puts the fake helper in PATH as docker-credential-fake, and returns the
+// directory it keeps credentials in.
+func installFakeHelper(t *testing.T) string {
+	bin := t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(bin, "docker-credential-fake"), []byte(fakeHelper), 0755))
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	dir := t.TempDir()
+	t.Setenv("FAKE_HELPER_DIR", dir)
+	return dir
+}
+
+func TestHelperStore(t *testing.T) {
+	for _, notFound := range []string{
+		"credentials not found in native keychain",
+		"The specified item could not be found in the keychain.",
+		"Error: credentials not found",
+	} {
+		t.Run(notFound, func(t *testing.T) {
+			t.Setenv("FAKE_HELPER_NOT_FOUND", notFound)
+			testHelperStore(t)
+		})
+	}
+}
+
+func testHelperStore(t *testing.T) {
+	dir := installFakeHelper(t)
+	store := NewHelperStore("fake")
+
+	c, err := store.Get("registry.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	assert.NoError(t, store.Store("https://registry.test", Credentials{Username: "regi", Secret: "s3cret"}))
+	entries, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// Found by any name of the server.
+	for _, server := range []string{"https://registry.test", "registry.test", "https://registry.test/"} {
+		c, err = store.Get(server)
+		assert.NoError(t, err)
+		assert.Equal(t, &Credentials{Username: "regi", Secret: "s3cret"}, c, server)
+	}
+
+	assert.NoError(t, store.Erase("registry.test"))
+	c, err = store.Get("https://registry.test")
+	assert.NoError(t, err)
+	assert.Nil(t, c)
+
+	// Erasing again is fine.
+	assert.NoError(t, store.Erase("registry.test"))
+}
+
+func TestHelperStore_Errors(t *testing.T) {
+	_, err := NewHelperStore("missing").Get("registry.test")
+	assert.EqualError(t, err, "credential helper docker-credential-missing not found in PATH")
+
+	installFakeHelper(t)
+	t.Setenv("FAKE_HELPER_DIR", filepath.Join(t.TempDir(), "missing"))
+	err = NewHelperStore("fake").Store("registry.test", Credentials{Username: "regi", Secret: "s3cret"})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "docker-credential-fake store failed")
+	assert.False(t, isNotFound(err))
+
+	// Other failures are not taken for missing credentials.
+	t.Setenv("FAKE_HELPER_NOT_FOUND", "keychain is locked")
+	_, err = NewHelperStore("fake").Get("registry.test")
+	assert.EqualError(t, err, "docker-credential-fake get failed: keychain is locked")
+}
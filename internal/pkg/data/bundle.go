@@ -0,0 +1,239 @@
+package data
+
+import (
+	"github.com/pkg/errors"
+)
+
+// BundleVersion is the version of the bundle format.
+const BundleVersion = 1
+
+// Import strategies, telling what to do with a context of a bundle whose name is taken.
+const (
+	// ImportMerge updates the existing context with the settings given by the bundle.
+	ImportMerge = "merge"
+
+	// ImportOverwrite replaces the existing context with the one of the bundle.
+	ImportOverwrite = "overwrite"
+
+	// ImportSkip keeps the existing context.
+	ImportSkip = "skip"
+)
+
+// Bundle is a portable list of contexts, e.g. to share with teammates. Passwords are either
+// omitted, or encrypted with a passphrase.
+type Bundle struct {
+	Version  int              `yaml:"version" json:"version"`
+	Contexts []*BundleContext `yaml:"contexts" json:"contexts"`
+}
+
+// BundleContext is a context of a bundle. InsecureSkipTLSVerify is nil when the bundle leaves it
+// out, so that merging keeps the setting of the existing context.
+type BundleContext struct {
+	Name                  string `yaml:"name" json:"name"`
+	Server                string `yaml:"server" json:"server"`
+	InsecureSkipTLSVerify *bool  `yaml:"insecure-skip-tls-verify,omitempty" json:"insecureSkipTLSVerify,omitempty"`
+	User                  string `yaml:"user,omitempty" json:"user,omitempty"`
+	Password              string `yaml:"password,omitempty" json:"password,omitempty"`
+	CAFile                string `yaml:"certificate-authority,omitempty" json:"certificateAuthority,omitempty"`
+	CertFile              string `yaml:"client-certificate,omitempty" json:"clientCertificate,omitempty"`
+	KeyFile               string `yaml:"client-key,omitempty" json:"clientKey,omitempty"`
+	CredentialsStore      string `yaml:"credentials-store,omitempty" json:"credentialsStore,omitempty"`
+}
+
+// newBundleContext returns the bundle context of the registry.
+func newBundleContext(reg *Registry) *BundleContext {
+	insecure := reg.InsecureSkipTLSVerify
+	return &BundleContext{
+		Name:                  reg.Name,
+		Server:                reg.Server,
+		InsecureSkipTLSVerify: &insecure,
+		User:                  reg.User,
+		Password:              reg.Password,
+		CAFile:                reg.CAFile,
+		CertFile:              reg.CertFile,
+		KeyFile:               reg.KeyFile,
+		CredentialsStore:      reg.CredentialsStore,
+	}
+}
+
+// registry returns the registry of the bundle context, TLS is verified unless told otherwise.
+func (c *BundleContext) registry() *Registry {
+	return &Registry{
+		Name:                  c.Name,
+		Server:                c.Server,
+		InsecureSkipTLSVerify: c.InsecureSkipTLSVerify != nil && *c.InsecureSkipTLSVerify,
+		User:                  c.User,
+		Password:              c.Password,
+		CAFile:                c.CAFile,
+		CertFile:              c.CertFile,
+		KeyFile:               c.KeyFile,
+		CredentialsStore:      c.CredentialsStore,
+	}
+}
+
+// ImportResult tells the names of the contexts added, updated and skipped by an import.
+type ImportResult struct {
+	Added   []string
+	Updated []string
+	Skipped []string
+}
+
+// Export returns the bundle of the contexts with the names, or of all the contexts if no name
+// is given. Passwords are encrypted with the passphrase, or omitted if it is empty.
+func (db *DB) Export(names []string, passphrase string) (*Bundle, error) {
+	registries, err := db.ListContexts()
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Registry{}
+	for _, reg := range registries {
+		byName[reg.Name] = reg
+	}
+
+	if len(names) > 0 {
+		registries = nil
+		for _, name := range names {
+			reg, ok := byName[name]
+			if !ok {
+				return nil, errors.Errorf("context %q not found", name)
+			}
+			registries = append(registries, reg)
+		}
+	}
+
+	bundle := &Bundle{Version: BundleVersion, Contexts: []*BundleContext{}}
+	for _, reg := range registries {
+		exported := newBundleContext(reg)
+		exported.Password = ""
+		if len(passphrase) > 0 {
+			if exported.Password, err = db.secrets.encryptWith(reg.Password, passphrase); err != nil {
+				return nil, errors.Wrapf(err, "unable to encrypt password of context %q", reg.Name)
+			}
+		}
+		bundle.Contexts = append(bundle.Contexts, exported)
+	}
+	return bundle, nil
+}
+
+// Import adds the contexts of the bundle, resolving name conflicts with the strategy. Passwords
+// of the bundle are decrypted with the passphrase. Nothing is imported if any context is invalid.
+func (db *DB) Import(bundle *Bundle, strategy, passphrase string) (*ImportResult, error) {
+	if bundle.Version != BundleVersion {
+		return nil, errors.Errorf("unsupported bundle version %d, expected %d", bundle.Version, BundleVersion)
+	}
+
+	switch strategy {
+	case ImportMerge, ImportOverwrite, ImportSkip:
+	default:
+		return nil, errors.Errorf("unknown import strategy %q, expected %s, %s or %s",
+			strategy, ImportMerge, ImportOverwrite, ImportSkip)
+	}
+
+	var result *ImportResult
+	err := db.update(func() error {
+		var err error
+		result, err = db.importBundle(bundle, strategy, passphrase)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// importBundle adds the contexts of the bundle to the storage, see Import.
+func (db *DB) importBundle(bundle *Bundle, strategy, passphrase string) (*ImportResult, error) {
+	registries, err := db.registries()
+	if err != nil {
+		return nil, err
+	}
+
+	existing := map[string]int{}
+	for i, v := range registries {
+		r := v.(map[interface{}]interface{})
+		if name, ok := r[keyRegistryName].(string); ok {
+			existing[name] = i
+		}
+	}
+
+	result := &ImportResult{}
+	seen := map[string]bool{}
+	for i, reg := range bundle.Contexts {
+		if reg == nil || len(reg.Name) == 0 {
+			return nil, errors.Errorf("context #%d of the bundle has no name", i+1)
+		}
+		if len(reg.Server) == 0 {
+			return nil, errors.Errorf("context %q of the bundle has no server", reg.Name)
+		}
+		if seen[reg.Name] {
+			return nil, errors.Errorf("context %q is given twice by the bundle", reg.Name)
+		}
+		seen[reg.Name] = true
+
+		imported := *reg.registry()
+		if imported.Password, err = db.secrets.decryptWith(reg.Password, passphrase); err != nil {
+			return nil, errors.Wrapf(err, "unable to read password of context %q of the bundle", reg.Name)
+		}
+
+		index, found := existing[reg.Name]
+		switch {
+		case !found:
+			result.Added = append(result.Added, reg.Name)
+		case strategy == ImportSkip:
+			result.Skipped = append(result.Skipped, reg.Name)
+			continue
+		case strategy == ImportMerge:
+			current, err := db.packUp(registries[index].(map[interface{}]interface{}), index)
+			if err != nil {
+				return nil, err
+			}
+			imported = *merge(current, &imported, reg.InsecureSkipTLSVerify)
+			result.Updated = append(result.Updated, reg.Name)
+		default:
+			result.Updated = append(result.Updated, reg.Name)
+		}
+
+		if imported.Password, err = db.secrets.encrypt(imported.Password); err != nil {
+			return nil, errors.Wrapf(err, "unable to encrypt password of context %q", reg.Name)
+		}
+
+		if found {
+			registries[index] = &imported
+		} else {
+			registries = append(registries, &imported)
+		}
+	}
+
+	if len(result.Added)+len(result.Updated) == 0 {
+		return result, nil
+	}
+	return result, db.Upsert(keyRegistries, registries)
+}
+
+// merge returns the registry with the settings given by the patch, empty ones are kept. TLS
+// verification is only changed if insecure is given.
+func merge(reg, patch *Registry, insecure *bool) *Registry {
+	merged := *reg
+	for _, f := range []struct {
+		field *string
+		value string
+	}{
+		{&merged.Server, patch.Server},
+		{&merged.User, patch.User},
+		{&merged.Password, patch.Password},
+		{&merged.CAFile, patch.CAFile},
+		{&merged.CertFile, patch.CertFile},
+		{&merged.KeyFile, patch.KeyFile},
+		{&merged.CredentialsStore, patch.CredentialsStore},
+	} {
+		if len(f.value) > 0 {
+			*f.field = f.value
+		}
+	}
+
+	if insecure != nil {
+		merged.InsecureSkipTLSVerify = *insecure
+	}
+	return &merged
+}
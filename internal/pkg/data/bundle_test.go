@@ -0,0 +1,152 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+func TestDB_Export(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(PassphraseEnv, "")
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+	for _, reg := range []*Registry{
+		{Name: "dev", Server: "http://localhost:5000", User: "regi", Password: "s3cret"},
+		{Name: "prod", Server: "https://registry.test", CAFile: "/etc/regi/ca.pem"},
+	} {
+		ok, err := db.Add(reg)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	// Passwords are omitted.
+	bundle, err := db.Export(nil, "")
+	assert.NoError(t, err)
+	verify := false
+	assert.Equal(t, &Bundle{Version: BundleVersion, Contexts: []*BundleContext{
+		{Name: "dev", Server: "http://localhost:5000", InsecureSkipTLSVerify: &verify, User: "regi"},
+		{Name: "prod", Server: "https://registry.test", InsecureSkipTLSVerify: &verify, CAFile: "/etc/regi/ca.pem"},
+	}}, bundle)
+
+	// Or encrypted with the passphrase.
+	bundle, err = db.Export([]string{"dev"}, "bundle passphrase")
+	assert.NoError(t, err)
+	assert.Len(t, bundle.Contexts, 1)
+	assert.True(t, strings.HasPrefix(bundle.Contexts[0].Password, prefixPassphrase))
+
+	plain, err := db.secrets.decryptWith(bundle.Contexts[0].Password, "bundle passphrase")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", plain)
+
+	_, err = db.Export([]string{"unknown"}, "")
+	assert.EqualError(t, err, `context "unknown" not found`)
+}
+
+func TestDB_Import(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(PassphraseEnv, "")
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&Registry{Name: "dev", Server: "http://localhost:5000", User: "regi", Password: "local"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	password, err := db.secrets.encryptWith("shared", "bundle passphrase")
+	assert.NoError(t, err)
+	bundle := &Bundle{Version: BundleVersion, Contexts: []*BundleContext{
+		{Name: "dev", Server: "http://localhost:5001"},
+		{Name: "prod", Server: "https://registry.test", User: "ci", Password: password},
+	}}
+
+	// Skip keeps the existing context.
+	result, err := db.Import(bundle, ImportSkip, "bundle passphrase")
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Added: []string{"prod"}, Skipped: []string{"dev"}}, result)
+
+	prod, err := db.GetContext("prod")
+	assert.NoError(t, err)
+	assert.Equal(t, "shared", prod.Password)
+
+	// Merge keeps the settings the bundle has not.
+	result, err = db.Import(bundle, ImportMerge, "bundle passphrase")
+	assert.NoError(t, err)
+	assert.Equal(t, &ImportResult{Updated: []string{"dev", "prod"}}, result)
+
+	dev, err := db.GetContext("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &Registry{Name: "dev", Server: "http://localhost:5001", User: "regi", Password: "local"}, dev)
+
+	// Overwrite replaces it.
+	_, err = db.Import(bundle, ImportOverwrite, "bundle passphrase")
+	assert.NoError(t, err)
+	dev, err = db.GetContext("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &Registry{Name: "dev", Server: "http://localhost:5001"}, dev)
+
+	// Errors, nothing is imported.
+	_, err = db.Import(bundle, ImportSkip, "")
+	assert.EqualError(t, err, `unable to read password of context "prod" of the bundle: password is encrypted with a passphrase, please set REGI_PASSPHRASE`)
+	_, err = db.Import(bundle, "replace", "")
+	assert.EqualError(t, err, `unknown import strategy "replace", expected merge, overwrite or skip`)
+	_, err = db.Import(&Bundle{Version: 2}, ImportSkip, "")
+	assert.EqualError(t, err, "unsupported bundle version 2, expected 1")
+	_, err = db.Import(&Bundle{Version: BundleVersion, Contexts: []*BundleContext{{Name: "new", Server: "http://new"}, {Name: "new"}}}, ImportSkip, "")
+	assert.EqualError(t, err, `context "new" of the bundle has no server`)
+	_, err = db.Import(&Bundle{Version: BundleVersion, Contexts: []*BundleContext{{Server: "http://new"}}}, ImportSkip, "")
+	assert.EqualError(t, err, "context #1 of the bundle has no name")
+
+	added, err := db.GetContext("new")
+	assert.NoError(t, err)
+	assert.Nil(t, added)
+}
+
+func TestDB_ImportEmpty(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	result, err := db.Import(&Bundle{Version: BundleVersion, Contexts: []*BundleContext{{Name: "dev", Server: "http://localhost:5000"}}}, ImportMerge, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"dev"}, result.Added)
+
+	dev, err := db.GetContext("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:5000", dev.Server)
+}
+
+func TestDB_ImportMergeInsecure(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&Registry{Name: "dev", Server: "http://localhost:5000", InsecureSkipTLSVerify: true})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Left out by the bundle, the setting is kept.
+	_, err = db.Import(&Bundle{Version: BundleVersion, Contexts: []*BundleContext{
+		{Name: "dev", Server: "http://localhost:5001"},
+	}}, ImportMerge, "")
+	assert.NoError(t, err)
+
+	dev, err := db.GetContext("dev")
+	assert.NoError(t, err)
+	assert.Equal(t, &Registry{Name: "dev", Server: "http://localhost:5001", InsecureSkipTLSVerify: true}, dev)
+
+	// Given, it is changed.
+	verify := false
+	_, err = db.Import(&Bundle{Version: BundleVersion, Contexts: []*BundleContext{
+		{Name: "dev", Server: "http://localhost:5001", InsecureSkipTLSVerify: &verify},
+	}}, ImportMerge, "")
+	assert.NoError(t, err)
+
+	dev, err = db.GetContext("dev")
+	assert.NoError(t, err)
+	assert.False(t, dev.InsecureSkipTLSVerify)
+}
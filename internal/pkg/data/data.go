@@ -1,12 +1,18 @@
 package data
 
 import (
+	"bytes"
 	"fmt"
 	"github.com/pkg/errors"
 	"github.com/ulfox/dby/db"
+	"gopkg.in/yaml.v2"
 	"os"
+	"path/filepath"
 )
 
+// recoveryHint tells how to recover from a corrupted or invalid storage file.
+const recoveryHint = "please fix it, or move it away and add the contexts again"
+
 const (
 	keyCurrent          = "current"
 	keyRegistries       = "registries"
@@ -15,30 +21,252 @@ const (
 	keyRegistrySkip     = "insecure-skip-tls-verify"
 	keyRegistryUser     = "user"
 	keyRegistryPassword = "password"
+	keyRegistryCA       = "certificate-authority"
+	keyRegistryCert     = "client-certificate"
+	keyRegistryKey      = "client-key"
+	keyRegistryCreds    = "credentials-store"
 )
 
-// DB defines a YAML file base data storage.
+// DB defines a YAML file base data storage. Passwords are stored encrypted.
+//
+// The file is read once opened, and is never written by the embedded storage itself: changes go
+// through update, which locks the file, applies them to its latest content and replaces it
+// atomically, so that regi processes running in parallel do not lose each other's changes.
 type DB struct {
 	*db.Storage
+
+	secrets *secrets
+
+	// location is the path of the storage file, empty if the storage is kept in memory.
+	location string
+
+	// context is the context to use instead of the current one, if any.
+	context string
+}
+
+// Options overrides the storage file and the context to use, which are otherwise given by the
+// environment.
+type Options struct {
+	// Location is the path of the storage file, by default given by ConfigEnv or ~/.regi/regi.yaml.
+	Location string
+
+	// Context is the context to use instead of the current one, by default given by ContextEnv.
+	Context string
 }
 
 // NewDB returns a new YAML data storage.
 func NewDB() (*DB, error) {
-	db, err := db.NewStorageFactory(defaultLocation())
+	return NewDBWithOptions(Options{})
+}
+
+// NewDBWithOptions returns a new YAML data storage with the options. If the storage file does
+// not exist while ServerEnv is set, the storage is kept in memory and no file is created.
+func NewDBWithOptions(opts Options) (*DB, error) {
+	location := opts.Location
+	if len(location) == 0 {
+		location = defaultLocation()
+	}
+
+	context := opts.Context
+	if len(context) == 0 {
+		context = os.Getenv(ContextEnv)
+	}
+
+	if _, err := os.Stat(location); os.IsNotExist(err) && len(os.Getenv(ServerEnv)) > 0 {
+		storage, err := db.NewStorageFactory(true)
+		if err != nil {
+			return nil, err
+		}
+		return &DB{Storage: storage, secrets: newSecrets(filepath.Dir(location)), context: context}, nil
+	}
+
+	storage, old, err := loadStorage(location)
 	if err != nil {
 		return nil, err
 	}
 
-	return &DB{db}, nil
+	// The file holds credentials, only the user may read it.
+	if err := os.Chmod(location, 0600); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	d := &DB{
+		Storage:  storage,
+		secrets:  newSecrets(filepath.Dir(location)),
+		location: location,
+		context:  context,
+	}
+
+	// Files of older schema versions are migrated on first load.
+	if old != nil {
+		if err := d.update(func() error { return nil }); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// loadStorage reads the storage file, and migrates its content to SchemaVersion. The backup to
+// keep is returned if it is migrated. An empty storage is returned if the file does not exist
+// yet, it is created by the first change.
+func loadStorage(location string) (*db.Storage, *backup, error) {
+	doc, old, err := readDocument(location)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	storage, err := db.NewStorageFactory(true)
+	if err != nil {
+		return nil, nil, err
+	}
+	storage.Path = location
+
+	if err := storage.Upsert(keyVersion, doc.Version); err != nil {
+		return nil, nil, err
+	}
+
+	if len(doc.Current) > 0 {
+		if err := storage.Upsert(keyCurrent, doc.Current); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if len(doc.Registries) > 0 {
+		if err := storage.Upsert(keyRegistries, doc.Registries); err != nil {
+			return nil, nil, err
+		}
+	}
+	return storage, old, nil
+}
+
+// update applies the change to the latest content of the storage file, and writes the result.
+// The file is locked meanwhile, a change must not call update again.
+func (db *DB) update(change func() error) error {
+	// Nothing to keep for a storage in memory.
+	if len(db.location) == 0 {
+		return change()
+	}
+
+	lock, err := lockFile(db.location)
+	if err != nil {
+		return err
+	}
+	defer lock.unlock()
+
+	storage, old, err := loadStorage(db.location)
+	if err != nil {
+		return err
+	}
+	db.Storage = storage
+
+	if err := change(); err != nil {
+		return err
+	}
+
+	if old != nil {
+		if err := os.WriteFile(old.path(db.location), old.content, 0600); err != nil {
+			return errors.Wrapf(err, "unable to back up storage %s before migrating it", db.location)
+		}
+	}
+	return db.write()
+}
+
+// write replaces the storage file atomically: the content is written to a temporary file,
+// which is renamed once synced, so that readers never see a partly written file.
+func (db *DB) write() error {
+	var buf bytes.Buffer
+	if err := yaml.NewEncoder(&buf).Encode(db.GetData()); err != nil {
+		return errors.Wrapf(err, "unable to encode storage %s", db.location)
+	}
+
+	dir := filepath.Dir(db.location)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	// CreateTemp creates the file only the user may read.
+	f, err := os.CreateTemp(dir, "."+filepath.Base(db.location)+".*")
+	if err != nil {
+		return errors.Wrapf(err, "unable to write storage %s", db.location)
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(buf.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, db.location)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return errors.Wrapf(err, "unable to write storage %s", db.location)
+	}
+	return nil
+}
+
+// registries returns the registries as stored, nil if none is stored yet. Each of them is a map.
+func (db *DB) registries() ([]interface{}, error) {
+	// The path is missing until a context is added.
+	keyPath, err := db.GetPath(keyRegistries)
+	if err != nil || keyPath == nil {
+		return nil, nil
+	}
+
+	registries, ok := keyPath.([]interface{})
+	if !ok {
+		return nil, db.corrupted("%s is not a list", keyRegistries)
+	}
+
+	for i, v := range registries {
+		if _, ok := v.(map[interface{}]interface{}); !ok {
+			return nil, db.corrupted("context #%d is not a map", i+1)
+		}
+	}
+	return registries, nil
+}
+
+// corrupted returns the error telling the storage file is corrupted, and how to recover.
+func (db *DB) corrupted(format string, args ...interface{}) error {
+	return corrupted(db.location, format, args...)
+}
+
+// corrupted returns the error telling the storage file at the location is corrupted, and how
+// to recover.
+func corrupted(location, format string, args ...interface{}) error {
+	return errors.Errorf("storage %s is corrupted, %s; %s", location, fmt.Sprintf(format, args...), recoveryHint)
+}
+
+// invalid returns the error telling the content of the storage file at the location is invalid,
+// and how to recover.
+func invalid(location string, err error) error {
+	return errors.Errorf("storage %s is invalid, %s; %s", location, err, recoveryHint)
 }
 
 // Registry defines a registry entry.
 type Registry struct {
-	Name                  string
-	Server                string
-	InsecureSkipTLSVerify bool
-	User                  string
-	Password              string
+	Name                  string `yaml:"name" json:"name"`
+	Server                string `yaml:"server" json:"server"`
+	InsecureSkipTLSVerify bool   `yaml:"insecure-skip-tls-verify" json:"insecureSkipTLSVerify"`
+	User                  string `yaml:"user,omitempty" json:"user,omitempty"`
+	Password              string `yaml:"password,omitempty" json:"password,omitempty"`
+
+	// CAFile is the path to a PEM bundle of CA certificates trusted for the server.
+	CAFile string `yaml:"certificate-authority,omitempty" json:"certificateAuthority,omitempty"`
+
+	// CertFile and KeyFile are the paths to a PEM client certificate and its key for mutual TLS.
+	CertFile string `yaml:"client-certificate,omitempty" json:"clientCertificate,omitempty"`
+	KeyFile  string `yaml:"client-key,omitempty" json:"clientKey,omitempty"`
+
+	// CredentialsStore names where the credentials are kept instead of Password, either the
+	// Docker config file or a Docker credential helper.
+	CredentialsStore string `yaml:"credentials-store,omitempty" json:"credentialsStore,omitempty"`
+
+	// Ephemeral tells the context is given by ServerEnv, it is not stored.
+	Ephemeral bool `yaml:"-" json:"-"`
 }
 
 // CurrentContext returns the current registry setting.
@@ -49,11 +277,13 @@ func (db *DB) CurrentContext() (*Registry, error) {
 		return nil, err
 	}
 
-	var current string
 	if keyPath == nil {
 		return nil, nil
 	}
-	current = keyPath.(string)
+	current, ok := keyPath.(string)
+	if !ok {
+		return nil, db.corrupted("%s is not a context name", keyCurrent)
+	}
 	if len(current) == 0 {
 		return nil, nil
 	}
@@ -73,25 +303,43 @@ func (db *DB) CurrentContext() (*Registry, error) {
 	return nil, errors.New("unable to find context, there must be something wrong about adding context")
 }
 
+// ActiveContext returns the context commands use: the one given by the options or ContextEnv,
+// else the ephemeral one given by ServerEnv, else the current one. Nil is returned if none is.
+func (db *DB) ActiveContext() (*Registry, error) {
+	if len(db.context) > 0 {
+		regs, err := db.ListContexts()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, reg := range regs {
+			if reg.Name == db.context {
+				return reg, nil
+			}
+		}
+		return nil, errors.Errorf("context %q not found, it is given by --context or %s", db.context, ContextEnv)
+	}
+
+	if reg := envContext(); reg != nil {
+		return reg, nil
+	}
+
+	return db.CurrentContext()
+}
+
 // ListContexts returns all the registries.
 func (db *DB) ListContexts() ([]*Registry, error) {
 	var registries []*Registry
 
 	// GetContext all the contexts.
-	keyPath, err := db.GetPath(keyRegistries)
+	list, err := db.registries()
 	if err != nil {
 		return nil, err
 	}
 
-	if keyPath == nil {
-		return nil, errors.New(
-			"registries is not specified, there must be something wrong about setting current context")
-	}
-	list := keyPath.([]interface{})
-
-	for _, v := range list {
+	for i, v := range list {
 		r := v.(map[interface{}]interface{})
-		reg, err := packUp(r)
+		reg, err := db.packUp(r, i)
 		if err != nil {
 			return nil, err
 		}
@@ -104,21 +352,15 @@ func (db *DB) ListContexts() ([]*Registry, error) {
 // GetContext a registry entry.
 func (db *DB) GetContext(name string) (*Registry, error) {
 	// GetContext all the contexts.
-	keyPath, err := db.GetPath(keyRegistries)
+	registries, err := db.registries()
 	if err != nil {
 		return nil, err
 	}
 
-	if keyPath == nil {
-		return nil, errors.New(
-			"registries is not specified, there must be something wrong about setting current context")
-	}
-	registries := keyPath.([]interface{})
-
-	for _, v := range registries {
+	for i, v := range registries {
 		r := v.(map[interface{}]interface{})
-		if r["name"] != nil && r["name"].(string) == name {
-			return packUp(r)
+		if r[keyRegistryName] == name {
+			return db.packUp(r, i)
 		}
 	}
 
@@ -127,105 +369,216 @@ func (db *DB) GetContext(name string) (*Registry, error) {
 
 // SetCurrentContext current context.
 func (db *DB) SetCurrentContext(name string) error {
-	return db.Upsert("current", name)
+	return db.update(func() error {
+		return db.Upsert(keyCurrent, name)
+	})
 }
 
 // DeleteContext context.
 func (db *DB) DeleteContext(name string) error {
-	// GetContext all the contexts.
-	regs, err := db.ListContexts()
-	if err != nil {
-		return errors.Errorf("unable to delete context[%s], %s", name, err.Error())
-	}
+	return db.update(func() error {
+		// GetContext all the contexts, as stored.
+		registries, err := db.registries()
+		if err != nil {
+			return errors.Wrapf(err, "unable to delete context[%s]", name)
+		}
 
-	var newRegs []*Registry
-	for _, v := range regs {
-		if v.Name != name {
-			newRegs = append(newRegs, v)
+		newRegs := []interface{}{}
+		for _, v := range registries {
+			r := v.(map[interface{}]interface{})
+			if r[keyRegistryName] != name {
+				newRegs = append(newRegs, r)
+			}
 		}
-	}
 
-	err = db.Upsert(keyRegistries, newRegs)
-	if err != nil {
-		return errors.Errorf("unable to delete context[%s], %s", name, err.Error())
-	}
+		err = db.Upsert(keyRegistries, newRegs)
+		if err != nil {
+			return errors.Errorf("unable to delete context[%s], %s", name, err.Error())
+		}
 
-	return nil
+		return nil
+	})
 }
 
 // Add new registry to the context list.
-func (db *DB) Add(name, server, user, password string, skip bool) (bool, error) {
-	keyPath, err := db.GetPath(keyRegistries)
+func (db *DB) Add(reg *Registry) (bool, error) {
+	added := false
+	err := db.update(func() error {
+		registries, err := db.registries()
+		if err != nil {
+			return err
+		}
 
-	var registries []interface{}
-	if keyPath != nil {
-		registries = keyPath.([]interface{})
-	}
+		for _, v := range registries {
+			r := v.(map[interface{}]interface{})
+			if r[keyRegistryName] == reg.Name {
+				return nil
+			}
+		}
 
-	found := false
-	for _, v := range registries {
-		r := v.(map[interface{}]interface{})
-		if r["name"] == name {
-			found = true
-			break
+		// The password is stored encrypted, the given registry is kept as it is.
+		stored := *reg
+		stored.Password, err = db.secrets.encrypt(reg.Password)
+		if err != nil {
+			return errors.Wrapf(err, "unable to encrypt password of context %q", reg.Name)
+		}
+		registries = append(registries, &stored)
+
+		if err := db.Upsert(keyRegistries, registries); err != nil {
+			return err
 		}
+
+		added = true
+		return nil
+	})
+	if err != nil {
+		return false, err
 	}
 
-	if !found {
-		registries = append(registries, map[string]interface{}{
-			keyRegistryName:     name,
-			keyRegistryServer:   server,
-			keyRegistrySkip:     skip,
-			keyRegistryUser:     user,
-			keyRegistryPassword: password,
-		})
+	return added, nil
+}
 
-		err = db.Upsert(keyRegistries, registries)
+// UpdateContext replaces the context with the same name as the given registry.
+func (db *DB) UpdateContext(reg *Registry) error {
+	return db.update(func() error {
+		registries, err := db.registries()
 		if err != nil {
-			return false, err
+			return err
 		}
 
-		return true, nil
-	}
+		for i, v := range registries {
+			r := v.(map[interface{}]interface{})
+			if r[keyRegistryName] != reg.Name {
+				continue
+			}
+
+			stored := *reg
+			if stored.Password, err = db.secrets.encrypt(reg.Password); err != nil {
+				return errors.Wrapf(err, "unable to encrypt password of context %q", reg.Name)
+			}
+			registries[i] = &stored
+			return db.Upsert(keyRegistries, registries)
+		}
 
-	return false, nil
+		return errors.Errorf("context %q not found", reg.Name)
+	})
 }
 
-// packUp converts a map format registry to struct Registry.
-func packUp(reg map[interface{}]interface{}) (*Registry, error) {
-	r := Registry{}
-	name := reg["name"]
-	server := reg["server"]
-	skip := reg["insecure-skip-tls-verify"]
-	user := reg["user"]
-	pass := reg["password"]
+// RenameContext renames a context, the current context follows it.
+func (db *DB) RenameContext(name, newName string) error {
+	return db.update(func() error {
+		registries, err := db.registries()
+		if err != nil {
+			return err
+		}
 
-	if name == nil {
-		return nil, errors.New("name is not specified")
-	}
-	r.Name = name.(string)
+		var found map[interface{}]interface{}
+		for _, v := range registries {
+			r := v.(map[interface{}]interface{})
+			switch r[keyRegistryName] {
+			case newName:
+				return errors.Errorf("context %q already exists", newName)
+			case name:
+				found = r
+			}
+		}
 
-	if server == nil {
-		return nil, errors.New("server is not specified")
-	}
-	r.Server = server.(string)
+		if found == nil {
+			return errors.Errorf("context %q not found", name)
+		}
 
-	if skip != nil {
-		r.InsecureSkipTLSVerify = skip.(bool)
-	}
+		found[keyRegistryName] = newName
+		if err := db.Upsert(keyRegistries, registries); err != nil {
+			return err
+		}
+
+		current, err := db.GetPath(keyCurrent)
+		if err == nil && current == name {
+			return db.Upsert(keyCurrent, newName)
+		}
+		return nil
+	})
+}
+
+// SetCredentials sets the user and password of a context, an empty password removes it.
+func (db *DB) SetCredentials(name, user, password string) error {
+	return db.update(func() error {
+		registries, err := db.registries()
+		if err != nil {
+			return err
+		}
+
+		for _, v := range registries {
+			r := v.(map[interface{}]interface{})
+			if r[keyRegistryName] != name {
+				continue
+			}
+
+			r[keyRegistryUser] = user
+			if r[keyRegistryPassword], err = db.secrets.encrypt(password); err != nil {
+				return errors.Wrapf(err, "unable to encrypt password of context %q", name)
+			}
+			return db.Upsert(keyRegistries, registries)
+		}
+
+		return errors.Errorf("context %q not found", name)
+	})
+}
+
+// MigrateSecrets encrypts the passwords stored in plain text, and returns the number of them.
+func (db *DB) MigrateSecrets() (int, error) {
+	migrated := 0
+	err := db.update(func() error {
+		registries, err := db.registries()
+		if err != nil {
+			return err
+		}
 
-	if user != nil {
-		r.User = user.(string)
+		for _, v := range registries {
+			r := v.(map[interface{}]interface{})
+			pass, ok := r[keyRegistryPassword].(string)
+			if !ok || len(pass) == 0 || IsEncrypted(pass) {
+				continue
+			}
+
+			if r[keyRegistryPassword], err = db.secrets.encrypt(pass); err != nil {
+				return errors.Wrapf(err, "unable to encrypt password of context %q", r[keyRegistryName])
+			}
+			migrated++
+		}
+
+		if migrated == 0 {
+			return nil
+		}
+		return db.Upsert(keyRegistries, registries)
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	if pass != nil {
-		r.Password = pass.(string)
+	return migrated, nil
+}
+
+// packUp converts a map format registry at the index of the registries to struct Registry,
+// decrypting the password.
+func (db *DB) packUp(reg map[interface{}]interface{}, index int) (*Registry, error) {
+	r, err := decodeStored(reg, index)
+	if err != nil {
+		return nil, invalid(db.location, err)
 	}
 
-	return &r, nil
+	if r.Password, err = db.secrets.decrypt(r.Password); err != nil {
+		return nil, errors.Wrapf(err, "unable to read context %q", r.Name)
+	}
+	return r, nil
 }
 
+// defaultLocation returns the path of the storage file, given by ConfigEnv or ~/.regi/regi.yaml.
 func defaultLocation() string {
+	if location := os.Getenv(ConfigEnv); len(location) > 0 {
+		return location
+	}
+
 	home, err := os.UserHomeDir()
 	if err != nil {
 		home = "."
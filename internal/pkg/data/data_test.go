@@ -2,6 +2,9 @@ package data
 
 import (
 	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strconv"
 	"testing"
 )
 
@@ -10,3 +13,274 @@ func TestDB_Current(t *testing.T) {
 	assert.NoError(t, err)
 	assert.NotEmpty(t, db)
 }
+
+func TestDB_Add(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	reg := &Registry{
+		Name:                  "secure",
+		Server:                "https://registry.test",
+		InsecureSkipTLSVerify: true,
+		User:                  "regi",
+		Password:              "regi",
+		CAFile:                "/etc/regi/ca.pem",
+		CertFile:              "/etc/regi/client.pem",
+		KeyFile:               "/etc/regi/client-key.pem",
+	}
+	ok, err := db.Add(reg)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Duplicates are refused.
+	ok, err = db.Add(reg)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// TLS settings survive a reload from disk.
+	db, err = NewDB()
+	assert.NoError(t, err)
+
+	got, err := db.GetContext("secure")
+	assert.NoError(t, err)
+	assert.Equal(t, reg, got)
+}
+
+func TestDB_Secrets(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(PassphraseEnv, "")
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&Registry{Name: "secure", Server: "https://registry.test", User: "regi", Password: "s3cret"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The password is encrypted on disk, and the file is for the user only.
+	location := filepath.Join(home, ".regi", "regi.yaml")
+	content, err := os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cret")
+	assert.Contains(t, string(content), prefixKey)
+
+	info, err := os.Stat(location)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	got, err := db.GetContext("secure")
+	assert.NoError(t, err)
+	assert.Equal(t, "s3cret", got.Password)
+
+	// Passwords written in plain text by an older version are migrated.
+	assert.NoError(t, os.WriteFile(location, []byte(`current: legacy
+registries:
+- name: legacy
+  server: http://localhost:5000
+  user: regi
+  password: plain
+- name: anonymous
+  server: http://localhost:5001
+`), 0644))
+
+	db, err = NewDB()
+	assert.NoError(t, err)
+	info, err = os.Stat(location)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	n, err := db.MigrateSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+
+	content, err = os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "plain")
+
+	current, err := db.CurrentContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "plain", current.Password)
+
+	// Nothing left to migrate.
+	n, err = db.MigrateSecrets()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+
+	// Deleting a context keeps the others encrypted.
+	assert.NoError(t, db.DeleteContext("anonymous"))
+	content, err = os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "plain")
+}
+
+func TestDB_SetCredentials(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(PassphraseEnv, "")
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&Registry{Name: "login", Server: "https://registry.test"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	assert.NoError(t, db.SetCredentials("login", "regi", "s3cret"))
+	content, err := os.ReadFile(filepath.Join(home, ".regi", "regi.yaml"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cret")
+
+	got, err := db.GetContext("login")
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", got.User)
+	assert.Equal(t, "s3cret", got.Password)
+
+	// The password is removed, the user kept.
+	assert.NoError(t, db.SetCredentials("login", "regi", ""))
+	got, err = db.GetContext("login")
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", got.User)
+	assert.Empty(t, got.Password)
+
+	assert.EqualError(t, db.SetCredentials("unknown", "regi", "s3cret"), `context "unknown" not found`)
+}
+
+func TestDB_UpdateContext(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv(PassphraseEnv, "")
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	ok, err := db.Add(&Registry{Name: "typo", Server: "https://registyr.test", User: "regi", Password: "s3cret"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, db.SetCurrentContext("typo"))
+
+	reg, err := db.GetContext("typo")
+	assert.NoError(t, err)
+	reg.Server = "https://registry.test"
+	assert.NoError(t, db.UpdateContext(reg))
+
+	content, err := os.ReadFile(filepath.Join(home, ".regi", "regi.yaml"))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cret")
+
+	got, err := db.GetContext("typo")
+	assert.NoError(t, err)
+	assert.Equal(t, &Registry{Name: "typo", Server: "https://registry.test", User: "regi", Password: "s3cret"}, got)
+
+	assert.EqualError(t, db.UpdateContext(&Registry{Name: "unknown"}), `context "unknown" not found`)
+
+	// Renaming keeps the current context.
+	assert.NoError(t, db.RenameContext("typo", "fixed"))
+	current, err := db.CurrentContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "fixed", current.Name)
+	assert.Equal(t, "s3cret", current.Password)
+
+	got, err = db.GetContext("typo")
+	assert.NoError(t, err)
+	assert.Nil(t, got)
+
+	ok, err = db.Add(&Registry{Name: "other", Server: "https://other.test"})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.EqualError(t, db.RenameContext("other", "fixed"), `context "fixed" already exists`)
+	assert.EqualError(t, db.RenameContext("unknown", "new"), `context "unknown" not found`)
+
+	// Renaming another context leaves the current one.
+	assert.NoError(t, db.RenameContext("other", "another"))
+	current, err = db.CurrentContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "fixed", current.Name)
+}
+
+func TestDB_ListContextsEmpty(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+
+	registries, err := db.ListContexts()
+	assert.NoError(t, err)
+	assert.Empty(t, registries)
+}
+
+func TestDB_ConcurrentChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	// Both are opened before any change, as parallel processes would be.
+	first, err := NewDB()
+	assert.NoError(t, err)
+	second, err := NewDB()
+	assert.NoError(t, err)
+
+	_, err = first.Add(&Registry{Name: "first", Server: "https://first.test"})
+	assert.NoError(t, err)
+	_, err = second.Add(&Registry{Name: "second", Server: "https://second.test"})
+	assert.NoError(t, err)
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+	registries, err := db.ListContexts()
+	assert.NoError(t, err)
+	assert.Len(t, registries, 2)
+
+	// Many changes at once, none is lost.
+	done := make(chan error)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			db, err := NewDB()
+			if err == nil {
+				_, err = db.Add(&Registry{Name: "parallel-" + strconv.Itoa(i), Server: "https://parallel.test"})
+			}
+			done <- err
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, <-done)
+	}
+
+	db, err = NewDB()
+	assert.NoError(t, err)
+	registries, err = db.ListContexts()
+	assert.NoError(t, err)
+	assert.Len(t, registries, 12)
+}
+
+func TestDB_Corrupted(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"yaml", "registries: [\n", "it is not valid YAML"},
+		{"document", "- regi\n", "it does not hold a map"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "regi.yaml")
+			assert.NoError(t, os.WriteFile(location, []byte(tc.content), 0600))
+
+			db, err := NewDBWithOptions(Options{Location: location})
+			if err == nil {
+				_, err = db.ListContexts()
+				if err == nil {
+					_, err = db.CurrentContext()
+				}
+			}
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "storage "+location+" is corrupted, "+tc.err)
+			assert.Contains(t, err.Error(), "please fix it, or move it away and add the contexts again")
+
+			// The file is left as it is.
+			content, err := os.ReadFile(location)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.content, string(content))
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package data
+
+import (
+	"os"
+)
+
+const (
+	// ConfigEnv holds the path of the storage file, instead of ~/.regi/regi.yaml.
+	ConfigEnv = "REGI_CONFIG"
+
+	// ContextEnv holds the name of the context to use instead of the current one.
+	ContextEnv = "REGI_CONTEXT"
+
+	// ServerEnv, UserEnv and PasswordEnv give an ephemeral context, e.g. in CI where no storage
+	// file exists.
+	ServerEnv   = "REGI_SERVER"
+	UserEnv     = "REGI_USER"
+	PasswordEnv = "REGI_PASSWORD"
+
+	// EnvContextName is the name of the ephemeral context given by ServerEnv.
+	EnvContextName = "env"
+)
+
+// envContext returns the ephemeral context given by ServerEnv, UserEnv and PasswordEnv, nil if
+// ServerEnv is not set.
+func envContext() *Registry {
+	server := os.Getenv(ServerEnv)
+	if len(server) == 0 {
+		return nil
+	}
+
+	return &Registry{
+		Name:      EnvContextName,
+		Server:    server,
+		User:      os.Getenv(UserEnv),
+		Password:  os.Getenv(PasswordEnv),
+		Ephemeral: true,
+	}
+}
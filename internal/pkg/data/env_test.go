@@ -0,0 +1,104 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_ConfigEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ServerEnv, "")
+
+	location := filepath.Join(t.TempDir(), "ci.yaml")
+	t.Setenv(ConfigEnv, location)
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+	_, err = db.Add(&Registry{Name: "ci", Server: "https://registry.test"})
+	assert.NoError(t, err)
+
+	_, err = os.Stat(location)
+	assert.NoError(t, err)
+
+	// The location given by the options wins over the environment.
+	other := filepath.Join(t.TempDir(), "other.yaml")
+	db, err = NewDBWithOptions(Options{Location: other})
+	assert.NoError(t, err)
+
+	registries, err := db.ListContexts()
+	assert.NoError(t, err)
+	assert.Empty(t, registries)
+}
+
+func TestDB_ActiveContext(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ContextEnv, "")
+	t.Setenv(ServerEnv, "")
+
+	db, err := NewDB()
+	assert.NoError(t, err)
+	for _, name := range []string{"dev", "prod"} {
+		_, err := db.Add(&Registry{Name: name, Server: "https://" + name + ".test"})
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, db.SetCurrentContext("dev"))
+
+	active, err := db.ActiveContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", active.Name)
+
+	// The environment gives another context, the current one is kept.
+	t.Setenv(ContextEnv, "prod")
+	db, err = NewDB()
+	assert.NoError(t, err)
+
+	active, err = db.ActiveContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "prod", active.Name)
+
+	current, err := db.CurrentContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", current.Name)
+
+	// The options win over the environment.
+	db, err = NewDBWithOptions(Options{Context: "dev"})
+	assert.NoError(t, err)
+
+	active, err = db.ActiveContext()
+	assert.NoError(t, err)
+	assert.Equal(t, "dev", active.Name)
+
+	db, err = NewDBWithOptions(Options{Context: "missing"})
+	assert.NoError(t, err)
+
+	_, err = db.ActiveContext()
+	assert.EqualError(t, err, `context "missing" not found, it is given by --context or REGI_CONTEXT`)
+}
+
+func TestDB_ServerEnv(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv(ContextEnv, "")
+	t.Setenv(ServerEnv, "https://registry.test")
+	t.Setenv(UserEnv, "ci")
+	t.Setenv(PasswordEnv, "s3cret")
+
+	location := filepath.Join(t.TempDir(), "regi.yaml")
+	db, err := NewDBWithOptions(Options{Location: location})
+	assert.NoError(t, err)
+
+	active, err := db.ActiveContext()
+	assert.NoError(t, err)
+	assert.Equal(t, &Registry{
+		Name:      EnvContextName,
+		Server:    "https://registry.test",
+		User:      "ci",
+		Password:  "s3cret",
+		Ephemeral: true,
+	}, active)
+
+	// No storage file is created.
+	_, err = os.Stat(location)
+	assert.True(t, os.IsNotExist(err))
+}
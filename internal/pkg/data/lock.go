@@ -0,0 +1,78 @@
+package data
+
+import (
+	"github.com/pkg/errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// lockSuffix names the lock file of the storage file, next to it.
+	lockSuffix = ".lock"
+
+	// lockTimeout is how long to wait for another process to release the lock.
+	lockTimeout = 10 * time.Second
+
+	// lockRetry is how often the lock is tried again meanwhile.
+	lockRetry = 50 * time.Millisecond
+)
+
+// errLocked tells the lock file is locked by another process.
+var errLocked = errors.New("locked by another process")
+
+// fileLock is an exclusive lock on a lock file next to a file. The lock is held by the open
+// lock file rather than by its existence, so the system releases it when the process exits,
+// crashed or not, and a lock is never taken over from a live process.
+type fileLock struct {
+	file *os.File
+}
+
+// lockFile locks the file, waiting up to lockTimeout for another process to release it. The
+// lock file is kept once released, as removing it would let two processes lock different files.
+func lockFile(path string) (*fileLock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+
+	p := path + lockSuffix
+	f, err := os.OpenFile(p, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to lock %s", path)
+	}
+
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		err := tryLockFile(f)
+		if err == nil {
+			break
+		}
+
+		if err != errLocked {
+			f.Close()
+			return nil, errors.Wrapf(err, "unable to lock %s", path)
+		}
+
+		if time.Now().After(deadline) {
+			f.Close()
+			return nil, errors.Errorf("%s is locked by another regi process, please try again once it is done", path)
+		}
+		time.Sleep(lockRetry)
+	}
+
+	// The pid tells who holds the lock, for whoever looks into it.
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	}
+	return &fileLock{file: f}, nil
+}
+
+// unlock releases the lock.
+func (l *fileLock) unlock() error {
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
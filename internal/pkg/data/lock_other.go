@@ -0,0 +1,18 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd || windows)
+
+package data
+
+import (
+	"os"
+)
+
+// tryLockFile does not lock the file, as the platform has no file locks regi knows of.
+// Changes of processes running in parallel are not serialized there.
+func tryLockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing, as tryLockFile.
+func unlockFile(f *os.File) error {
+	return nil
+}
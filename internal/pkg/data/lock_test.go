@@ -0,0 +1,103 @@
+package data
+
+import (
+	"bufio"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// lockHolderEnv gives the file for TestLockFileHolder to lock, when run by TestLockFileCrashed.
+const lockHolderEnv = "REGI_TEST_LOCK_HOLDER"
+
+func TestLockFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regi.yaml")
+
+	lock, err := lockFile(path)
+	assert.NoError(t, err)
+	content, err := os.ReadFile(path + lockSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(os.Getpid())+"\n", string(content))
+
+	// Another process waits for the lock to be released.
+	released := make(chan struct{})
+	go func() {
+		time.Sleep(3 * lockRetry)
+		close(released)
+		lock.unlock()
+	}()
+
+	other, err := lockFile(path)
+	assert.NoError(t, err)
+	select {
+	case <-released:
+	default:
+		t.Error("lock taken before being released")
+	}
+	assert.NoError(t, other.unlock())
+
+	// The lock file is kept, unlocked.
+	lock, err = lockFile(path)
+	assert.NoError(t, err)
+	assert.NoError(t, lock.unlock())
+}
+
+func TestLockFileCrashed(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "regi.yaml")
+
+	// Another process holds the lock until it is killed.
+	holder := exec.Command(os.Args[0], "-test.run=^TestLockFileHolder$")
+	holder.Env = append(os.Environ(), lockHolderEnv+"="+path)
+	stdin, err := holder.StdinPipe()
+	assert.NoError(t, err)
+	defer stdin.Close()
+	stdout, err := holder.StdoutPipe()
+	assert.NoError(t, err)
+	assert.NoError(t, holder.Start())
+
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "locked\n", line)
+
+	// A live holder keeps its lock, however old it is.
+	old := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(path+lockSuffix, old, old))
+	f, err := os.OpenFile(path+lockSuffix, os.O_RDWR, 0600)
+	assert.NoError(t, err)
+	assert.Equal(t, errLocked, tryLockFile(f))
+	assert.NoError(t, f.Close())
+
+	content, err := os.ReadFile(path + lockSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(holder.Process.Pid), strings.TrimSpace(string(content)))
+
+	// The lock of a crashed process is released by the system.
+	assert.NoError(t, holder.Process.Kill())
+	holder.Wait()
+
+	start := time.Now()
+	lock, err := lockFile(path)
+	assert.NoError(t, err)
+	assert.Less(t, time.Since(start), lockTimeout)
+	assert.NoError(t, lock.unlock())
+}
+
+// TestLockFileHolder locks a file for TestLockFileCrashed, and holds it until killed.
+func TestLockFileHolder(t *testing.T) {
+	path := os.Getenv(lockHolderEnv)
+	if len(path) == 0 {
+		t.Skip("run by TestLockFileCrashed")
+	}
+
+	if _, err := lockFile(path); err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout.WriteString("locked\n")
+	io.ReadAll(os.Stdin)
+}
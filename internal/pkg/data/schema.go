@@ -0,0 +1,322 @@
+package data
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"strconv"
+)
+
+// SchemaVersion is the version of the storage file schema written by this regi. Files of older
+// versions are migrated on first load, a backup of them is kept next to the file.
+//
+// Versions:
+//  1. No version field. Contexts rewritten by regi 0.x have lowercased field names, e.g.
+//     'insecureskiptlsverify', as yaml.v2 writes untagged struct fields.
+//  2. The version field, and the field names of contextFields only.
+const SchemaVersion = 2
+
+// keyVersion holds the schema version of the storage file.
+const keyVersion = "version"
+
+// document is the typed content of the storage file.
+type document struct {
+	Version    int
+	Current    string
+	Registries []*Registry
+}
+
+// backup is the content of a storage file of an older schema version, kept once it is migrated.
+type backup struct {
+	version int
+	content []byte
+}
+
+// path returns the path of the backup of the storage file at the location.
+func (b *backup) path(location string) string {
+	return fmt.Sprintf("%s.v%d.bak", location, b.version)
+}
+
+// migrations upgrade the content of a storage file from a schema version to the next one. They
+// work on the YAML nodes, as the fields of older versions may not be known anymore.
+var migrations = map[int]func(root *yaml.Node) error{
+	// Version 2 adds the version field, and renames the lowercased field names.
+	1: func(root *yaml.Node) error {
+		for i := 0; i+1 < len(root.Content); i += 2 {
+			if key, value := root.Content[i], root.Content[i+1]; key.Value == keyRegistries &&
+				value.Kind == yaml.SequenceNode {
+				for _, n := range value.Content {
+					renameLegacyFields(n)
+				}
+			}
+		}
+		return nil
+	},
+}
+
+// legacyContextFields are the field names of version 1 contexts rewritten by regi 0.x, by the
+// keys they are known by since.
+var legacyContextFields = map[string]string{
+	"insecureskiptlsverify": keyRegistrySkip,
+}
+
+// renameLegacyFields renames the lowercased field names of a version 1 context. A legacy field
+// is dropped if the context has the field under its own name as well.
+func renameLegacyFields(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+
+	keys := map[string]bool{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		keys[n.Content[i].Value] = true
+	}
+
+	var content []*yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+		if name, ok := legacyContextFields[key.Value]; ok {
+			if keys[name] {
+				continue
+			}
+			key.Value = name
+		}
+		content = append(content, key, value)
+	}
+	n.Content = content
+}
+
+// contextField tells how to decode a field of a context.
+type contextField struct {
+	key     string
+	boolean bool
+	value   func(r *Registry) interface{}
+}
+
+// contextFields are the fields of a context, by their keys in the storage file.
+var contextFields = []contextField{
+	{key: keyRegistryName, value: func(r *Registry) interface{} { return &r.Name }},
+	{key: keyRegistryServer, value: func(r *Registry) interface{} { return &r.Server }},
+	{key: keyRegistrySkip, boolean: true, value: func(r *Registry) interface{} { return &r.InsecureSkipTLSVerify }},
+	{key: keyRegistryUser, value: func(r *Registry) interface{} { return &r.User }},
+	{key: keyRegistryPassword, value: func(r *Registry) interface{} { return &r.Password }},
+	{key: keyRegistryCA, value: func(r *Registry) interface{} { return &r.CAFile }},
+	{key: keyRegistryCert, value: func(r *Registry) interface{} { return &r.CertFile }},
+	{key: keyRegistryKey, value: func(r *Registry) interface{} { return &r.KeyFile }},
+	{key: keyRegistryCreds, value: func(r *Registry) interface{} { return &r.CredentialsStore }},
+}
+
+// fieldError tells why a field of the storage file, or of one of its contexts, is invalid.
+type fieldError struct {
+	// context is the quoted name of the context, or its position if it has no name.
+	context string
+	field   string
+	line    int
+	reason  string
+}
+
+// Error implements error.
+func (e *fieldError) Error() string {
+	var subject string
+	switch {
+	case len(e.context) > 0 && len(e.field) > 0:
+		subject = fmt.Sprintf("context %s: field %q", e.context, e.field)
+	case len(e.context) > 0:
+		subject = "context " + e.context
+	default:
+		subject = fmt.Sprintf("field %q", e.field)
+	}
+
+	if e.line > 0 {
+		return fmt.Sprintf("%s %s (line %d)", subject, e.reason, e.line)
+	}
+	return subject + " " + e.reason
+}
+
+// readDocument reads the storage file, and migrates its content to SchemaVersion. The backup to
+// keep is returned if it is migrated. An empty document is returned if the file does not exist.
+func readDocument(location string) (*document, *backup, error) {
+	content, err := os.ReadFile(location)
+	if os.IsNotExist(err) {
+		return &document{Version: SchemaVersion}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(content, &root); err != nil {
+		return nil, nil, corrupted(location, "it is not valid YAML: %s", err)
+	}
+
+	// Empty, or nothing but comments.
+	if root.Kind == 0 || len(root.Content) == 0 {
+		return &document{Version: SchemaVersion}, nil, nil
+	}
+
+	mapping := root.Content[0]
+	if mapping.Kind != yaml.MappingNode {
+		return nil, nil, corrupted(location, "it does not hold a map")
+	}
+
+	version, err := schemaVersion(mapping)
+	if err != nil {
+		return nil, nil, invalid(location, err)
+	}
+
+	if version > SchemaVersion {
+		return nil, nil, errors.Errorf("storage %s has schema version %d, this regi knows up to %d, please upgrade regi",
+			location, version, SchemaVersion)
+	}
+
+	var old *backup
+	if version < SchemaVersion {
+		if err := migrate(mapping, version); err != nil {
+			return nil, nil, errors.Wrapf(err, "storage %s", location)
+		}
+		old = &backup{version: version, content: content}
+	}
+
+	doc, err := decodeDocument(mapping)
+	if err != nil {
+		return nil, nil, invalid(location, err)
+	}
+	return doc, old, nil
+}
+
+// schemaVersion returns the schema version of the storage file content.
+func schemaVersion(root *yaml.Node) (int, error) {
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		if key.Value != keyVersion {
+			continue
+		}
+
+		var version int
+		if err := value.Decode(&version); err != nil || version < 1 {
+			return 0, &fieldError{field: keyVersion, line: value.Line, reason: "must be a positive integer"}
+		}
+		return version, nil
+	}
+
+	// Files written before the schema was versioned.
+	return 1, nil
+}
+
+// migrate upgrades the storage file content from the schema version to SchemaVersion.
+func migrate(root *yaml.Node, version int) error {
+	for v := version; v < SchemaVersion; v++ {
+		if err := migrations[v](root); err != nil {
+			return errors.Wrapf(err, "unable to migrate from schema version %d", v)
+		}
+	}
+	return nil
+}
+
+// decodeDocument decodes the storage file content of SchemaVersion, and validates it.
+func decodeDocument(root *yaml.Node) (*document, error) {
+	doc := &document{Version: SchemaVersion}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+		switch key.Value {
+		case keyVersion:
+			// Read by schemaVersion.
+		case keyCurrent:
+			if value.Kind != yaml.ScalarNode || value.Decode(&doc.Current) != nil {
+				return nil, &fieldError{field: keyCurrent, line: value.Line, reason: "must be a context name"}
+			}
+		case keyRegistries:
+			if value.Tag == "!!null" {
+				continue
+			}
+			if value.Kind != yaml.SequenceNode {
+				return nil, &fieldError{field: keyRegistries, line: value.Line, reason: "must be a list"}
+			}
+
+			seen := map[string]bool{}
+			for j, n := range value.Content {
+				reg, err := decodeContext(n, j)
+				if err != nil {
+					return nil, err
+				}
+
+				if seen[reg.Name] {
+					return nil, &fieldError{context: strconv.Quote(reg.Name), line: n.Line, reason: "is given twice"}
+				}
+				seen[reg.Name] = true
+				doc.Registries = append(doc.Registries, reg)
+			}
+		default:
+			return nil, &fieldError{field: key.Value, line: key.Line, reason: "is unknown"}
+		}
+	}
+	return doc, nil
+}
+
+// decodeStored decodes the context at the index of the registries, as kept in memory.
+func decodeStored(reg map[interface{}]interface{}, index int) (*Registry, error) {
+	var n yaml.Node
+	if err := n.Encode(reg); err != nil {
+		return nil, err
+	}
+	return decodeContext(&n, index)
+}
+
+// decodeContext decodes the context at the index of the registries, and validates it. The
+// password is kept as it is stored.
+func decodeContext(n *yaml.Node, index int) (*Registry, error) {
+	context := fmt.Sprintf("#%d", index+1)
+	if n.Kind != yaml.MappingNode {
+		return nil, &fieldError{context: context, line: n.Line, reason: "must be a map"}
+	}
+
+	// Errors name the context, once its name is known.
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if key, value := n.Content[i], n.Content[i+1]; key.Value == keyRegistryName &&
+			value.Kind == yaml.ScalarNode && len(value.Value) > 0 {
+			context = strconv.Quote(value.Value)
+		}
+	}
+
+	reg := &Registry{}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key, value := n.Content[i], n.Content[i+1]
+
+		var field *contextField
+		for j := range contextFields {
+			if contextFields[j].key == key.Value {
+				field = &contextFields[j]
+				break
+			}
+		}
+
+		if field == nil {
+			return nil, &fieldError{context: context, field: key.Value, line: key.Line, reason: "is unknown"}
+		}
+
+		if value.Tag == "!!null" {
+			continue
+		}
+
+		if field.boolean {
+			if value.Decode(field.value(reg)) != nil {
+				return nil, &fieldError{context: context, field: field.key, line: value.Line, reason: "must be a boolean"}
+			}
+			continue
+		}
+
+		if value.Kind != yaml.ScalarNode || value.Decode(field.value(reg)) != nil {
+			return nil, &fieldError{context: context, field: field.key, line: value.Line, reason: "must be a string"}
+		}
+	}
+
+	if len(reg.Name) == 0 {
+		return nil, &fieldError{context: context, field: keyRegistryName, line: n.Line, reason: "is required"}
+	}
+
+	if len(reg.Server) == 0 {
+		return nil, &fieldError{context: context, field: keyRegistryServer, line: n.Line, reason: "is required"}
+	}
+	return reg, nil
+}
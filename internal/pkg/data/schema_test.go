@@ -0,0 +1,183 @@
+package data
+
+import (
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDB_Invalid(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"version", "version: two\n", `field "version" must be a positive integer (line 1)`},
+		{"unknown", "version: 2\ncontexts: []\n", `field "contexts" is unknown (line 2)`},
+		{"current", "current: [regi]\n", `field "current" must be a context name (line 1)`},
+		{"registries", "registries: regi\n", `field "registries" must be a list (line 1)`},
+		{"context", "registries:\n- regi\n", `context #1 must be a map (line 2)`},
+		{"name", "registries:\n- server: https://registry.test\n", `context #1: field "name" is required (line 2)`},
+		{"server", "registries:\n- name: regi\n", `context "regi": field "server" is required (line 2)`},
+		{"string", "registries:\n- name: regi\n  server: [https://registry.test]\n",
+			`context "regi": field "server" must be a string (line 3)`},
+		{"boolean", "registries:\n- name: regi\n  server: https://registry.test\n  insecure-skip-tls-verify: maybe\n",
+			`context "regi": field "insecure-skip-tls-verify" must be a boolean (line 4)`},
+		{"field", "registries:\n- name: regi\n  server: https://registry.test\n  proxy: http://proxy.test\n",
+			`context "regi": field "proxy" is unknown (line 4)`},
+		{"twice", "registries:\n- name: regi\n  server: https://one.test\n- name: regi\n  server: https://two.test\n",
+			`context "regi" is given twice (line 4)`},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			location := filepath.Join(t.TempDir(), "regi.yaml")
+			assert.NoError(t, os.WriteFile(location, []byte(tc.content), 0600))
+
+			_, err := NewDBWithOptions(Options{Location: location})
+			assert.EqualError(t, err, "storage "+location+" is invalid, "+tc.err+
+				"; please fix it, or move it away and add the contexts again")
+
+			// Neither migrated nor backed up.
+			content, err := os.ReadFile(location)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.content, string(content))
+
+			_, err = os.Stat(location + ".v1.bak")
+			assert.True(t, os.IsNotExist(err))
+		})
+	}
+}
+
+func TestDB_Migrate(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "regi.yaml")
+
+	// Written by regi before the schema was versioned.
+	v1 := `current: prod
+registries:
+- name: prod
+  server: https://registry.test
+  insecure-skip-tls-verify: false
+  user: regi
+  password: ""
+- name: dev
+  server: http://localhost:5000
+  insecure-skip-tls-verify: true
+  user: null
+  password: null
+`
+	assert.NoError(t, os.WriteFile(location, []byte(v1), 0600))
+
+	db, err := NewDBWithOptions(Options{Location: location})
+	assert.NoError(t, err)
+
+	// The file is migrated on first load, its content is kept.
+	backup, err := os.ReadFile(location + ".v1.bak")
+	assert.NoError(t, err)
+	assert.Equal(t, v1, string(backup))
+
+	content, err := os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "version: 2\n")
+
+	for _, db := range []*DB{db, mustOpen(t, location)} {
+		registries, err := db.ListContexts()
+		assert.NoError(t, err)
+		assert.Equal(t, []*Registry{
+			{Name: "prod", Server: "https://registry.test", User: "regi"},
+			{Name: "dev", Server: "http://localhost:5000", InsecureSkipTLSVerify: true},
+		}, registries)
+
+		current, err := db.CurrentContext()
+		assert.NoError(t, err)
+		assert.Equal(t, "prod", current.Name)
+	}
+
+	// Up to date files are left as they are.
+	info, err := os.Stat(location)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(location+".v1.bak"))
+
+	mustOpen(t, location)
+	again, err := os.Stat(location)
+	assert.NoError(t, err)
+	assert.Equal(t, info.ModTime(), again.ModTime())
+
+	_, err = os.Stat(location + ".v1.bak")
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDB_MigrateLegacyFields(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "regi.yaml")
+
+	// Written by regi 0.x adding the contexts a, b and c, then deleting c, which rewrites all
+	// the contexts with lowercased field names.
+	v1 := `current: a
+registries:
+- insecureskiptlsverify: true
+  name: a
+  password: secret
+  server: https://a.test
+  user: alice
+- insecureskiptlsverify: false
+  name: b
+  password: ""
+  server: https://b.test
+  user: ""
+- name: d
+  server: https://d.test
+  insecure-skip-tls-verify: true
+  insecureskiptlsverify: false
+`
+	assert.NoError(t, os.WriteFile(location, []byte(v1), 0600))
+
+	db, err := NewDBWithOptions(Options{Location: location})
+	assert.NoError(t, err)
+
+	content, err := os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "insecureskiptlsverify")
+
+	for _, db := range []*DB{db, mustOpen(t, location)} {
+		registries, err := db.ListContexts()
+		assert.NoError(t, err)
+		assert.Equal(t, []*Registry{
+			{Name: "a", Server: "https://a.test", InsecureSkipTLSVerify: true, User: "alice", Password: "secret"},
+			{Name: "b", Server: "https://b.test"},
+			{Name: "d", Server: "https://d.test", InsecureSkipTLSVerify: true},
+		}, registries)
+	}
+
+	// Files of version 2 must not have them.
+	assert.NoError(t, os.WriteFile(location, []byte("version: 2\n"+v1), 0600))
+	_, err = NewDBWithOptions(Options{Location: location})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), `context "a": field "insecureskiptlsverify" is unknown (line 4)`)
+}
+
+func TestDB_NewerSchema(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "regi.yaml")
+	assert.NoError(t, os.WriteFile(location, []byte("version: 99\nnamespaces: []\n"), 0600))
+
+	_, err := NewDBWithOptions(Options{Location: location})
+	assert.EqualError(t, err, "storage "+location+" has schema version 99, this regi knows up to 2, please upgrade regi")
+}
+
+func TestDB_SchemaVersion(t *testing.T) {
+	location := filepath.Join(t.TempDir(), "regi.yaml")
+
+	db, err := NewDBWithOptions(Options{Location: location})
+	assert.NoError(t, err)
+	assert.NoError(t, db.SetCurrentContext(""))
+
+	// New files are written with the schema version.
+	content, err := os.ReadFile(location)
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "version: 2\n")
+}
+
+// mustOpen opens the storage file at the location.
+func mustOpen(t *testing.T, location string) *DB {
+	db, err := NewDBWithOptions(Options{Location: location})
+	assert.NoError(t, err)
+	return db
+}
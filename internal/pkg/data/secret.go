@@ -0,0 +1,249 @@
+package data
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// PassphraseEnv holds the passphrase to encrypt passwords with. If it is not set, passwords
+	// are encrypted with the key file.
+	PassphraseEnv = "REGI_PASSPHRASE"
+
+	// keyFile holds the random key passwords are encrypted with, next to the storage file.
+	keyFile = "secret.key"
+
+	// prefixKey marks a password encrypted with the key file.
+	prefixKey = "enc:key:"
+
+	// prefixPassphrase marks a password encrypted with a key derived from the passphrase.
+	prefixPassphrase = "enc:passphrase:"
+
+	keySize  = 32
+	saltSize = 16
+
+	// pbkdf2Iterations slows down guessing the passphrase.
+	pbkdf2Iterations = 200000
+)
+
+// IsEncrypted tells if a stored password is encrypted.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, prefixKey) || strings.HasPrefix(value, prefixPassphrase)
+}
+
+// secrets encrypts and decrypts passwords with AES-GCM. The key is either read from the key
+// file, which is created on first use, or derived from the passphrase given by PassphraseEnv.
+type secrets struct {
+	dir string
+
+	// key is the content of the key file, once read.
+	key []byte
+
+	// derived caches the keys derived from the passphrase, by salt.
+	derived map[string][]byte
+}
+
+// newSecrets returns secrets keeping the key file in the directory.
+func newSecrets(dir string) *secrets {
+	return &secrets{dir: dir, derived: map[string][]byte{}}
+}
+
+// encrypt encrypts a password with the passphrase given by PassphraseEnv, or with the key file.
+// An empty password is kept empty.
+func (s *secrets) encrypt(plain string) (string, error) {
+	return s.encryptWith(plain, os.Getenv(PassphraseEnv))
+}
+
+// encryptWith encrypts a password with the passphrase, or with the key file if it is empty.
+func (s *secrets) encryptWith(plain, passphrase string) (string, error) {
+	if len(plain) == 0 || IsEncrypted(plain) {
+		return plain, nil
+	}
+
+	var prefix string
+	var salt, key []byte
+	if len(passphrase) > 0 {
+		salt = make([]byte, saltSize)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return "", err
+		}
+		prefix, key = prefixPassphrase, s.passphraseKey(passphrase, salt)
+	} else {
+		var err error
+		if key, err = s.fileKey(true); err != nil {
+			return "", err
+		}
+		prefix = prefixKey
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(append(salt, nonce...), nonce, []byte(plain), nil)
+	return prefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt decrypts a password, with the passphrase given by PassphraseEnv if it is encrypted
+// with a passphrase. A password which is not encrypted is returned as it is.
+func (s *secrets) decrypt(value string) (string, error) {
+	return s.decryptWith(value, os.Getenv(PassphraseEnv))
+}
+
+// decryptWith decrypts a password, with the passphrase if it is encrypted with a passphrase.
+func (s *secrets) decryptWith(value, passphrase string) (string, error) {
+	var prefix string
+	switch {
+	case strings.HasPrefix(value, prefixKey):
+		prefix = prefixKey
+	case strings.HasPrefix(value, prefixPassphrase):
+		prefix = prefixPassphrase
+	default:
+		return value, nil
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, prefix))
+	if err != nil {
+		return "", errors.Wrap(err, "encrypted password is corrupted")
+	}
+
+	var key []byte
+	if prefix == prefixPassphrase {
+		if len(passphrase) == 0 {
+			return "", errors.Errorf("password is encrypted with a passphrase, please set %s", PassphraseEnv)
+		}
+		if len(sealed) < saltSize {
+			return "", errors.New("encrypted password is corrupted")
+		}
+		key, sealed = s.passphraseKey(passphrase, sealed[:saltSize]), sealed[saltSize:]
+	} else if key, err = s.fileKey(false); err != nil {
+		return "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("encrypted password is corrupted")
+	}
+
+	plain, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		if prefix == prefixPassphrase {
+			return "", errors.Errorf("unable to decrypt password, please check %s", PassphraseEnv)
+		}
+		return "", errors.Errorf("unable to decrypt password, %s does not match", filepath.Join(s.dir, keyFile))
+	}
+	return string(plain), nil
+}
+
+// fileKey reads the key file, and creates it with a random key if asked to.
+func (s *secrets) fileKey(create bool) ([]byte, error) {
+	if s.key != nil {
+		return s.key, nil
+	}
+
+	p := filepath.Join(s.dir, keyFile)
+	key, err := os.ReadFile(p)
+	switch {
+	case err == nil:
+		if len(key) != keySize {
+			return nil, errors.Errorf("invalid key file %s, it must hold %d bytes", p, keySize)
+		}
+	case os.IsNotExist(err) && create:
+		key = make([]byte, keySize)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(s.dir, 0700); err != nil {
+			return nil, err
+		}
+
+		// Never overwrite a key another process has just created.
+		f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err != nil {
+			if os.IsExist(err) {
+				return s.fileKey(false)
+			}
+			return nil, err
+		}
+		if _, err := f.Write(key); err != nil {
+			f.Close()
+			return nil, err
+		}
+		if err := f.Close(); err != nil {
+			return nil, err
+		}
+	case os.IsNotExist(err):
+		return nil, errors.Errorf("key file %s is missing, passwords encrypted with it can not be decrypted", p)
+	default:
+		return nil, err
+	}
+
+	s.key = key
+	return key, nil
+}
+
+// passphraseKey derives a key from the passphrase and the salt.
+func (s *secrets) passphraseKey(passphrase string, salt []byte) []byte {
+	if key, ok := s.derived[string(salt)]; ok {
+		return key
+	}
+
+	key := pbkdf2([]byte(passphrase), salt, pbkdf2Iterations, keySize)
+	s.derived[string(salt)] = key
+	return key
+}
+
+// newGCM returns AES-GCM with the key.
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// pbkdf2 derives a key with PBKDF2-HMAC-SHA256, as defined by RFC 8018.
+func pbkdf2(password, salt []byte, iterations, size int) []byte {
+	prf := hmac.New(sha256.New, password)
+
+	var key []byte
+	for block := uint32(1); len(key) < size; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.Write(prf, binary.BigEndian, block)
+		u := prf.Sum(nil)
+
+		t := make([]byte, len(u))
+		copy(t, u)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:size]
+}
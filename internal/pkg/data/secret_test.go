@@ -0,0 +1,109 @@
+package data
+
+import (
+	"encoding/hex"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSecrets_KeyFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(PassphraseEnv, "")
+
+	s := newSecrets(dir)
+	value, err := s.encrypt("regi")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(value, prefixKey))
+	assert.NotContains(t, value, "regi")
+
+	// The key file is created for the user only.
+	info, err := os.Stat(filepath.Join(dir, keyFile))
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// Another process reads the same key.
+	plain, err := newSecrets(dir).decrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", plain)
+
+	// Empty and plain text passwords are kept as they are.
+	empty, err := s.encrypt("")
+	assert.NoError(t, err)
+	assert.Empty(t, empty)
+	plain, err = s.decrypt("legacy")
+	assert.NoError(t, err)
+	assert.Equal(t, "legacy", plain)
+
+	// Without the key file.
+	_, err = newSecrets(t.TempDir()).decrypt(value)
+	assert.Error(t, err)
+}
+
+func TestSecrets_Passphrase(t *testing.T) {
+	t.Setenv(PassphraseEnv, "correct horse battery staple")
+
+	value, err := newSecrets(t.TempDir()).encrypt("regi")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(value, prefixPassphrase))
+
+	plain, err := newSecrets(t.TempDir()).decrypt(value)
+	assert.NoError(t, err)
+	assert.Equal(t, "regi", plain)
+
+	t.Setenv(PassphraseEnv, "wrong")
+	_, err = newSecrets(t.TempDir()).decrypt(value)
+	assert.EqualError(t, err, "unable to decrypt password, please check REGI_PASSPHRASE")
+
+	t.Setenv(PassphraseEnv, "")
+	_, err = newSecrets(t.TempDir()).decrypt(value)
+	assert.EqualError(t, err, "password is encrypted with a passphrase, please set REGI_PASSPHRASE")
+}
+
+func TestPBKDF2(t *testing.T) {
+	cases := []struct {
+		name       string
+		password   string
+		salt       string
+		iterations int
+		expected   string
+	}{
+		{
+			name:       "RFC 7914, single iteration",
+			password:   "passwd",
+			salt:       "salt",
+			iterations: 1,
+			expected: "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc" +
+				"49ca9cccf179b645991664b39d77ef317c71b845b1e30bd509112041d3a19783",
+		},
+		{
+			name:       "RFC 7914, many iterations",
+			password:   "Password",
+			salt:       "NaCl",
+			iterations: 80000,
+			expected: "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56" +
+				"a1d425a1225833549adb841b51c9b3176a272bdebba1d078478f62b397f33c8d",
+		},
+		{
+			name:       "RFC 6070 inputs, one block",
+			password:   "password",
+			salt:       "salt",
+			iterations: 4096,
+			expected:   "c5e478d59288c841aa530db6845c4c8d962893a001ce4e11a4963873aa98134a",
+		},
+		{
+			name:       "RFC 6070 inputs, partial last block",
+			password:   "passwordPASSWORDpassword",
+			salt:       "saltSALTsaltSALTsaltSALTsaltSALTsalt",
+			iterations: 4096,
+			expected:   "348c89dbcbd32b2f32d814b8116e84cf2b17347ebc1800181c4e2a1fb8dd53e1c635518c7dac47e9",
+		},
+	}
+
+	for _, c := range cases {
+		key := pbkdf2([]byte(c.password), []byte(c.salt), c.iterations, len(c.expected)/2)
+		assert.Equal(t, c.expected, hex.EncodeToString(key), c.name)
+	}
+}
@@ -0,0 +1,228 @@
+package layout
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// dirWriter writes an image to an OCI image layout directory. Images already in the layout
+// are kept, except the one with the same name which is replaced.
+type dirWriter struct {
+	path  string
+	name  string
+	index registry.Index
+}
+
+// NewDirWriter returns a Writer storing the image with the name, e.g. 'golang:1.17', in an
+// OCI image layout directory. The directory is created if it does not exist.
+func NewDirWriter(p, name string) (Writer, error) {
+	if err := os.MkdirAll(filepath.Join(p, blobsDir, "sha256"), 0755); err != nil {
+		return nil, err
+	}
+
+	w := &dirWriter{
+		path: p,
+		name: name,
+		index: registry.Index{
+			SchemaVersion: 2,
+			MediaType:     registry.MediaTypeOCIIndex,
+			Manifests:     []registry.Descriptor{},
+		},
+	}
+
+	// Keep the images of an existing layout.
+	content, err := os.ReadFile(filepath.Join(p, indexFile))
+	switch {
+	case err == nil:
+		if err := json.Unmarshal(content, &w.index); err != nil {
+			return nil, errors.Wrapf(err, "unable to decode %s", filepath.Join(p, indexFile))
+		}
+	case !os.IsNotExist(err):
+		return nil, err
+	}
+
+	if err := os.WriteFile(filepath.Join(p, layoutFile), []byte(layoutContent), 0644); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// HasBlob implements registry.Target.
+func (w *dirWriter) HasBlob(desc registry.Descriptor) (bool, error) {
+	p, err := blobPath(desc.Digest)
+	if err != nil {
+		return false, err
+	}
+
+	info, err := os.Stat(filepath.Join(w.path, p))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return info.Size() == desc.Size, nil
+}
+
+// PutBlob implements registry.Target. The blob is written to a temporary file first, which is
+// renamed once the content is verified.
+func (w *dirWriter) PutBlob(desc registry.Descriptor, content io.Reader) error {
+	p, err := blobPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(w.path, filepath.Dir(p))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	d := newDigester()
+	if _, err := io.Copy(io.MultiWriter(tmp, d), content); err != nil {
+		tmp.Close()
+		return errors.Wrapf(err, "unable to write blob %s", desc.Digest)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := d.verify(desc); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(w.path, p))
+}
+
+// PutManifest implements registry.Target.
+func (w *dirWriter) PutManifest(m *registry.RawManifest, tag string) error {
+	entry := newIndexEntry(m, w.name, tag)
+	if err := w.PutBlob(entry, bytes.NewReader(m.Body)); err != nil {
+		return err
+	}
+
+	if len(tag) == 0 {
+		return nil
+	}
+
+	// Replace the image with the same name.
+	manifests := []registry.Descriptor{}
+	for _, d := range w.index.Manifests {
+		if !sameImage(d, entry) {
+			manifests = append(manifests, d)
+		}
+	}
+	w.index.Manifests = append(manifests, entry)
+	return nil
+}
+
+// Close implements io.Closer. It writes the index of the layout.
+func (w *dirWriter) Close() error {
+	content, err := json.MarshalIndent(w.index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(w.path, indexFile), content, 0644)
+}
+
+// dirReader reads images from an OCI image layout directory.
+type dirReader struct {
+	path  string
+	index registry.Index
+}
+
+// openDir opens an OCI image layout directory.
+func openDir(p string) (*dirReader, error) {
+	content, err := os.ReadFile(filepath.Join(p, indexFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, errors.Errorf("%s is not an OCI image layout, %s is missing", p, indexFile)
+		}
+		return nil, err
+	}
+
+	r := &dirReader{path: p}
+	if err := json.Unmarshal(content, &r.index); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode %s", filepath.Join(p, indexFile))
+	}
+	return r, nil
+}
+
+// Image implements Reader.
+func (r *dirReader) Image(name string) (*registry.RawManifest, error) {
+	desc, err := findImage(&r.index, name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to find image in %s", r.path)
+	}
+	return readIndexedManifest(r, desc)
+}
+
+// Manifest implements registry.Source.
+func (r *dirReader) Manifest(digest string) (*registry.RawManifest, error) {
+	return readManifest(r, digest)
+}
+
+// Blob implements registry.Source.
+func (r *dirReader) Blob(desc registry.Descriptor) (io.ReadCloser, error) {
+	p, err := blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(r.path, p))
+	if os.IsNotExist(err) {
+		return nil, errors.Errorf("blob %s not found in %s", desc.Digest, r.path)
+	}
+	return f, err
+}
+
+// Close implements io.Closer.
+func (r *dirReader) Close() error {
+	return nil
+}
+
+// readManifest reads a manifest stored as a blob.
+func readManifest(src registry.Source, digest string) (*registry.RawManifest, error) {
+	blob, err := src.Blob(registry.Descriptor{Digest: digest})
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	body, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read manifest %s", digest)
+	}
+
+	if actual := registry.Digest(body); actual != digest {
+		return nil, errors.Errorf("manifest %s is corrupted, digest of the content is %s", digest, actual)
+	}
+
+	return &registry.RawManifest{
+		MediaType: registry.DetectManifestMediaType(body),
+		Digest:    digest,
+		Body:      body,
+	}, nil
+}
+
+// readIndexedManifest reads a manifest referred to by an index, which tells its media type.
+func readIndexedManifest(src registry.Source, desc *registry.Descriptor) (*registry.RawManifest, error) {
+	m, err := readManifest(src, desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(desc.MediaType) > 0 {
+		m.MediaType = desc.MediaType
+	}
+	return m, nil
+}
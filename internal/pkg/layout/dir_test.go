@@ -0,0 +1,70 @@
+package layout
+
+import (
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	server := registrytest.NewServer(t)
+	image := server.AddImage("hello-world", "latest", []byte(`{"architecture":"amd64","os":"linux"}`), []byte("layer-1"))
+	index := server.AddIndex("busybox", "latest", "linux/amd64", "linux/arm64")
+
+	client, err := registry.NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+
+	// Write two images to the same layout.
+	p := filepath.Join(t.TempDir(), "images")
+	for _, name := range []string{"hello-world", "busybox"} {
+		m, err := client.GetManifest(name, "latest")
+		assert.NoError(t, err)
+
+		w, err := NewDirWriter(p, name+":latest")
+		assert.NoError(t, err)
+		assert.NoError(t, registry.Copy(client.Repository(name), w, m, "latest", nil))
+		assert.NoError(t, w.Close())
+	}
+
+	content, err := os.ReadFile(filepath.Join(p, "oci-layout"))
+	assert.NoError(t, err)
+	assert.Equal(t, layoutContent, string(content))
+
+	// Read.
+	r, err := Open(p)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	m, err := r.Image("hello-world:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, image, m.Digest)
+
+	m, err = r.Image("busybox:latest")
+	assert.NoError(t, err)
+	assert.Equal(t, index, m.Digest)
+	assert.True(t, m.IsIndex())
+
+	_, err = r.Image("latest")
+	assert.NoError(t, err)
+
+	_, err = r.Image("alpine:latest")
+	assert.EqualError(t, err, `unable to find image in `+p+`: image "alpine:latest" not found, available images are: busybox:latest, hello-world:latest`)
+
+	// A platform is picked out of the index.
+	arm64, err := registry.ResolvePlatform(r, m, registry.Platform{OS: "linux", Architecture: "arm64"})
+	assert.NoError(t, err)
+	assert.Equal(t, registry.MediaTypeDockerManifest, arm64.MediaType)
+
+	// The index is copied back with its platform manifests.
+	assert.NoError(t, registry.Copy(r, client.Repository("copy"), m, "latest", nil))
+	assert.Equal(t, map[string]string{"latest": index}, server.Tags("copy"))
+	assert.True(t, server.HasManifest("copy", arm64.Digest))
+
+	// A layout is required.
+	_, err = Open(t.TempDir())
+	assert.Error(t, err)
+}
@@ -0,0 +1,174 @@
+// Package layout stores images on disk, either as an OCI image layout directory or as a
+// tarball which can be loaded by 'docker load'.
+package layout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// layoutFile marks the root of an OCI image layout.
+	layoutFile = "oci-layout"
+
+	// layoutContent is the content of the oci-layout file.
+	layoutContent = `{"imageLayoutVersion":"1.0.0"}`
+
+	// indexFile is the entry point of an OCI image layout.
+	indexFile = "index.json"
+
+	// dockerManifestFile describes the images of a tarball made by 'docker save'.
+	dockerManifestFile = "manifest.json"
+
+	// blobsDir holds the blobs of an OCI image layout, by algorithm and hex digest.
+	blobsDir = "blobs"
+
+	// annotationRefName is the annotation holding the tag of an image in an OCI image layout.
+	annotationRefName = "org.opencontainers.image.ref.name"
+
+	// annotationImageName is the annotation holding the full name of an image, e.g.
+	// 'registry.example.com/golang:1.17', as written by containerd and docker.
+	annotationImageName = "io.containerd.image.name"
+)
+
+// Writer stores an image on disk. The image is complete once its tagged manifest is put and
+// the writer is closed.
+type Writer interface {
+	registry.Target
+	io.Closer
+}
+
+// Reader reads images stored on disk.
+type Reader interface {
+	registry.Source
+	io.Closer
+
+	// Image returns the manifest of the image with the name, e.g. 'golang:1.17'. If no image
+	// has the name and the layout holds a single image, that one is returned.
+	Image(name string) (*registry.RawManifest, error)
+}
+
+// Open opens an OCI image layout directory, or a tarball made by regi or 'docker save'.
+func Open(p string) (Reader, error) {
+	info, err := os.Stat(p)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return openDir(p)
+	}
+	return openTar(p)
+}
+
+// dockerManifest is an entry of the manifest.json file of a 'docker save' tarball.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// blobPath returns the path of a blob in an OCI image layout, e.g. 'blobs/sha256/<hex>'.
+func blobPath(digest string) (string, error) {
+	parts := strings.SplitN(digest, ":", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 || strings.ContainsAny(parts[1], "/\\.") {
+		return "", errors.Errorf("invalid digest %q", digest)
+	}
+	return path.Join(blobsDir, parts[0], parts[1]), nil
+}
+
+// digester computes the sha256 digest and the size of the content written to it.
+type digester struct {
+	hash hash.Hash
+	size int64
+}
+
+// newDigester returns a digester.
+func newDigester() *digester {
+	return &digester{hash: sha256.New()}
+}
+
+// Write implements io.Writer.
+func (d *digester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	return d.hash.Write(p)
+}
+
+// Digest returns the digest of the content written so far.
+func (d *digester) Digest() string {
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(d.hash.Sum(nil)))
+}
+
+// verify checks the content written against the descriptor.
+func (d *digester) verify(desc registry.Descriptor) error {
+	if d.size != desc.Size {
+		return errors.Errorf("size of blob %s is %d, expected %d", desc.Digest, d.size, desc.Size)
+	}
+	if actual := d.Digest(); actual != desc.Digest {
+		return errors.Errorf("blob %s is corrupted, digest of the content is %s", desc.Digest, actual)
+	}
+	return nil
+}
+
+// findImage finds the descriptor of the image with the name in an index. An image matches if
+// its full name or its tag is the name, or if its full name ends with '/<name>'.
+func findImage(index *registry.Index, name string) (*registry.Descriptor, error) {
+	var names []string
+	for i, m := range index.Manifests {
+		imageName := m.Annotations[annotationImageName]
+		refName := m.Annotations[annotationRefName]
+		if len(name) > 0 && (imageName == name || refName == name || strings.HasSuffix(imageName, "/"+name)) {
+			return &index.Manifests[i], nil
+		}
+
+		if len(imageName) == 0 {
+			imageName = refName
+		}
+		names = append(names, imageName)
+	}
+
+	if len(index.Manifests) == 1 {
+		return &index.Manifests[0], nil
+	}
+
+	if len(index.Manifests) == 0 {
+		return nil, errors.New("no image found")
+	}
+
+	sort.Strings(names)
+	return nil, errors.Errorf("image %q not found, available images are: %s", name, strings.Join(names, ", "))
+}
+
+// newIndexEntry returns the index entry of a tagged manifest.
+func newIndexEntry(m *registry.RawManifest, name, tag string) registry.Descriptor {
+	desc := registry.Descriptor{
+		MediaType: m.MediaType,
+		Digest:    registry.Digest(m.Body),
+		Size:      int64(len(m.Body)),
+		Annotations: map[string]string{
+			annotationRefName: tag,
+		},
+	}
+	if len(name) > 0 {
+		desc.Annotations[annotationImageName] = name
+	}
+	return desc
+}
+
+// sameImage tells if two index entries name the same image.
+func sameImage(a, b registry.Descriptor) bool {
+	if name := a.Annotations[annotationImageName]; len(name) > 0 {
+		return name == b.Annotations[annotationImageName]
+	}
+	return len(b.Annotations[annotationImageName]) == 0 &&
+		a.Annotations[annotationRefName] == b.Annotations[annotationRefName]
+}
@@ -0,0 +1,432 @@
+package layout
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxMetadataSize limits the size of the JSON files describing the images of a tarball.
+const maxMetadataSize = 16 * 1024 * 1024
+
+// tarWriter writes an image to a tarball holding an OCI image layout together with the
+// manifest.json file of 'docker save', so that it can be loaded by 'docker load' as well.
+type tarWriter struct {
+	file *os.File
+	tw   *tar.Writer
+	name string
+
+	// written tells the blobs written so far.
+	written map[string]bool
+
+	// entry is the index entry of the tagged manifest, config and layers are those of its image.
+	entry  *registry.Descriptor
+	config string
+	layers []string
+}
+
+// NewTarWriter returns a Writer storing the image with the name, e.g. 'registry.example.com/golang:1.17',
+// in a tarball. The file is overwritten if it exists.
+func NewTarWriter(p, name string) (Writer, error) {
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tarWriter{
+		file:    f,
+		tw:      tar.NewWriter(f),
+		name:    name,
+		written: map[string]bool{},
+	}, nil
+}
+
+// HasBlob implements registry.Target.
+func (w *tarWriter) HasBlob(desc registry.Descriptor) (bool, error) {
+	return w.written[desc.Digest], nil
+}
+
+// PutBlob implements registry.Target.
+func (w *tarWriter) PutBlob(desc registry.Descriptor, content io.Reader) error {
+	p, err := blobPath(desc.Digest)
+	if err != nil {
+		return err
+	}
+
+	if err := w.tw.WriteHeader(newTarHeader(p, desc.Size)); err != nil {
+		return err
+	}
+
+	d := newDigester()
+	if _, err := io.Copy(io.MultiWriter(w.tw, d), content); err != nil {
+		return errors.Wrapf(err, "unable to write blob %s", desc.Digest)
+	}
+	if err := d.verify(desc); err != nil {
+		return err
+	}
+
+	w.written[desc.Digest] = true
+	return nil
+}
+
+// PutManifest implements registry.Target.
+func (w *tarWriter) PutManifest(m *registry.RawManifest, tag string) error {
+	entry := newIndexEntry(m, w.name, tag)
+	if !w.written[entry.Digest] {
+		if err := w.PutBlob(entry, bytes.NewReader(m.Body)); err != nil {
+			return err
+		}
+	}
+
+	if len(tag) == 0 {
+		return nil
+	}
+	w.entry = &entry
+
+	// Docker only loads images, not indexes, from manifest.json.
+	if m.IsIndex() {
+		return nil
+	}
+
+	manifest, err := m.Manifest()
+	if err != nil {
+		return err
+	}
+
+	if w.config, err = blobPath(manifest.Config.Digest); err != nil {
+		return err
+	}
+	for _, l := range manifest.Layers {
+		p, err := blobPath(l.Digest)
+		if err != nil {
+			return err
+		}
+		w.layers = append(w.layers, p)
+	}
+	return nil
+}
+
+// Close implements io.Closer. It writes the files describing the image, if the tagged manifest
+// has been put.
+func (w *tarWriter) Close() error {
+	if w.entry != nil {
+		if err := w.writeMetadata(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+
+	if err := w.tw.Close(); err != nil {
+		w.file.Close()
+		return err
+	}
+	return w.file.Close()
+}
+
+// writeMetadata writes the oci-layout, index.json and manifest.json files.
+func (w *tarWriter) writeMetadata() error {
+	index, err := json.Marshal(registry.Index{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeOCIIndex,
+		Manifests:     []registry.Descriptor{*w.entry},
+	})
+	if err != nil {
+		return err
+	}
+
+	type file struct {
+		name    string
+		content []byte
+	}
+
+	files := []file{
+		{name: layoutFile, content: []byte(layoutContent)},
+		{name: indexFile, content: index},
+	}
+
+	if len(w.config) > 0 {
+		var repoTags []string
+		if len(w.name) > 0 {
+			repoTags = []string{w.name}
+		}
+
+		manifest, err := json.Marshal([]dockerManifest{{Config: w.config, RepoTags: repoTags, Layers: w.layers}})
+		if err != nil {
+			return err
+		}
+		files = append(files, file{name: dockerManifestFile, content: manifest})
+	}
+
+	for _, f := range files {
+		if err := w.tw.WriteHeader(newTarHeader(f.name, int64(len(f.content)))); err != nil {
+			return err
+		}
+		if _, err := w.tw.Write(f.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// newTarHeader returns the header of a regular file in a tarball.
+func newTarHeader(name string, size int64) *tar.Header {
+	return &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Unix(0, 0),
+	}
+}
+
+// tarEntry is a regular file of a tarball.
+type tarEntry struct {
+	name string
+	size int64
+	gzip bool
+}
+
+// tarReader reads images from a tarball holding an OCI image layout, or from a tarball made by
+// 'docker save' before OCI image layouts were supported. Files of a tarball can only be read
+// in sequence, so the tarball is scanned once to find every file by the digest of its content.
+type tarReader struct {
+	path string
+
+	// entries maps digest of the content to file.
+	entries map[string]tarEntry
+
+	// index is the index of the OCI image layout, if there is one.
+	index *registry.Index
+
+	// images are the images listed in the manifest.json file of 'docker save'.
+	images []dockerManifest
+
+	// byName maps file name to digest of the content.
+	byName map[string]string
+
+	// manifests holds the manifests made up for images of 'docker save'.
+	manifests map[string][]byte
+}
+
+// openTar opens and scans a tarball.
+func openTar(p string) (*tarReader, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := &tarReader{
+		path:      p,
+		entries:   map[string]tarEntry{},
+		byName:    map[string]string{},
+		manifests: map[string][]byte{},
+	}
+
+	var index, manifest []byte
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to read %s", p)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(h.Name)
+		d := newDigester()
+		var head bytes.Buffer
+		content := io.TeeReader(tr, d)
+
+		// Keep the files describing the images, and the first bytes of each file to tell
+		// whether it is compressed.
+		switch {
+		case name == indexFile || name == dockerManifestFile:
+			data, err := io.ReadAll(io.LimitReader(content, maxMetadataSize))
+			if err != nil {
+				return nil, errors.Wrapf(err, "unable to read %s in %s", name, p)
+			}
+			if name == indexFile {
+				index = data
+			} else {
+				manifest = data
+			}
+		default:
+			if _, err := io.CopyN(&head, content, 2); err != nil && err != io.EOF {
+				return nil, errors.Wrapf(err, "unable to read %s in %s", name, p)
+			}
+			if _, err := io.Copy(io.Discard, content); err != nil {
+				return nil, errors.Wrapf(err, "unable to read %s in %s", name, p)
+			}
+		}
+
+		digest := d.Digest()
+		r.entries[digest] = tarEntry{
+			name: name,
+			size: d.size,
+			gzip: bytes.HasPrefix(head.Bytes(), []byte{0x1f, 0x8b}),
+		}
+		r.byName[name] = digest
+	}
+
+	switch {
+	case index != nil:
+		r.index = &registry.Index{}
+		if err := json.Unmarshal(index, r.index); err != nil {
+			return nil, errors.Wrapf(err, "unable to decode %s in %s", indexFile, p)
+		}
+	case manifest != nil:
+		if err := json.Unmarshal(manifest, &r.images); err != nil {
+			return nil, errors.Wrapf(err, "unable to decode %s in %s", dockerManifestFile, p)
+		}
+	default:
+		return nil, errors.Errorf("%s is neither an OCI image layout nor a 'docker save' tarball", p)
+	}
+	return r, nil
+}
+
+// Image implements Reader.
+func (r *tarReader) Image(name string) (*registry.RawManifest, error) {
+	if r.index != nil {
+		desc, err := findImage(r.index, name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to find image in %s", r.path)
+		}
+		return readIndexedManifest(r, desc)
+	}
+
+	image, err := r.findDockerImage(name)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to find image in %s", r.path)
+	}
+	return r.dockerImageManifest(image)
+}
+
+// findDockerImage finds the image with the name in the manifest.json file of 'docker save'.
+func (r *tarReader) findDockerImage(name string) (*dockerManifest, error) {
+	var names []string
+	for i, image := range r.images {
+		for _, tag := range image.RepoTags {
+			if len(name) > 0 && (tag == name || strings.HasSuffix(tag, "/"+name)) {
+				return &r.images[i], nil
+			}
+			names = append(names, tag)
+		}
+	}
+
+	if len(r.images) == 1 {
+		return &r.images[0], nil
+	}
+
+	if len(r.images) == 0 {
+		return nil, errors.New("no image found")
+	}
+
+	sort.Strings(names)
+	return nil, errors.Errorf("image %q not found, available images are: %s", name, strings.Join(names, ", "))
+}
+
+// dockerImageManifest makes up a Docker image manifest for an image of 'docker save', whose
+// layers are either compressed or not.
+func (r *tarReader) dockerImageManifest(image *dockerManifest) (*registry.RawManifest, error) {
+	config, err := r.descriptor(image.Config, registry.MediaTypeDockerConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := registry.Manifest{
+		SchemaVersion: 2,
+		MediaType:     registry.MediaTypeDockerManifest,
+		Config:        config,
+		Layers:        []registry.Descriptor{},
+	}
+	for _, l := range image.Layers {
+		desc, err := r.descriptor(l, registry.MediaTypeDockerLayerTar)
+		if err != nil {
+			return nil, err
+		}
+		if r.entries[desc.Digest].gzip {
+			desc.MediaType = registry.MediaTypeDockerLayer
+		}
+		manifest.Layers = append(manifest.Layers, desc)
+	}
+
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := registry.Digest(body)
+	r.manifests[digest] = body
+	return &registry.RawManifest{MediaType: registry.MediaTypeDockerManifest, Digest: digest, Body: body}, nil
+}
+
+// descriptor returns the descriptor of a file of the tarball.
+func (r *tarReader) descriptor(name, mediaType string) (registry.Descriptor, error) {
+	digest, ok := r.byName[path.Clean(name)]
+	if !ok {
+		return registry.Descriptor{}, errors.Errorf("file %s not found in %s", name, r.path)
+	}
+	return registry.Descriptor{MediaType: mediaType, Digest: digest, Size: r.entries[digest].size}, nil
+}
+
+// Manifest implements registry.Source.
+func (r *tarReader) Manifest(digest string) (*registry.RawManifest, error) {
+	if body, ok := r.manifests[digest]; ok {
+		return &registry.RawManifest{MediaType: registry.MediaTypeDockerManifest, Digest: digest, Body: body}, nil
+	}
+	return readManifest(r, digest)
+}
+
+// Blob implements registry.Source.
+func (r *tarReader) Blob(desc registry.Descriptor) (io.ReadCloser, error) {
+	entry, ok := r.entries[desc.Digest]
+	if !ok {
+		return nil, errors.Errorf("blob %s not found in %s", desc.Digest, r.path)
+	}
+
+	f, err := os.Open(r.path)
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err != nil {
+			f.Close()
+			return nil, errors.Wrapf(err, "unable to read %s from %s", entry.name, r.path)
+		}
+		if h.Typeflag == tar.TypeReg && path.Clean(h.Name) == entry.name {
+			return &tarBlob{Reader: tr, file: f}, nil
+		}
+	}
+}
+
+// Close implements io.Closer.
+func (r *tarReader) Close() error {
+	return nil
+}
+
+// tarBlob reads a file of a tarball, and closes the tarball when done.
+type tarBlob struct {
+	io.Reader
+	file *os.File
+}
+
+// Close implements io.Closer.
+func (b *tarBlob) Close() error {
+	return b.file.Close()
+}
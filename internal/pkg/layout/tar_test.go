@@ -0,0 +1,158 @@
+package layout
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/registry"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTar(t *testing.T) {
+	server := registrytest.NewServer(t)
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	digest := server.AddImage("hello-world", "latest", config, []byte("layer-1"), []byte("layer-2"))
+
+	client, err := registry.NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+	m, err := client.GetManifest("hello-world", "latest")
+	assert.NoError(t, err)
+
+	// Write.
+	p := filepath.Join(t.TempDir(), "hello-world.tar")
+	w, err := NewTarWriter(p, "registry.test/hello-world:latest")
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Copy(client.Repository("hello-world"), w, m, "latest", nil))
+	assert.NoError(t, w.Close())
+
+	files := readTar(t, p)
+	assert.Contains(t, files, "oci-layout")
+	assert.Contains(t, files, "index.json")
+
+	var images []dockerManifest
+	assert.NoError(t, json.Unmarshal(files["manifest.json"], &images))
+	assert.Equal(t, []string{"registry.test/hello-world:latest"}, images[0].RepoTags)
+	assert.Equal(t, config, files[images[0].Config])
+	assert.Equal(t, []byte("layer-2"), files[images[0].Layers[1]])
+
+	// Read.
+	r, err := Open(p)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	for _, name := range []string{"hello-world:latest", "latest", "", "unknown:latest"} {
+		image, err := r.Image(name)
+		assert.NoError(t, err, name)
+		assert.Equal(t, digest, image.Digest, name)
+		assert.Equal(t, registry.MediaTypeDockerManifest, image.MediaType, name)
+	}
+
+	// Copied back, the image keeps its digest.
+	image, err := r.Image("hello-world:latest")
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Copy(r, client.Repository("copy"), image, "latest", nil))
+	assert.Equal(t, map[string]string{"latest": digest}, server.Tags("copy"))
+}
+
+func TestTar_DockerSave(t *testing.T) {
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := []byte("uncompressed layer")
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write([]byte("compressed layer"))
+	gz.Close()
+
+	manifest, _ := json.Marshal([]dockerManifest{{
+		Config:   "0123.json",
+		RepoTags: []string{"golang:1.17"},
+		Layers:   []string{"a/layer.tar", "b/layer.tar"},
+	}})
+
+	p := filepath.Join(t.TempDir(), "golang.tar")
+	writeTar(t, p, map[string][]byte{
+		"manifest.json": manifest,
+		"0123.json":     config,
+		"a/layer.tar":   layer,
+		"b/layer.tar":   compressed.Bytes(),
+	})
+
+	r, err := Open(p)
+	assert.NoError(t, err)
+	defer r.Close()
+
+	_, err = r.Image("golang:1.18")
+	assert.NoError(t, err, "the only image is picked")
+
+	image, err := r.Image("golang:1.17")
+	assert.NoError(t, err)
+	m, err := image.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, registry.MediaTypeDockerConfig, m.Config.MediaType)
+	assert.Equal(t, registry.Digest(config), m.Config.Digest)
+	assert.Equal(t, registry.MediaTypeDockerLayerTar, m.Layers[0].MediaType)
+	assert.Equal(t, int64(len(layer)), m.Layers[0].Size)
+	assert.Equal(t, registry.MediaTypeDockerLayer, m.Layers[1].MediaType)
+
+	// Pushed to a registry.
+	server := registrytest.NewServer(t)
+	client, err := registry.NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+	assert.NoError(t, registry.Copy(r, client.Repository("golang"), image, "1.17", nil))
+
+	content, ok := server.Blob("golang", m.Layers[0].Digest)
+	assert.True(t, ok)
+	assert.Equal(t, layer, content)
+	assert.True(t, server.HasManifest("golang", image.Digest))
+}
+
+func TestTar_Invalid(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "empty.tar")
+	writeTar(t, p, map[string][]byte{"README": []byte("hello")})
+
+	_, err := Open(p)
+	assert.Error(t, err)
+}
+
+// writeTar writes files to a tarball.
+func writeTar(t *testing.T, p string, files map[string][]byte) {
+	f, err := os.Create(p)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	tw := tar.NewWriter(f)
+	for name, content := range files {
+		assert.NoError(t, tw.WriteHeader(newTarHeader(name, int64(len(content)))))
+		_, err := tw.Write(content)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, tw.Close())
+}
+
+// readTar reads the files of a tarball.
+func readTar(t *testing.T, p string) map[string][]byte {
+	f, err := os.Open(p)
+	assert.NoError(t, err)
+	defer f.Close()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(f)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		assert.NoError(t, err)
+
+		content, err := io.ReadAll(tr)
+		assert.NoError(t, err)
+		files[h.Name] = content
+	}
+}
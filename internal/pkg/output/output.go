@@ -0,0 +1,106 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+)
+
+const (
+	// FormatJSON prints results as indented JSON.
+	FormatJSON = "json"
+
+	// FormatYAML prints results as YAML.
+	FormatYAML = "yaml"
+
+	// FormatWide prints results as a table with all the columns.
+	FormatWide = "wide"
+
+	// templatePrefix prefixes a Go template given as output format, e.g. 'go-template={{.Name}}'.
+	templatePrefix = "go-template="
+)
+
+// Table is implemented by results that can be printed as a table.
+type Table interface {
+	// Header returns the column names.
+	Header() []string
+
+	// Rows returns the table rows, each having as many cells as the header.
+	Rows() [][]string
+}
+
+// Format defines how results are printed.
+type Format struct {
+	name     string
+	template *template.Template
+}
+
+// ParseFormat parses an output format given by user. An empty string gives the default
+// format, with which commands print their human friendly text.
+func ParseFormat(s string) (*Format, error) {
+	switch {
+	case s == "", s == FormatJSON, s == FormatYAML, s == FormatWide:
+		return &Format{name: s}, nil
+	case strings.HasPrefix(s, templatePrefix):
+		tmpl, err := template.New("output").Parse(strings.TrimPrefix(s, templatePrefix))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid go-template")
+		}
+		return &Format{name: templatePrefix, template: tmpl}, nil
+	default:
+		return nil, errors.Errorf(
+			"unknown output format %q, supported formats are json, yaml, wide and go-template=<template>", s)
+	}
+}
+
+// IsDefault tells if the default format is used.
+func (f *Format) IsDefault() bool {
+	return f == nil || len(f.name) == 0
+}
+
+// Print prints the result to the writer.
+func (f *Format) Print(w io.Writer, v interface{}) error {
+	if f.IsDefault() {
+		return errors.New("default format must be printed by the command")
+	}
+
+	switch f.name {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case FormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+		return enc.Close()
+	case FormatWide:
+		t, ok := v.(Table)
+		if !ok {
+			return errors.New("wide format is not supported by this command")
+		}
+		return PrintTable(w, t)
+	default:
+		if err := f.template.Execute(w, v); err != nil {
+			return errors.Wrap(err, "unable to execute go-template")
+		}
+		return nil
+	}
+}
+
+// PrintTable prints a table with aligned columns, as the wide format does.
+func PrintTable(w io.Writer, t Table) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 3, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(t.Header(), "\t"))
+	for _, row := range t.Rows() {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,107 @@
+package output
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// fruits is a result used for testing.
+type fruits struct {
+	Fruits []fruit `json:"fruits" yaml:"fruits"`
+}
+
+type fruit struct {
+	Name  string `json:"name" yaml:"name"`
+	Color string `json:"color" yaml:"color"`
+}
+
+func (f fruits) Header() []string {
+	return []string{"NAME", "COLOR"}
+}
+
+func (f fruits) Rows() [][]string {
+	var rows [][]string
+	for _, v := range f.Fruits {
+		rows = append(rows, []string{v.Name, v.Color})
+	}
+	return rows
+}
+
+func TestFormat_Print(t *testing.T) {
+	result := fruits{Fruits: []fruit{{Name: "apple", Color: "red"}, {Name: "banana", Color: "yellow"}}}
+
+	cases := []struct {
+		name     string
+		format   string
+		expected string
+	}{
+		{
+			name:   "json",
+			format: "json",
+			expected: `{
+  "fruits": [
+    {
+      "name": "apple",
+      "color": "red"
+    },
+    {
+      "name": "banana",
+      "color": "yellow"
+    }
+  ]
+}
+`,
+		},
+		{
+			name:   "yaml",
+			format: "yaml",
+			expected: `fruits:
+  - name: apple
+    color: red
+  - name: banana
+    color: yellow
+`,
+		},
+		{
+			name:   "wide",
+			format: "wide",
+			expected: `NAME     COLOR
+apple    red
+banana   yellow
+`,
+		},
+		{
+			name:     "go-template",
+			format:   `go-template={{range .Fruits}}{{.Name}} {{end}}`,
+			expected: "apple banana ",
+		},
+	}
+
+	for _, c := range cases {
+		f, err := ParseFormat(c.format)
+		assert.NoError(t, err, c.name)
+		assert.False(t, f.IsDefault(), c.name)
+
+		buf := new(bytes.Buffer)
+		assert.NoError(t, f.Print(buf, result), c.name)
+		assert.Equal(t, c.expected, buf.String(), c.name)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	f, err := ParseFormat("")
+	assert.NoError(t, err)
+	assert.True(t, f.IsDefault())
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+
+	_, err = ParseFormat("go-template={{.Name")
+	assert.Error(t, err)
+
+	// Wide format needs a table.
+	f, err = ParseFormat("wide")
+	assert.NoError(t, err)
+	assert.Error(t, f.Print(new(bytes.Buffer), "not a table"))
+}
@@ -0,0 +1,337 @@
+package registry
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// apiPath is the root of the registry API.
+	apiPath = "v2"
+
+	// headerContentDigest is the header carrying the digest of a manifest or blob.
+	headerContentDigest = "Docker-Content-Digest"
+
+	// defaultPageSize is the number of entries requested per page from list APIs.
+	defaultPageSize = 100
+)
+
+// Client is a typed client of the Docker registry HTTP API V2, a.k.a. the OCI distribution API.
+type Client struct {
+	rest *rest.Client
+}
+
+// NewClient returns a registry client. The API path of the given config is ignored,
+// the client always talks to the /v2/ API root of the host.
+func NewClient(cfg *rest.ClientConfig) (*Client, error) {
+	c := *cfg
+	c.APIPath = apiPath
+
+	client, err := rest.NewClient(&c)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{rest: client}, nil
+}
+
+// Ping checks the registry supports the API and, if it asks for authentication, accepts the
+// credentials of the client.
+func (c *Client) Ping() error {
+	resp, err := c.rest.Verb("GET").Path("").Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return rest.CheckResponse(resp)
+}
+
+// Catalog returns the repositories in the registry. At most limit repositories are
+// returned, 0 means no limit.
+func (c *Client) Catalog(limit int) ([]string, error) {
+	return c.list(c.rest.Verb("GET").Path("_catalog"), limit, func(resp *http.Response) ([]string, error) {
+		var page catalog
+		err := rest.DecodeResponseInto(resp, &page)
+		return page.Repositories, err
+	})
+}
+
+// Tags returns the tags of a repository. At most limit tags are returned, 0 means no limit.
+func (c *Client) Tags(name string, limit int) ([]string, error) {
+	return c.list(c.rest.Verb("GET").Path(name, "tags", "list"), limit, func(resp *http.Response) ([]string, error) {
+		var page tagList
+		err := rest.DecodeResponseInto(resp, &page)
+		return page.Tags, err
+	})
+}
+
+// list walks through all the pages of a list API and collects the entries of each page.
+func (c *Client) list(r *rest.Request, limit int, decode func(*http.Response) ([]string, error)) ([]string, error) {
+	pageSize := defaultPageSize
+	if limit > 0 && limit < pageSize {
+		pageSize = limit
+	}
+
+	pager := rest.NewPager(r.Selectors(map[string]string{"n": strconv.Itoa(pageSize)}))
+	defer pager.Close()
+
+	entries := []string{}
+	for pager.Next() {
+		page, err := decode(pager.Response())
+		if err != nil {
+			return nil, err
+		}
+
+		for _, entry := range page {
+			entries = append(entries, entry)
+			if limit > 0 && len(entries) == limit {
+				return entries, nil
+			}
+		}
+	}
+
+	return entries, pager.Err()
+}
+
+// RawManifest is a manifest as stored in the registry. The body is kept byte to byte,
+// since the digest of a manifest is computed over its exact content.
+type RawManifest struct {
+	MediaType string
+	Digest    string
+	Body      []byte
+}
+
+// IsIndex tells if the manifest is a multi-platform index.
+func (m *RawManifest) IsIndex() bool {
+	return IsIndexMediaType(m.MediaType)
+}
+
+// Manifest decodes an image manifest.
+func (m *RawManifest) Manifest() (*Manifest, error) {
+	if m.IsIndex() {
+		return nil, errors.Errorf("manifest %s is an index of type %s", m.Digest, m.MediaType)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(m.Body, &manifest); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode manifest %s", m.Digest)
+	}
+	return &manifest, nil
+}
+
+// Index decodes a multi-platform index.
+func (m *RawManifest) Index() (*Index, error) {
+	if !m.IsIndex() {
+		return nil, errors.Errorf("manifest %s of type %s is not an index", m.Digest, m.MediaType)
+	}
+
+	var index Index
+	if err := json.Unmarshal(m.Body, &index); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode index %s", m.Digest)
+	}
+	return &index, nil
+}
+
+// Descriptor returns the descriptor pointing to the manifest.
+func (m *RawManifest) Descriptor() Descriptor {
+	return Descriptor{
+		MediaType: m.MediaType,
+		Digest:    m.Digest,
+		Size:      int64(len(m.Body)),
+	}
+}
+
+// GetManifest fetches a manifest by tag or digest. If no media type is given, all the
+// manifest media types known by regi are accepted.
+func (c *Client) GetManifest(name, reference string, accept ...string) (*RawManifest, error) {
+	resp, err := c.manifestRequest("GET", name, reference, accept).Do()
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read manifest %s:%s", name, reference)
+	}
+
+	digest := resp.Header.Get(headerContentDigest)
+	if len(digest) == 0 {
+		digest = Digest(body)
+	}
+
+	return &RawManifest{
+		MediaType: manifestMediaType(resp.Header.Get("Content-Type"), body),
+		Digest:    digest,
+		Body:      body,
+	}, nil
+}
+
+// HeadManifest checks a manifest by tag or digest and returns its descriptor without
+// fetching the content. If no media type is given, all the manifest media types known by
+// regi are accepted.
+func (c *Client) HeadManifest(name, reference string, accept ...string) (*Descriptor, error) {
+	resp, err := c.manifestRequest("HEAD", name, reference, accept).Do()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
+	digest := resp.Header.Get(headerContentDigest)
+	if len(digest) == 0 && strings.HasPrefix(reference, "sha256:") {
+		digest = reference
+	}
+	if len(digest) == 0 {
+		return nil, errors.Errorf("registry did not tell the digest of %s:%s", name, reference)
+	}
+
+	return &Descriptor{
+		MediaType: resp.Header.Get("Content-Type"),
+		Digest:    digest,
+		Size:      resp.ContentLength,
+	}, nil
+}
+
+// DeleteManifest deletes a manifest by digest, together with all the tags pointing to it.
+func (c *Client) DeleteManifest(name, digest string) error {
+	resp, err := c.rest.Verb("DELETE").Path(name, "manifests", digest).Do()
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusAccepted {
+		return errors.Errorf("unable to delete manifest %s@%s, server responded %s", name, digest, resp.Status)
+	}
+	return nil
+}
+
+// GetBlob fetches a blob by digest. The caller must close the returned reader.
+func (c *Client) GetBlob(name, digest string) (io.ReadCloser, int64, error) {
+	resp, err := c.rest.Verb("GET").Path(name, "blobs", digest).Do()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := rest.CheckResponse(resp); err != nil {
+		resp.Body.Close()
+		return nil, 0, err
+	}
+
+	return resp.Body, resp.ContentLength, nil
+}
+
+// ReadBlob fetches a small blob, such as an image config, and verifies its digest.
+func (c *Client) ReadBlob(name, digest string) ([]byte, error) {
+	blob, _, err := c.GetBlob(name, digest)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	content, err := io.ReadAll(blob)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read blob %s", digest)
+	}
+
+	if actual := Digest(content); actual != digest {
+		return nil, errors.Errorf("blob %s is corrupted, digest of the content is %s", digest, actual)
+	}
+	return content, nil
+}
+
+// GetImageConfig fetches and decodes the config blob of an image manifest.
+func (c *Client) GetImageConfig(name string, manifest *Manifest) (*ImageConfig, error) {
+	content, err := c.ReadBlob(name, manifest.Config.Digest)
+	if err != nil {
+		return nil, err
+	}
+
+	var config ImageConfig
+	if err := json.Unmarshal(content, &config); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode image config %s", manifest.Config.Digest)
+	}
+	return &config, nil
+}
+
+// Platforms returns the platforms supported by a manifest of the repository. The platforms of
+// an index are read from its entries, the platform of an image manifest from its config.
+func (c *Client) Platforms(name string, m *RawManifest) ([]Platform, error) {
+	return Platforms(c.Repository(name), m)
+}
+
+// ResolvePlatform returns the image manifest of the repository for the wanted platform. An
+// index is resolved to the manifest it holds for the platform, an image manifest is returned
+// as it is if it runs on the platform.
+func (c *Client) ResolvePlatform(name string, m *RawManifest, wanted Platform) (*RawManifest, error) {
+	return ResolvePlatform(c.Repository(name), m, wanted)
+}
+
+// manifestRequest makes a request to the manifest API.
+func (c *Client) manifestRequest(verb, name, reference string, accept []string) *rest.Request {
+	if len(accept) == 0 {
+		accept = ManifestMediaTypes
+	}
+
+	return c.rest.Verb(verb).
+		Path(name, "manifests", reference).
+		SetHeader("Accept", strings.Join(accept, ", "))
+}
+
+// DetectManifestMediaType tells the media type of a manifest from its content, e.g. for a
+// manifest read from disk.
+func DetectManifestMediaType(body []byte) string {
+	return manifestMediaType("", body)
+}
+
+// manifestMediaType tells the media type of a manifest from the Content-Type header, falling
+// back on the content itself when the registry serves a generic type.
+func manifestMediaType(contentType string, body []byte) string {
+	for _, t := range ManifestMediaTypes {
+		if strings.HasPrefix(contentType, t) {
+			return t
+		}
+	}
+
+	var probe struct {
+		MediaType string            `json:"mediaType"`
+		Manifests []json.RawMessage `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &probe); err == nil {
+		if len(probe.MediaType) > 0 {
+			return probe.MediaType
+		}
+		if probe.Manifests != nil {
+			return MediaTypeOCIIndex
+		}
+	}
+
+	return MediaTypeOCIManifest
+}
+
+// Digest computes the sha256 digest of the content.
+func Digest(content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+}
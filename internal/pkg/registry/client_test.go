@@ -0,0 +1,199 @@
+package registry
+
+import (
+	"fmt"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"testing"
+)
+
+func TestClient_Ping(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.Username, server.Password = "regi", "s3cret"
+
+	client, err := NewClient(&rest.ClientConfig{Host: server.URL, Username: "regi", Password: "s3cret"})
+	assert.NoError(t, err)
+	assert.NoError(t, client.Ping())
+
+	client, err = NewClient(&rest.ClientConfig{Host: server.URL, Username: "regi", Password: "wrong"})
+	assert.NoError(t, err)
+	err = client.Ping()
+	assert.True(t, rest.HasStatus(err, http.StatusUnauthorized), "%v", err)
+
+	client, err = NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+	err = client.Ping()
+	assert.True(t, rest.HasErrorCode(err, rest.ErrorCodeUnauthorized), "%v", err)
+}
+
+func TestClient_List(t *testing.T) {
+	server := registrytest.NewServer(t)
+	for i := 0; i < 120; i++ {
+		server.AddImage("library/alpine", fmt.Sprintf("3.%03d", i), []byte(`{}`))
+	}
+	server.AddImage("library/busybox", "latest", []byte(`{}`))
+
+	client, err := NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+
+	repos, err := client.Catalog(0)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"library/alpine", "library/busybox"}, repos)
+
+	tags, err := client.Tags("library/alpine", 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 120, len(tags))
+	assert.Equal(t, "3.119", tags[119])
+
+	tags, err = client.Tags("library/alpine", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3.000", "3.001", "3.002", "3.003", "3.004"}, tags)
+
+	_, err = client.Tags("library/unknown", 0)
+	assert.Error(t, err)
+}
+
+func TestClient_Manifest(t *testing.T) {
+	server := registrytest.NewServer(t)
+	config := []byte(`{"architecture":"amd64","os":"linux","config":{"Cmd":["/hello"],"Env":["PATH=/bin"]}}`)
+	digest := server.AddImage("hello-world", "latest", config, []byte("layer-1"), []byte("layer-2"))
+
+	client, err := NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+
+	// Get.
+	raw, err := client.GetManifest("hello-world", "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, digest, raw.Digest)
+	assert.Equal(t, MediaTypeDockerManifest, raw.MediaType)
+	assert.Equal(t, digest, Digest(raw.Body))
+	assert.False(t, raw.IsIndex())
+
+	manifest, err := raw.Manifest()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, len(manifest.Layers))
+	assert.Equal(t, int64(len("layer-1")), manifest.Layers[0].Size)
+
+	_, err = raw.Index()
+	assert.Error(t, err)
+
+	// Config and blobs.
+	imageConfig, err := client.GetImageConfig("hello-world", manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, "linux", imageConfig.OS)
+	assert.Equal(t, []string{"/hello"}, imageConfig.Config.Cmd)
+	assert.Equal(t, "linux/amd64", imageConfig.Platform().String())
+
+	content, err := client.ReadBlob("hello-world", manifest.Config.Digest)
+	assert.NoError(t, err)
+	assert.Equal(t, config, content)
+
+	blob, size, err := client.GetBlob("hello-world", manifest.Layers[1].Digest)
+	assert.NoError(t, err)
+	content, err = io.ReadAll(blob)
+	blob.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "layer-2", string(content))
+	assert.Equal(t, int64(7), size)
+
+	// Head.
+	desc, err := client.HeadManifest("hello-world", "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, digest, desc.Digest)
+	assert.Equal(t, int64(len(raw.Body)), desc.Size)
+
+	// Delete.
+	assert.NoError(t, client.DeleteManifest("hello-world", digest))
+	_, err = client.HeadManifest("hello-world", "latest")
+	assert.Error(t, err)
+	assert.Error(t, client.DeleteManifest("hello-world", digest))
+}
+
+func TestClient_Platforms(t *testing.T) {
+	server := registrytest.NewServer(t)
+	server.AddIndex("hello-world", "latest", "linux/amd64", "linux/arm64/v8")
+	server.AddImage("hello-world", "amd64", []byte(`{"architecture":"amd64","os":"linux"}`))
+
+	client, err := NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+
+	// Index.
+	raw, err := client.GetManifest("hello-world", "latest")
+	assert.NoError(t, err)
+	assert.True(t, raw.IsIndex())
+
+	platforms, err := client.Platforms("hello-world", raw)
+	assert.NoError(t, err)
+	assert.Equal(t, []Platform{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "arm64", Variant: "v8"},
+	}, platforms)
+
+	arm64, err := client.ResolvePlatform("hello-world", raw, Platform{OS: "linux", Architecture: "arm64"})
+	assert.NoError(t, err)
+	assert.Equal(t, MediaTypeDockerManifest, arm64.MediaType)
+	manifest, err := arm64.Manifest()
+	assert.NoError(t, err)
+	config, err := client.GetImageConfig("hello-world", manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, "arm64", config.Architecture)
+
+	_, err = client.ResolvePlatform("hello-world", raw, Platform{OS: "linux", Architecture: "s390x"})
+	assert.Error(t, err)
+
+	// Image manifest.
+	raw, err = client.GetManifest("hello-world", "amd64")
+	assert.NoError(t, err)
+
+	platforms, err = client.Platforms("hello-world", raw)
+	assert.NoError(t, err)
+	assert.Equal(t, []Platform{{OS: "linux", Architecture: "amd64"}}, platforms)
+
+	resolved, err := client.ResolvePlatform("hello-world", raw, Platform{OS: "linux", Architecture: "amd64"})
+	assert.NoError(t, err)
+	assert.Equal(t, raw, resolved)
+
+	_, err = client.ResolvePlatform("hello-world", raw, Platform{OS: "linux", Architecture: "arm64"})
+	assert.EqualError(t, err, "platform linux/arm64 not found, the image is for linux/amd64")
+}
+
+func TestManifestMediaType(t *testing.T) {
+	cases := []struct {
+		name        string
+		contentType string
+		body        string
+		expected    string
+	}{
+		{
+			name:        "content type",
+			contentType: MediaTypeDockerManifestList,
+			body:        `{}`,
+			expected:    MediaTypeDockerManifestList,
+		},
+		{
+			name:        "media type in body",
+			contentType: "application/json",
+			body:        `{"mediaType":"application/vnd.oci.image.index.v1+json"}`,
+			expected:    MediaTypeOCIIndex,
+		},
+		{
+			name:        "index without media type",
+			contentType: "",
+			body:        `{"schemaVersion":2,"manifests":[]}`,
+			expected:    MediaTypeOCIIndex,
+		},
+		{
+			name:        "manifest without media type",
+			contentType: "",
+			body:        `{"schemaVersion":2,"layers":[]}`,
+			expected:    MediaTypeOCIManifest,
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, manifestMediaType(c.contentType, []byte(c.body)), c.name)
+	}
+}
@@ -0,0 +1,184 @@
+package registry
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	"io"
+)
+
+// Source provides the content of images, e.g. a repository or an image layout on disk.
+type Source interface {
+	// Manifest returns a manifest by digest, e.g. a platform manifest of an index.
+	Manifest(digest string) (*RawManifest, error)
+
+	// Blob opens a blob. The caller must close the returned reader.
+	Blob(desc Descriptor) (io.ReadCloser, error)
+}
+
+// Target stores the content of images.
+type Target interface {
+	// HasBlob tells if the target holds the blob already.
+	HasBlob(desc Descriptor) (bool, error)
+
+	// PutBlob stores a blob.
+	PutBlob(desc Descriptor, content io.Reader) error
+
+	// PutManifest stores a manifest, under the tag if it is not empty. The content the
+	// manifest refers to is stored first.
+	PutManifest(m *RawManifest, tag string) error
+}
+
+// Mounter is implemented by targets which can get a blob without its content being copied,
+// e.g. by mounting it from another repository of the same registry.
+type Mounter interface {
+	// MountBlob tells if the blob could be mounted.
+	MountBlob(desc Descriptor) (bool, error)
+}
+
+// BlobStatus tells how a blob has been copied.
+type BlobStatus int
+
+const (
+	// BlobCopied means the content of the blob has been copied.
+	BlobCopied BlobStatus = iota
+
+	// BlobExists means the target holds the blob already.
+	BlobExists
+
+	// BlobMounted means the blob has been mounted from another repository.
+	BlobMounted
+)
+
+// Progress is told about each blob of a copy.
+type Progress func(desc Descriptor, status BlobStatus)
+
+// Copy copies an image, or an index with all its platform manifests, from the source to the
+// target and tags it. Blobs the target holds already are skipped, and blobs are mounted rather
+// than copied if the target can. The manifests are copied
+// byte to byte, so their digests are preserved.
+func Copy(src Source, dst Target, m *RawManifest, tag string, progress Progress) error {
+	if progress == nil {
+		progress = func(Descriptor, BlobStatus) {}
+	}
+
+	if m.IsIndex() {
+		index, err := m.Index()
+		if err != nil {
+			return err
+		}
+
+		for _, desc := range index.Manifests {
+			child, err := src.Manifest(desc.Digest)
+			if err != nil {
+				return err
+			}
+			if err := Copy(src, dst, child, "", progress); err != nil {
+				return err
+			}
+		}
+		return dst.PutManifest(m, tag)
+	}
+
+	manifest, err := m.Manifest()
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range append([]Descriptor{manifest.Config}, manifest.Layers...) {
+		if err := copyBlob(src, dst, desc, progress); err != nil {
+			return err
+		}
+	}
+	return dst.PutManifest(m, tag)
+}
+
+// copyBlob copies a blob unless the target holds it already.
+func copyBlob(src Source, dst Target, desc Descriptor, progress Progress) error {
+	exists, err := dst.HasBlob(desc)
+	if err != nil {
+		return err
+	}
+	if exists {
+		progress(desc, BlobExists)
+		return nil
+	}
+
+	if mounter, ok := dst.(Mounter); ok {
+		mounted, err := mounter.MountBlob(desc)
+		if err != nil {
+			return err
+		}
+		if mounted {
+			progress(desc, BlobMounted)
+			return nil
+		}
+	}
+
+	content, err := src.Blob(desc)
+	if err != nil {
+		return err
+	}
+	defer content.Close()
+
+	if err := dst.PutBlob(desc, content); err != nil {
+		return err
+	}
+	progress(desc, BlobCopied)
+	return nil
+}
+
+// Platforms returns the platforms supported by a manifest of the source. The platforms of an
+// index are read from its entries, the platform of an image manifest from its config.
+func Platforms(src Source, m *RawManifest) ([]Platform, error) {
+	if m.IsIndex() {
+		index, err := m.Index()
+		if err != nil {
+			return nil, err
+		}
+		return index.Platforms(), nil
+	}
+
+	manifest, err := m.Manifest()
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := src.Blob(manifest.Config)
+	if err != nil {
+		return nil, err
+	}
+	defer blob.Close()
+
+	var config ImageConfig
+	if err := json.NewDecoder(blob).Decode(&config); err != nil {
+		return nil, errors.Wrapf(err, "unable to decode image config %s", manifest.Config.Digest)
+	}
+	return []Platform{config.Platform()}, nil
+}
+
+// ResolvePlatform returns the image manifest of the source for the wanted platform. An index is
+// resolved to the manifest it holds for the platform, an image manifest is returned as it is
+// if it runs on the platform.
+func ResolvePlatform(src Source, m *RawManifest, wanted Platform) (*RawManifest, error) {
+	if !m.IsIndex() {
+		platforms, err := Platforms(src, m)
+		if err != nil {
+			return nil, err
+		}
+		if !platforms[0].Matches(wanted) {
+			return nil, errors.Errorf("platform %s not found, the image is for %s", wanted, platforms[0])
+		}
+		return m, nil
+	}
+
+	index, err := m.Index()
+	if err != nil {
+		return nil, err
+	}
+
+	desc, err := index.Find(wanted)
+	if err != nil {
+		return nil, err
+	}
+	return src.Manifest(desc.Digest)
+}
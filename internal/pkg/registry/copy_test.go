@@ -0,0 +1,94 @@
+package registry
+
+import (
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestCopy(t *testing.T) {
+	src := registrytest.NewServer(t)
+	digest := src.AddIndex("hello-world", "latest", "linux/amd64", "linux/arm64")
+	dst := registrytest.NewServer(t)
+
+	srcClient, err := NewClient(&rest.ClientConfig{Host: src.URL})
+	assert.NoError(t, err)
+	dstClient, err := NewClient(&rest.ClientConfig{Host: dst.URL})
+	assert.NoError(t, err)
+
+	m, err := srcClient.GetManifest("hello-world", "latest")
+	assert.NoError(t, err)
+
+	copied := map[string]BlobStatus{}
+	progress := func(desc Descriptor, status BlobStatus) {
+		copied[desc.Digest] = status
+	}
+
+	// All the blobs of all the platforms are copied, and the digest is preserved.
+	assert.NoError(t, Copy(srcClient.Repository("hello-world"), dstClient.Repository("hello"), m, "v1", progress))
+	assert.Equal(t, map[string]string{"v1": digest}, dst.Tags("hello"))
+	assert.Equal(t, 4, len(copied))
+	for _, status := range copied {
+		assert.Equal(t, BlobCopied, status)
+	}
+
+	// Blobs are not copied twice.
+	assert.NoError(t, Copy(srcClient.Repository("hello-world"), dstClient.Repository("hello"), m, "v2", progress))
+	assert.Equal(t, map[string]string{"v1": digest, "v2": digest}, dst.Tags("hello"))
+	for _, status := range copied {
+		assert.Equal(t, BlobExists, status)
+	}
+
+	// The manifest of a platform.
+	arm64, err := srcClient.ResolvePlatform("hello-world", m, Platform{OS: "linux", Architecture: "arm64"})
+	assert.NoError(t, err)
+	assert.NoError(t, Copy(srcClient.Repository("hello-world"), dstClient.Repository("arm64"), arm64, "latest", nil))
+	assert.Equal(t, map[string]string{"latest": arm64.Digest}, dst.Tags("arm64"))
+}
+
+func TestCopy_Mount(t *testing.T) {
+	s := registrytest.NewServer(t)
+	digest := s.AddImage("staging/app", "v1", []byte(`{"os":"linux","architecture":"amd64"}`), []byte("layer"))
+	s.AddImage("other", "v1", []byte(`{"os":"linux","architecture":"arm64"}`), []byte("other layer"))
+
+	client, err := NewClient(&rest.ClientConfig{Host: s.URL})
+	assert.NoError(t, err)
+
+	m, err := client.GetManifest("staging/app", "v1")
+	assert.NoError(t, err)
+
+	statuses := map[BlobStatus]int{}
+	progress := func(desc Descriptor, status BlobStatus) {
+		statuses[status]++
+	}
+
+	// Blobs of the same registry are mounted rather than uploaded.
+	dst := client.Repository("prod/app").MountFrom("staging/app")
+	assert.NoError(t, Copy(client.Repository("staging/app"), dst, m, "v1", progress))
+	assert.Equal(t, map[string]string{"v1": digest}, s.Tags("prod/app"))
+	assert.Equal(t, map[BlobStatus]int{BlobMounted: 2}, statuses)
+	assert.Equal(t, 2, s.Mounted())
+	assert.Equal(t, 0, s.Uploaded())
+
+	// Blobs which can not be mounted are uploaded in the session started by the mount.
+	m, err = client.GetManifest("other", "v1")
+	assert.NoError(t, err)
+	statuses = map[BlobStatus]int{}
+	dst = client.Repository("prod/other").MountFrom("staging/app")
+	assert.NoError(t, Copy(client.Repository("other"), dst, m, "v1", progress))
+	assert.Equal(t, map[BlobStatus]int{BlobCopied: 2}, statuses)
+	assert.Equal(t, 2, s.Uploaded())
+
+	// Blobs are pushed when the registry refuses to mount them.
+	s.MountDenied = true
+	m, err = client.GetManifest("staging/app", "v1")
+	assert.NoError(t, err)
+	statuses = map[BlobStatus]int{}
+	dst = client.Repository("denied/app").MountFrom("staging/app")
+	assert.NoError(t, Copy(client.Repository("staging/app"), dst, m, "v1", progress))
+	assert.Equal(t, map[string]string{"v1": digest}, s.Tags("denied/app"))
+	assert.Equal(t, map[BlobStatus]int{BlobCopied: 2}, statuses)
+	assert.Equal(t, 2, s.Mounted())
+	assert.Equal(t, 4, s.Uploaded())
+}
@@ -0,0 +1,62 @@
+package registry
+
+import (
+	"github.com/pkg/errors"
+	"strings"
+)
+
+// ParsePlatform parses a platform given as 'os/architecture[/variant]', e.g. 'linux/arm64'.
+func ParsePlatform(s string) (*Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return nil, errors.Errorf("invalid platform %q, expected os/architecture[/variant], e.g. linux/arm64", s)
+	}
+
+	for _, part := range parts {
+		if len(part) == 0 {
+			return nil, errors.Errorf("invalid platform %q, expected os/architecture[/variant], e.g. linux/arm64", s)
+		}
+	}
+
+	p := &Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+// Matches tells if the platform satisfies the wanted one. A wanted platform without variant
+// matches any variant.
+func (p Platform) Matches(wanted Platform) bool {
+	return p.OS == wanted.OS &&
+		p.Architecture == wanted.Architecture &&
+		(len(wanted.Variant) == 0 || p.Variant == wanted.Variant)
+}
+
+// Find returns the descriptor of the manifest for the wanted platform.
+func (i *Index) Find(wanted Platform) (*Descriptor, error) {
+	var available []string
+	for n, m := range i.Manifests {
+		if m.Platform == nil {
+			continue
+		}
+		if m.Platform.Matches(wanted) {
+			return &i.Manifests[n], nil
+		}
+		available = append(available, m.Platform.String())
+	}
+
+	return nil, errors.Errorf("platform %s not found, available platforms are: %s",
+		wanted, strings.Join(available, ", "))
+}
+
+// Platforms returns the platforms of the manifests in the index.
+func (i *Index) Platforms() []Platform {
+	var platforms []Platform
+	for _, m := range i.Manifests {
+		if m.Platform != nil {
+			platforms = append(platforms, *m.Platform)
+		}
+	}
+	return platforms
+}
@@ -0,0 +1,44 @@
+package registry
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	p, err := ParsePlatform("linux/arm64/v8")
+	assert.NoError(t, err)
+	assert.Equal(t, &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}, p)
+	assert.Equal(t, "linux/arm64/v8", p.String())
+
+	p, err = ParsePlatform("linux/amd64")
+	assert.NoError(t, err)
+	assert.Equal(t, &Platform{OS: "linux", Architecture: "amd64"}, p)
+
+	for _, s := range []string{"", "linux", "linux/", "linux/arm/v7/extra"} {
+		_, err = ParsePlatform(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestIndex_Find(t *testing.T) {
+	index := &Index{Manifests: []Descriptor{
+		{Digest: "sha256:amd64", Platform: &Platform{OS: "linux", Architecture: "amd64"}},
+		{Digest: "sha256:attestation"},
+		{Digest: "sha256:armv7", Platform: &Platform{OS: "linux", Architecture: "arm", Variant: "v7"}},
+		{Digest: "sha256:arm64", Platform: &Platform{OS: "linux", Architecture: "arm64", Variant: "v8"}},
+	}}
+
+	d, err := index.Find(Platform{OS: "linux", Architecture: "arm64"})
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:arm64", d.Digest)
+
+	d, err = index.Find(Platform{OS: "linux", Architecture: "arm", Variant: "v7"})
+	assert.NoError(t, err)
+	assert.Equal(t, "sha256:armv7", d.Digest)
+
+	_, err = index.Find(Platform{OS: "windows", Architecture: "amd64"})
+	assert.EqualError(t, err, "platform windows/amd64 not found, available platforms are: linux/amd64, linux/arm/v7, linux/arm64/v8")
+
+	assert.Equal(t, 3, len(index.Platforms()))
+}
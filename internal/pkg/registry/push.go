@@ -0,0 +1,140 @@
+package registry
+
+import (
+	"bytes"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+)
+
+// BlobExists tells if the repository holds the blob.
+func (c *Client) BlobExists(name, digest string) (bool, error) {
+	resp, err := c.rest.Verb("HEAD").Path(name, "blobs", digest).Do()
+	if err != nil {
+		return false, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if err := rest.CheckResponse(resp); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// PushBlob uploads a blob to the repository in a single request. The content must be exactly
+// the one described by the descriptor, or the registry rejects it.
+func (c *Client) PushBlob(name string, desc Descriptor, content io.Reader) error {
+	location, err := c.startUpload(name)
+	if err != nil {
+		return err
+	}
+	return c.UploadBlob(location, desc, content)
+}
+
+// MountBlob mounts a blob from another repository of the registry, so that the content is not
+// uploaded again. If the registry can not mount the blob, it starts an upload session instead,
+// whose location is returned for UploadBlob. If the registry refuses the mount, e.g. as the user
+// can not read the other repository, neither is returned and the blob is to be pushed.
+func (c *Client) MountBlob(name, digest, from string) (bool, string, error) {
+	resp, err := c.rest.Verb("POST").
+		Path(name, "blobs", "uploads", "").
+		Selectors(map[string]string{"mount": digest, "from": from}).
+		Do()
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		var e *rest.RegistryError
+		if errors.As(err, &e) && e.StatusCode >= 400 && e.StatusCode < 500 {
+			return false, "", nil
+		}
+		return false, "", err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return true, "", nil
+	case http.StatusAccepted:
+		if location := resp.Header.Get("Location"); len(location) > 0 {
+			return false, location, nil
+		}
+	}
+	return false, "", errors.Errorf("unable to mount blob %s from %s to %s, server responded %s",
+		digest, from, name, resp.Status)
+}
+
+// UploadBlob uploads the content of a blob to the location of an upload session, in a single request.
+func (c *Client) UploadBlob(location string, desc Descriptor, content io.Reader) error {
+	resp, err := c.rest.Verb("PUT").
+		Location(location).
+		Selectors(map[string]string{"digest": desc.Digest}).
+		SetHeader("Content-Type", "application/octet-stream").
+		RawBody(content, desc.Size).
+		Do()
+	if err != nil {
+		return errors.Wrapf(err, "unable to upload blob %s", desc.Digest)
+	}
+	defer resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return errors.Errorf("unable to upload blob %s, server responded %s", desc.Digest, resp.Status)
+	}
+	return nil
+}
+
+// startUpload starts a blob upload session and returns the location to upload the content to.
+func (c *Client) startUpload(name string) (string, error) {
+	resp, err := c.rest.Verb("POST").Path(name, "blobs", "uploads", "").Do()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		return "", err
+	}
+
+	location := resp.Header.Get("Location")
+	if resp.StatusCode != http.StatusAccepted || len(location) == 0 {
+		return "", errors.Errorf("unable to start blob upload to %s, server responded %s", name, resp.Status)
+	}
+	return location, nil
+}
+
+// PutManifest uploads a manifest under a tag or a digest, and returns the digest of the manifest.
+// The blobs and the platform manifests it refers to must be in the repository already.
+func (c *Client) PutManifest(name, reference string, m *RawManifest) (string, error) {
+	resp, err := c.rest.Verb("PUT").
+		Path(name, "manifests", reference).
+		SetHeader("Content-Type", m.MediaType).
+		RawBody(bytes.NewReader(m.Body), int64(len(m.Body))).
+		Do()
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if err := rest.CheckResponse(resp); err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusCreated {
+		return "", errors.Errorf("unable to upload manifest %s:%s, server responded %s", name, reference, resp.Status)
+	}
+
+	digest := resp.Header.Get(headerContentDigest)
+	if len(digest) == 0 {
+		digest = Digest(m.Body)
+	}
+	return digest, nil
+}
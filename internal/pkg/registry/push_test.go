@@ -0,0 +1,65 @@
+package registry
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/iamharvey/regi/internal/pkg/registry/registrytest"
+	"github.com/iamharvey/regi/internal/pkg/rest"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestClient_Push(t *testing.T) {
+	server := registrytest.NewServer(t)
+
+	client, err := NewClient(&rest.ClientConfig{Host: server.URL})
+	assert.NoError(t, err)
+
+	config := []byte(`{"architecture":"amd64","os":"linux"}`)
+	layer := []byte("layer-1")
+	configDesc := Descriptor{MediaType: MediaTypeOCIConfig, Digest: Digest(config), Size: int64(len(config))}
+	layerDesc := Descriptor{MediaType: MediaTypeOCILayer, Digest: Digest(layer), Size: int64(len(layer))}
+
+	// Blobs.
+	ok, err := client.BlobExists("hello-world", layerDesc.Digest)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, client.PushBlob("hello-world", configDesc, bytes.NewReader(config)))
+	assert.NoError(t, client.PushBlob("hello-world", layerDesc, bytes.NewReader(layer)))
+
+	ok, err = client.BlobExists("hello-world", layerDesc.Digest)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	content, ok := server.Blob("hello-world", layerDesc.Digest)
+	assert.True(t, ok)
+	assert.Equal(t, layer, content)
+
+	// A corrupted blob is rejected.
+	err = client.PushBlob("hello-world", layerDesc, bytes.NewReader([]byte("layer-2")))
+	assert.True(t, rest.HasErrorCode(err, rest.ErrorCodeDigestInvalid))
+
+	// Manifest.
+	body, _ := json.Marshal(Manifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        configDesc,
+		Layers:        []Descriptor{layerDesc},
+	})
+	digest, err := client.PutManifest("hello-world", "latest", &RawManifest{MediaType: MediaTypeOCIManifest, Body: body})
+	assert.NoError(t, err)
+	assert.Equal(t, Digest(body), digest)
+
+	raw, err := client.GetManifest("hello-world", "latest")
+	assert.NoError(t, err)
+	assert.Equal(t, MediaTypeOCIManifest, raw.MediaType)
+	assert.Equal(t, body, raw.Body)
+
+	// A manifest referring to missing blobs is rejected.
+	body, _ = json.Marshal(Manifest{SchemaVersion: 2, MediaType: MediaTypeOCIManifest, Config: configDesc})
+	_, err = client.PutManifest("busybox", "latest", &RawManifest{MediaType: MediaTypeOCIManifest, Body: body})
+	assert.True(t, rest.HasErrorCode(err, rest.ErrorCodeManifestBlobUnknown))
+	assert.True(t, rest.HasStatus(err, http.StatusBadRequest))
+}
@@ -0,0 +1,549 @@
+// Package registrytest provides an in-memory registry for testing.
+package registrytest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const (
+	// mediaTypeDockerManifest is the media type of Docker image manifest, schema 2.
+	mediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// mediaTypeOCIIndex is the media type of OCI image index.
+	mediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+)
+
+// manifest is a manifest stored in Server.
+type manifest struct {
+	mediaType string
+	body      []byte
+}
+
+// Server is an in-memory registry implementing the parts of the distribution API used by regi.
+type Server struct {
+	*httptest.Server
+
+	// DeleteDisabled makes the registry refuse deletion, like a registry started
+	// without REGISTRY_STORAGE_DELETE_ENABLED.
+	DeleteDisabled bool
+
+	// Username and Password, if set, are required by basic authentication.
+	Username string
+	Password string
+
+	// MountDenied makes the registry refuse to mount blobs from another repository, like a
+	// registry whose user can not read the other repository.
+	MountDenied bool
+
+	// Fail, if set, makes the registry answer the requests it returns true for with an
+	// internal server error.
+	Fail func(r *http.Request) bool
+
+	mu sync.Mutex
+
+	// tags maps repository to tag to manifest digest.
+	tags map[string]map[string]string
+
+	// manifests maps repository to digest to manifest.
+	manifests map[string]map[string]manifest
+
+	// blobs maps repository to digest to content.
+	blobs map[string]map[string][]byte
+
+	// uploads maps upload session ID to the content received so far.
+	uploads map[string][]byte
+
+	// lastUpload numbers upload sessions.
+	lastUpload int
+
+	// uploaded and mounted count the blobs received by upload and by mount.
+	uploaded int
+	mounted  int
+}
+
+// NewServer starts an in-memory registry which is closed when the test finishes.
+func NewServer(t *testing.T) *Server {
+	s := &Server{
+		tags:      map[string]map[string]string{},
+		manifests: map[string]map[string]manifest{},
+		blobs:     map[string]map[string][]byte{},
+		uploads:   map[string][]byte{},
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// AddManifest stores a manifest in the repository, tagged if tag is not empty, and returns its digest.
+func (s *Server) AddManifest(repo, tag, mediaType string, body []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.putManifest(repo, tag, mediaType, body)
+}
+
+// AddBlob stores a blob in the repository and returns its digest.
+func (s *Server) AddBlob(repo string, content []byte) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	digest := digestOf(content)
+	if s.blobs[repo] == nil {
+		s.blobs[repo] = map[string][]byte{}
+	}
+	s.blobs[repo][digest] = content
+	return digest
+}
+
+// AddImage stores a Docker image made of the config and the layers, and returns the manifest digest.
+func (s *Server) AddImage(repo, tag string, config []byte, layers ...[]byte) string {
+	type descriptor struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+		Size      int    `json:"size"`
+	}
+
+	m := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Config        descriptor   `json:"config"`
+		Layers        []descriptor `json:"layers"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeDockerManifest,
+		Config: descriptor{
+			MediaType: "application/vnd.docker.container.image.v1+json",
+			Digest:    s.AddBlob(repo, config),
+			Size:      len(config),
+		},
+		Layers: []descriptor{},
+	}
+	for _, layer := range layers {
+		m.Layers = append(m.Layers, descriptor{
+			MediaType: "application/vnd.docker.image.rootfs.diff.tar.gzip",
+			Digest:    s.AddBlob(repo, layer),
+			Size:      len(layer),
+		})
+	}
+
+	body, _ := json.Marshal(m)
+	return s.AddManifest(repo, tag, mediaTypeDockerManifest, body)
+}
+
+// AddIndex stores an OCI image index with an image for each of the platforms given as
+// 'os/architecture[/variant]', and returns the index digest.
+func (s *Server) AddIndex(repo, tag string, platforms ...string) string {
+	type platform struct {
+		Architecture string `json:"architecture"`
+		OS           string `json:"os"`
+		Variant      string `json:"variant,omitempty"`
+	}
+
+	type descriptor struct {
+		MediaType string    `json:"mediaType"`
+		Digest    string    `json:"digest"`
+		Size      int       `json:"size"`
+		Platform  *platform `json:"platform,omitempty"`
+	}
+
+	index := struct {
+		SchemaVersion int          `json:"schemaVersion"`
+		MediaType     string       `json:"mediaType"`
+		Manifests     []descriptor `json:"manifests"`
+	}{
+		SchemaVersion: 2,
+		MediaType:     mediaTypeOCIIndex,
+		Manifests:     []descriptor{},
+	}
+	for _, p := range platforms {
+		parts := strings.SplitN(p, "/", 3)
+		pf := &platform{OS: parts[0], Architecture: parts[1]}
+		if len(parts) == 3 {
+			pf.Variant = parts[2]
+		}
+
+		config, _ := json.Marshal(pf)
+		digest := s.AddImage(repo, "", config, []byte("layer-"+p))
+
+		s.mu.Lock()
+		size := len(s.manifests[repo][digest].body)
+		s.mu.Unlock()
+
+		index.Manifests = append(index.Manifests, descriptor{
+			MediaType: mediaTypeDockerManifest,
+			Digest:    digest,
+			Size:      size,
+			Platform:  pf,
+		})
+	}
+
+	body, _ := json.Marshal(index)
+	return s.AddManifest(repo, tag, mediaTypeOCIIndex, body)
+}
+
+// Tags returns the tags of the repository and the digests they point to.
+func (s *Server) Tags(repo string) map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tags := map[string]string{}
+	for tag, digest := range s.tags[repo] {
+		tags[tag] = digest
+	}
+	return tags
+}
+
+// Blob returns the content of a blob in the repository.
+func (s *Server) Blob(repo, digest string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	content, ok := s.blobs[repo][digest]
+	return content, ok
+}
+
+// HasManifest tells if the repository holds the manifest.
+func (s *Server) HasManifest(repo, digest string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.manifests[repo][digest]
+	return ok
+}
+
+// Uploaded returns the number of blobs received by upload.
+func (s *Server) Uploaded() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.uploaded
+}
+
+// Mounted returns the number of blobs mounted from another repository.
+func (s *Server) Mounted() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.mounted
+}
+
+// putManifest stores a manifest. The caller must hold the lock.
+func (s *Server) putManifest(repo, tag, mediaType string, body []byte) string {
+	digest := digestOf(body)
+	if s.manifests[repo] == nil {
+		s.manifests[repo] = map[string]manifest{}
+	}
+	s.manifests[repo][digest] = manifest{mediaType: mediaType, body: body}
+
+	if s.tags[repo] == nil {
+		s.tags[repo] = map[string]string{}
+	}
+	if len(tag) > 0 {
+		s.tags[repo][tag] = digest
+	}
+	return digest
+}
+
+// serve routes a request to the matching API handler.
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.Username) > 0 {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != s.Username || pass != s.Password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Registry Realm"`)
+			writeError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+			return
+		}
+	}
+
+	if s.Fail != nil && s.Fail(r) {
+		writeError(w, http.StatusInternalServerError, "UNKNOWN", "unknown error")
+		return
+	}
+
+	p := strings.TrimPrefix(r.URL.Path, "/v2/")
+	switch {
+	case p == "":
+		w.WriteHeader(http.StatusOK)
+	case p == "_catalog":
+		var repos []string
+		for repo := range s.tags {
+			repos = append(repos, repo)
+		}
+		servePage(w, r, "repositories", repos)
+	case strings.HasSuffix(p, "/tags/list"):
+		repo := strings.TrimSuffix(p, "/tags/list")
+		if _, ok := s.tags[repo]; !ok {
+			writeError(w, http.StatusNotFound, "NAME_UNKNOWN", "repository name not known to registry")
+			return
+		}
+		var tags []string
+		for tag := range s.tags[repo] {
+			tags = append(tags, tag)
+		}
+		servePage(w, r, "tags", tags)
+	case strings.Contains(p, "/blobs/uploads/"):
+		i := strings.LastIndex(p, "/blobs/uploads/")
+		s.serveUpload(w, r, p[:i], p[i+len("/blobs/uploads/"):])
+	case strings.Contains(p, "/manifests/"):
+		i := strings.LastIndex(p, "/manifests/")
+		s.serveManifest(w, r, p[:i], p[i+len("/manifests/"):])
+	case strings.Contains(p, "/blobs/"):
+		i := strings.LastIndex(p, "/blobs/")
+		s.serveBlob(w, r, p[:i], p[i+len("/blobs/"):])
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+// servePage serves a page of a sorted list, following the pagination rules of the distribution API.
+func servePage(w http.ResponseWriter, r *http.Request, key string, list []string) {
+	sort.Strings(list)
+
+	start := 0
+	if last := r.URL.Query().Get("last"); len(last) > 0 {
+		start = sort.SearchStrings(list, last)
+		if start < len(list) && list[start] == last {
+			start++
+		}
+	}
+
+	end := len(list)
+	if n, err := strconv.Atoi(r.URL.Query().Get("n")); err == nil && start+n < end {
+		end = start + n
+		w.Header().Set("Link", fmt.Sprintf(`<%s?n=%d&last=%s>; rel="next"`, r.URL.Path, n, list[end-1]))
+	}
+
+	page := list[start:end]
+	if page == nil {
+		page = []string{}
+	}
+	json.NewEncoder(w).Encode(map[string][]string{key: page})
+}
+
+// serveManifest serves manifest APIs for a tag or a digest.
+func (s *Server) serveManifest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	if r.Method == http.MethodPut {
+		s.putManifestRequest(w, r, repo, ref)
+		return
+	}
+
+	digest := ref
+	if !strings.HasPrefix(ref, "sha256:") {
+		digest = s.tags[repo][ref]
+	}
+
+	m, ok := s.manifests[repo][digest]
+	if !ok {
+		writeError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", "manifest unknown")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.body)))
+		if r.Method == http.MethodGet {
+			w.Write(m.body)
+		}
+	case http.MethodDelete:
+		if s.DeleteDisabled {
+			writeError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", "The operation is unsupported.")
+			return
+		}
+		if !strings.HasPrefix(ref, "sha256:") {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+			return
+		}
+		for tag, d := range s.tags[repo] {
+			if d == digest {
+				delete(s.tags[repo], tag)
+			}
+		}
+		delete(s.manifests[repo], digest)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// serveBlob serves blob APIs.
+func (s *Server) serveBlob(w http.ResponseWriter, r *http.Request, repo, digest string) {
+	content, ok := s.blobs[repo][digest]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UNKNOWN", "blob unknown to registry")
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		if r.Method == http.MethodGet {
+			w.Write(content)
+		}
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// putManifestRequest stores a manifest sent by a client, once the content it refers to is checked.
+func (s *Server) putManifestRequest(w http.ResponseWriter, r *http.Request, repo, ref string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+		return
+	}
+
+	var refs struct {
+		Config *struct {
+			Digest string `json:"digest"`
+		} `json:"config"`
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+		Manifests []struct {
+			Digest string `json:"digest"`
+		} `json:"manifests"`
+	}
+	if err := json.Unmarshal(body, &refs); err != nil {
+		writeError(w, http.StatusBadRequest, "MANIFEST_INVALID", "manifest invalid")
+		return
+	}
+
+	// Everything the manifest refers to must be uploaded first.
+	var blobs []string
+	if refs.Config != nil {
+		blobs = append(blobs, refs.Config.Digest)
+	}
+	for _, l := range refs.Layers {
+		blobs = append(blobs, l.Digest)
+	}
+	for _, digest := range blobs {
+		if _, ok := s.blobs[repo][digest]; !ok {
+			writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "blob unknown to registry: "+digest)
+			return
+		}
+	}
+	for _, m := range refs.Manifests {
+		if _, ok := s.manifests[repo][m.Digest]; !ok {
+			writeError(w, http.StatusBadRequest, "MANIFEST_BLOB_UNKNOWN", "manifest unknown to registry: "+m.Digest)
+			return
+		}
+	}
+
+	tag := ref
+	if strings.HasPrefix(ref, "sha256:") {
+		if digestOf(body) != ref {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+			return
+		}
+		tag = ""
+	}
+
+	digest := s.putManifest(repo, tag, r.Header.Get("Content-Type"), body)
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", repo, digest))
+	w.WriteHeader(http.StatusCreated)
+}
+
+// serveUpload serves blob upload APIs: POST starts a session, or mounts a blob from another
+// repository, PATCH sends a chunk and PUT completes the upload with the last chunk.
+func (s *Server) serveUpload(w http.ResponseWriter, r *http.Request, repo, id string) {
+	if r.Method == http.MethodPost && len(id) == 0 {
+		// A blob which can not be mounted is uploaded in a new session instead.
+		digest, from := r.URL.Query().Get("mount"), r.URL.Query().Get("from")
+		if len(from) > 0 && s.MountDenied {
+			writeError(w, http.StatusForbidden, "DENIED", "requested access to the resource is denied")
+			return
+		}
+		if content, ok := s.blobs[from][digest]; ok && len(digest) > 0 {
+			if s.blobs[repo] == nil {
+				s.blobs[repo] = map[string][]byte{}
+			}
+			s.blobs[repo][digest] = content
+			s.mounted++
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest))
+			w.WriteHeader(http.StatusCreated)
+			return
+		}
+
+		s.lastUpload++
+		id = strconv.Itoa(s.lastUpload)
+		s.uploads[id] = []byte{}
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.Header().Set("Docker-Upload-UUID", id)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	content, ok := s.uploads[id]
+	if !ok {
+		writeError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", "blob upload unknown to registry")
+		return
+	}
+
+	chunk, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+	content = append(content, chunk...)
+
+	switch r.Method {
+	case http.MethodPatch:
+		s.uploads[id] = content
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", repo, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(content)-1))
+		w.WriteHeader(http.StatusAccepted)
+	case http.MethodPut:
+		delete(s.uploads, id)
+		digest := r.URL.Query().Get("digest")
+		if digestOf(content) != digest {
+			writeError(w, http.StatusBadRequest, "DIGEST_INVALID", "provided digest did not match uploaded content")
+			return
+		}
+
+		if s.blobs[repo] == nil {
+			s.blobs[repo] = map[string][]byte{}
+		}
+		s.blobs[repo][digest] = content
+		s.uploaded++
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", repo, digest))
+		w.WriteHeader(http.StatusCreated)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// writeError writes an error response in the format of the distribution API.
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"errors": []map[string]string{{"code": code, "message": message}},
+	})
+}
+
+// digestOf computes the sha256 digest of the content.
+func digestOf(content []byte) string {
+	sum := sha256.Sum256(content)
+	return fmt.Sprintf("sha256:%s", hex.EncodeToString(sum[:]))
+}
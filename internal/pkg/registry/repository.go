@@ -0,0 +1,96 @@
+package registry
+
+import (
+	"io"
+)
+
+// Repository is a repository of a registry, which serves as a Source or a Target of copies.
+type Repository struct {
+	client *Client
+	name   string
+
+	// mountFrom is the repository of the same registry to mount blobs from.
+	mountFrom string
+
+	// uploads maps blob digest to the upload session started when the blob could not be mounted.
+	uploads map[string]string
+
+	// mountDenied tells the registry refuses to mount blobs from mountFrom, which is not tried again.
+	mountDenied bool
+}
+
+// Repository returns the repository with the name.
+func (c *Client) Repository(name string) *Repository {
+	return &Repository{client: c, name: name}
+}
+
+// MountFrom makes the repository, as a Target, mount blobs from another repository of the
+// same registry instead of copying their content.
+func (r *Repository) MountFrom(from string) *Repository {
+	r.mountFrom = from
+	r.uploads = map[string]string{}
+	return r
+}
+
+// Name returns the name of the repository.
+func (r *Repository) Name() string {
+	return r.name
+}
+
+// Manifest implements Source.
+func (r *Repository) Manifest(digest string) (*RawManifest, error) {
+	return r.client.GetManifest(r.name, digest)
+}
+
+// Blob implements Source.
+func (r *Repository) Blob(desc Descriptor) (io.ReadCloser, error) {
+	blob, _, err := r.client.GetBlob(r.name, desc.Digest)
+	return blob, err
+}
+
+// HasBlob implements Target.
+func (r *Repository) HasBlob(desc Descriptor) (bool, error) {
+	return r.client.BlobExists(r.name, desc.Digest)
+}
+
+// MountBlob implements Mounter. Blobs are mounted only if a repository to mount from is set.
+func (r *Repository) MountBlob(desc Descriptor) (bool, error) {
+	if len(r.mountFrom) == 0 || r.mountFrom == r.name || r.mountDenied {
+		return false, nil
+	}
+
+	mounted, location, err := r.client.MountBlob(r.name, desc.Digest, r.mountFrom)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case mounted:
+	case len(location) > 0:
+		r.uploads[desc.Digest] = location
+	default:
+		// Refused, the blob is pushed as usual.
+		r.mountDenied = true
+	}
+	return mounted, nil
+}
+
+// PutBlob implements Target. The upload session started by a failed mount is used if there is one.
+func (r *Repository) PutBlob(desc Descriptor, content io.Reader) error {
+	if location, ok := r.uploads[desc.Digest]; ok {
+		delete(r.uploads, desc.Digest)
+		return r.client.UploadBlob(location, desc, content)
+	}
+	return r.client.PushBlob(r.name, desc, content)
+}
+
+// PutManifest implements Target. A manifest without tag is stored by digest.
+func (r *Repository) PutManifest(m *RawManifest, tag string) error {
+	reference := tag
+	if len(reference) == 0 {
+		reference = Digest(m.Body)
+	}
+
+	_, err := r.client.PutManifest(r.name, reference, m)
+	return err
+}
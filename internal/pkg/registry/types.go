@@ -0,0 +1,150 @@
+package registry
+
+import (
+	"time"
+)
+
+const (
+	// MediaTypeDockerManifest is the media type of Docker image manifest, schema 2.
+	MediaTypeDockerManifest = "application/vnd.docker.distribution.manifest.v2+json"
+
+	// MediaTypeDockerManifestList is the media type of Docker manifest list, which points to
+	// the manifests of several platforms.
+	MediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+
+	// MediaTypeDockerConfig is the media type of Docker image config.
+	MediaTypeDockerConfig = "application/vnd.docker.container.image.v1+json"
+
+	// MediaTypeDockerLayer is the media type of Docker gzipped image layer.
+	MediaTypeDockerLayer = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	// MediaTypeDockerLayerTar is the media type of Docker uncompressed image layer.
+	MediaTypeDockerLayerTar = "application/vnd.docker.image.rootfs.diff.tar"
+
+	// MediaTypeOCIManifest is the media type of OCI image manifest.
+	MediaTypeOCIManifest = "application/vnd.oci.image.manifest.v1+json"
+
+	// MediaTypeOCIIndex is the media type of OCI image index, which points to the manifests
+	// of several platforms.
+	MediaTypeOCIIndex = "application/vnd.oci.image.index.v1+json"
+
+	// MediaTypeOCIConfig is the media type of OCI image config.
+	MediaTypeOCIConfig = "application/vnd.oci.image.config.v1+json"
+
+	// MediaTypeOCILayer is the media type of OCI gzipped image layer.
+	MediaTypeOCILayer = "application/vnd.oci.image.layer.v1.tar+gzip"
+)
+
+// ManifestMediaTypes are the manifest media types regi accepts.
+var ManifestMediaTypes = []string{
+	MediaTypeOCIIndex,
+	MediaTypeDockerManifestList,
+	MediaTypeOCIManifest,
+	MediaTypeDockerManifest,
+}
+
+// IsIndexMediaType tells if the media type is of a multi-platform index.
+func IsIndexMediaType(mediaType string) bool {
+	return mediaType == MediaTypeOCIIndex || mediaType == MediaTypeDockerManifestList
+}
+
+// Platform describes the platform an image runs on.
+type Platform struct {
+	Architecture string   `json:"architecture"`
+	OS           string   `json:"os"`
+	OSVersion    string   `json:"os.version,omitempty"`
+	OSFeatures   []string `json:"os.features,omitempty"`
+	Variant      string   `json:"variant,omitempty"`
+}
+
+// String formats the platform as 'os/architecture[/variant]', e.g. 'linux/arm64/v8'.
+func (p Platform) String() string {
+	s := p.OS + "/" + p.Architecture
+	if len(p.Variant) > 0 {
+		s += "/" + p.Variant
+	}
+	return s
+}
+
+// Descriptor describes the content a manifest or an index points to.
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	URLs        []string          `json:"urls,omitempty"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+// Manifest is an image manifest, either Docker schema 2 or OCI.
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// Index is a multi-platform image index, either a Docker manifest list or an OCI image index.
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageConfig is the image config blob, which describes how to run the image.
+type ImageConfig struct {
+	Created      *time.Time      `json:"created,omitempty"`
+	Author       string          `json:"author,omitempty"`
+	Architecture string          `json:"architecture"`
+	OS           string          `json:"os"`
+	Variant      string          `json:"variant,omitempty"`
+	Config       ContainerConfig `json:"config"`
+	RootFS       RootFS          `json:"rootfs"`
+	History      []History       `json:"history,omitempty"`
+}
+
+// Platform returns the platform the image runs on.
+func (c *ImageConfig) Platform() Platform {
+	return Platform{Architecture: c.Architecture, OS: c.OS, Variant: c.Variant}
+}
+
+// ContainerConfig holds the execution parameters of an image.
+type ContainerConfig struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+// RootFS references the layer content addresses used by an image.
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+// History describes the history of a layer.
+type History struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Author     string     `json:"author,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+// catalog is the response of the catalog API.
+type catalog struct {
+	Repositories []string `json:"repositories"`
+}
+
+// tagList is the response of the tags API.
+type tagList struct {
+	Name string   `json:"name"`
+	Tags []string `json:"tags"`
+}
@@ -0,0 +1,390 @@
+package rest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// headerAuthenticate is the header carrying authentication challenges.
+	headerAuthenticate = "WWW-Authenticate"
+
+	// headerAuthorization is the header carrying credentials.
+	headerAuthorization = "Authorization"
+
+	// schemeBearer is the token based authentication scheme.
+	schemeBearer = "bearer"
+
+	// schemeBasic is the username and password based authentication scheme.
+	schemeBasic = "basic"
+
+	// defaultBearerTokenTag is the tag used with a static bearer token.
+	defaultBearerTokenTag = "Bearer"
+
+	// tokenClientID identifies regi to token servers, when exchanging an identity token.
+	tokenClientID = "regi"
+
+	// defaultTokenExpiry is used when a token server does not tell how long a token lives.
+	defaultTokenExpiry = 60 * time.Second
+)
+
+// Challenge defines an authentication challenge sent by a server in the WWW-Authenticate header.
+type Challenge struct {
+	// Scheme is the lower-cased authentication scheme, e.g. 'bearer' or 'basic'.
+	Scheme string
+
+	// Params holds the challenge parameters, e.g. realm, service and scope.
+	Params map[string]string
+}
+
+// ParseChallenges parses all the challenges found in the given WWW-Authenticate header values.
+func ParseChallenges(headers []string) []Challenge {
+	var challenges []Challenge
+	for _, h := range headers {
+		challenges = append(challenges, parseChallengeHeader(h)...)
+	}
+	return challenges
+}
+
+// parseChallengeHeader parses a single WWW-Authenticate header value, which may hold several challenges.
+func parseChallengeHeader(h string) []Challenge {
+	var (
+		challenges []Challenge
+		current    *Challenge
+	)
+
+	s := strings.TrimSpace(h)
+	for len(s) > 0 {
+		// Read a token, which is either a scheme or a parameter name.
+		i := strings.IndexAny(s, " =,")
+		if i < 0 {
+			i = len(s)
+		}
+		tok := s[:i]
+		s = strings.TrimLeft(s[i:], " ")
+
+		if strings.HasPrefix(s, "=") && current != nil {
+			// Parameter of the current challenge.
+			var val string
+			val, s = readParamValue(strings.TrimLeft(s[1:], " "))
+			current.Params[strings.ToLower(tok)] = val
+		} else if len(tok) > 0 {
+			// A new challenge starts.
+			challenges = append(challenges, Challenge{Scheme: strings.ToLower(tok), Params: map[string]string{}})
+			current = &challenges[len(challenges)-1]
+		}
+
+		s = strings.TrimLeft(s, " ,")
+	}
+
+	return challenges
+}
+
+// readParamValue reads a token or a quoted string and returns it with the unread remainder.
+func readParamValue(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		i := strings.IndexAny(s, " ,")
+		if i < 0 {
+			return s, ""
+		}
+		return s[:i], s[i:]
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if i+1 < len(s) {
+				i++
+				b.WriteByte(s[i])
+			}
+		case '"':
+			return b.String(), s[i+1:]
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), ""
+}
+
+// TokenCache caches bearer tokens per service and scope. It is safe for concurrent use
+// and can be shared by several clients talking to the same registry.
+type TokenCache struct {
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+// cachedToken is a token with its expiry time.
+type cachedToken struct {
+	value     string
+	expiresAt time.Time
+}
+
+// NewTokenCache returns an empty token cache.
+func NewTokenCache() *TokenCache {
+	return &TokenCache{tokens: map[string]cachedToken{}}
+}
+
+// Get returns a cached token which has not expired yet.
+func (c *TokenCache) Get(key string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	t, ok := c.tokens[key]
+	if !ok {
+		return "", false
+	}
+	if time.Now().After(t.expiresAt) {
+		delete(c.tokens, key)
+		return "", false
+	}
+	return t.value, true
+}
+
+// Set caches a token for the given duration.
+func (c *TokenCache) Set(key, value string, expiresIn time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.tokens[key] = cachedToken{value: value, expiresAt: time.Now().Add(expiresIn)}
+}
+
+// tokenResponse is the token server response defined by the distribution token authentication spec.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// authorize answers the given challenges and returns the value for the Authorization header.
+// Token authentication is preferred over basic authentication. An empty value is returned
+// if none of the challenges can be answered.
+func (c *Client) authorize(challenges []Challenge) (string, error) {
+	for _, ch := range challenges {
+		if ch.Scheme == schemeBearer {
+			token, err := c.bearerToken(ch)
+			if err != nil {
+				return "", err
+			}
+			return "Bearer " + token, nil
+		}
+	}
+
+	// An identity token is only good for the token server.
+	for _, ch := range challenges {
+		if ch.Scheme == schemeBasic && len(c.username) > 0 && len(c.identityToken) == 0 {
+			return "Basic " + basicAuth(c.username, c.password), nil
+		}
+	}
+
+	return "", nil
+}
+
+// staticAuthorization returns the Authorization header value for a configured bearer token.
+func (c *Client) staticAuthorization() string {
+	if len(c.bearerTokenValue) == 0 {
+		return ""
+	}
+
+	tag := c.bearerTokenTag
+	if len(tag) == 0 {
+		tag = defaultBearerTokenTag
+	}
+	return fmt.Sprintf("%s %s", tag, c.bearerTokenValue)
+}
+
+// basicAuth encodes username and password for basic authentication.
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+// bearerToken returns a token for the given challenge, either from cache or from the token server.
+func (c *Client) bearerToken(ch Challenge) (string, error) {
+	realm := ch.Params["realm"]
+	if len(realm) == 0 {
+		return "", errors.New("bearer challenge has no realm")
+	}
+
+	key := fmt.Sprintf("%s|%s|%s", realm, ch.Params["service"], ch.Params["scope"])
+	if token, ok := c.tokens.Get(key); ok {
+		return token, nil
+	}
+
+	req, err := c.tokenRequest(realm, ch)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := c.Client.Do(req)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to fetch token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("unable to fetch token from %s, server responded %s", realm, resp.Status)
+	}
+
+	var tr tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tr); err != nil {
+		return "", errors.Wrap(err, "unable to decode token response")
+	}
+
+	token := tr.Token
+	if len(token) == 0 {
+		token = tr.AccessToken
+	}
+	if len(token) == 0 {
+		return "", errors.Errorf("token server %s returned an empty token", realm)
+	}
+
+	expiresIn := defaultTokenExpiry
+	if tr.ExpiresIn > 0 {
+		expiresIn = time.Duration(tr.ExpiresIn) * time.Second
+	}
+	c.tokens.Set(key, token, expiresIn)
+
+	return token, nil
+}
+
+// tokenRequest returns the request for a token answering the challenge. An identity token is
+// exchanged with the OAuth2 refresh token grant, otherwise a token is asked for with the
+// username and password, if any.
+func (c *Client) tokenRequest(realm string, ch Challenge) (*http.Request, error) {
+	u, err := url.Parse(realm)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid token realm %q", realm)
+	}
+
+	if len(c.identityToken) > 0 {
+		form := url.Values{}
+		form.Set("grant_type", "refresh_token")
+		form.Set("refresh_token", c.identityToken)
+		form.Set("client_id", tokenClientID)
+		if service := ch.Params["service"]; len(service) > 0 {
+			form.Set("service", service)
+		}
+		if scope := ch.Params["scope"]; len(scope) > 0 {
+			form.Set("scope", scope)
+		}
+
+		req, err := http.NewRequest("POST", u.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req, nil
+	}
+
+	q := u.Query()
+	if service := ch.Params["service"]; len(service) > 0 {
+		q.Set("service", service)
+	}
+	for _, scope := range strings.Fields(ch.Params["scope"]) {
+		q.Add("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.username) > 0 {
+		req.SetBasicAuth(c.username, c.password)
+	}
+	return req, nil
+}
+
+// authorization returns the Authorization header value for a request to the URL, either the
+// static one, or the one answering the challenge remembered for its repository. An empty value
+// is returned if there is none yet.
+func (c *Client) authorization(u string) (string, error) {
+	if authz := c.staticAuthorization(); len(authz) > 0 {
+		return authz, nil
+	}
+
+	challenges := c.rememberedChallenges(u)
+	if len(challenges) == 0 {
+		return "", nil
+	}
+	return c.authorize(challenges)
+}
+
+// rememberedChallenges returns the challenges answered for the repository of the URL, or for
+// its host.
+func (c *Client) rememberedChallenges(u string) []Challenge {
+	c.challengesMu.Lock()
+	defer c.challengesMu.Unlock()
+
+	for _, key := range authorizationKeys(u) {
+		if challenges, ok := c.challenges[key]; ok {
+			return challenges
+		}
+	}
+	return nil
+}
+
+// rememberChallenges remembers the challenges answered for a request to the URL, so that the
+// following requests to the same repository are authorized up front. Bearer tokens are scoped
+// to a repository, basic credentials are valid for the whole host.
+func (c *Client) rememberChallenges(u string, challenges []Challenge) {
+	c.challengesMu.Lock()
+	defer c.challengesMu.Unlock()
+
+	if c.challenges == nil {
+		c.challenges = map[string][]Challenge{}
+	}
+
+	keys := authorizationKeys(u)
+	c.challenges[keys[0]] = challenges
+
+	for _, ch := range challenges {
+		if ch.Scheme == schemeBearer {
+			return
+		}
+	}
+	c.challenges[keys[len(keys)-1]] = challenges
+}
+
+// authorizationKeys returns the keys challenges are remembered by for a request to the URL: the
+// host and repository, e.g. 'registry.test/library/alpine', then the host alone.
+func authorizationKeys(u string) []string {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return []string{u}
+	}
+
+	host := parsed.Host
+	if name := repository(parsed.Path); len(name) > 0 {
+		return []string{host + "/" + name, host}
+	}
+	return []string{host}
+}
+
+// repository returns the repository name of a registry API path, e.g. 'library/alpine' for
+// '/v2/library/alpine/manifests/latest', or an empty name if the path is not about a repository.
+func repository(path string) string {
+	i := strings.Index(path, "/v2/")
+	if i < 0 {
+		return ""
+	}
+	path = path[i+len("/v2/"):]
+
+	end := -1
+	for _, segment := range []string{"/manifests/", "/blobs/", "/tags/"} {
+		if j := strings.LastIndex(path, segment); j > end {
+			end = j
+		}
+	}
+	if end <= 0 {
+		return ""
+	}
+	return path[:end]
+}
@@ -0,0 +1,280 @@
+package rest
+
+import (
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseChallenges(t *testing.T) {
+	cases := []struct {
+		name     string
+		headers  []string
+		expected []Challenge
+	}{
+		{
+			name:    "bearer with scope list",
+			headers: []string{`Bearer realm="https://auth.test/token",service="registry.test",scope="repository:foo/bar:pull,push"`},
+			expected: []Challenge{
+				{
+					Scheme: "bearer",
+					Params: map[string]string{
+						"realm":   "https://auth.test/token",
+						"service": "registry.test",
+						"scope":   "repository:foo/bar:pull,push",
+					},
+				},
+			},
+		},
+		{
+			name:    "multiple challenges",
+			headers: []string{`Basic realm="Registry Realm"`, `Bearer realm="https://auth.test/token"`},
+			expected: []Challenge{
+				{Scheme: "basic", Params: map[string]string{"realm": "Registry Realm"}},
+				{Scheme: "bearer", Params: map[string]string{"realm": "https://auth.test/token"}},
+			},
+		},
+		{
+			name:     "empty header",
+			headers:  []string{""},
+			expected: nil,
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, ParseChallenges(c.headers), c.name)
+	}
+}
+
+func TestClientBearerAuth(t *testing.T) {
+	tokenRequests := 0
+
+	// A stand-in token server which only grants tokens to regi:secret.
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "regi" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "registry.test", r.URL.Query().Get("service"))
+		assert.Equal(t, "registry:catalog:*", r.URL.Query().Get("scope"))
+
+		tokenRequests++
+		fmt.Fprint(w, `{"token":"abc","expires_in":300}`)
+	}))
+	defer tokenServer.Close()
+
+	// A registry which requires a token for every request.
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry.test",scope="registry:catalog:*"`, tokenServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, `{"repositories":["hello-world"]}`)
+	}))
+	defer registry.Close()
+
+	cache := NewTokenCache()
+	for i := 0; i < 2; i++ {
+		client, err := NewClient(&ClientConfig{
+			Host:          registry.URL,
+			APIPath:       "v2/_catalog",
+			ContentConfig: &ContentConfig{ContentType: "application/json"},
+			Username:      "regi",
+			Password:      "secret",
+			TokenCache:    cache,
+		})
+		assert.NoError(t, err)
+
+		resp, err := client.Verb("GET").Do()
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		rres, err := DecodeResponse(resp)
+		resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, []interface{}{"hello-world"}, rres["repositories"])
+	}
+
+	// The second request must reuse the cached token.
+	assert.Equal(t, 1, tokenRequests)
+
+	// Wrong credentials must not be granted a token.
+	client, err := NewClient(&ClientConfig{
+		Host:     registry.URL,
+		APIPath:  "v2/_catalog",
+		Username: "regi",
+		Password: "wrong",
+	})
+	assert.NoError(t, err)
+
+	_, err = client.Verb("GET").Do()
+	assert.Error(t, err)
+}
+
+func TestClientIdentityToken(t *testing.T) {
+	// A token server which only exchanges the refresh token.
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _, ok := r.BasicAuth()
+		assert.False(t, ok)
+		assert.Equal(t, "POST", r.Method)
+		assert.NoError(t, r.ParseForm())
+		if r.PostForm.Get("grant_type") != "refresh_token" || r.PostForm.Get("refresh_token") != "refresh" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		assert.Equal(t, "registry.test", r.PostForm.Get("service"))
+		assert.Equal(t, "repository:alpine:pull", r.PostForm.Get("scope"))
+		fmt.Fprint(w, `{"access_token":"abc","expires_in":300}`)
+	}))
+	defer tokenServer.Close()
+
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer abc" {
+			_, _, ok := r.BasicAuth()
+			assert.False(t, ok)
+			w.Header().Add("WWW-Authenticate", `Basic realm="registry"`)
+			w.Header().Add("WWW-Authenticate", fmt.Sprintf(
+				`Bearer realm="%s/token",service="registry.test",scope="repository:alpine:pull"`, tokenServer.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+	}))
+	defer registry.Close()
+
+	client, err := NewClient(&ClientConfig{Host: registry.URL, APIPath: "v2", IdentityToken: "refresh"})
+	assert.NoError(t, err)
+
+	// The identity token is never sent to the registry, even when it offers basic authentication.
+	resp, err := client.Verb("GET").Path("alpine", "tags", "list").Do()
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	// A wrong identity token is not granted a token.
+	client, err = NewClient(&ClientConfig{Host: registry.URL, APIPath: "v2", IdentityToken: "wrong"})
+	assert.NoError(t, err)
+	_, err = client.Verb("GET").Path("alpine", "tags", "list").Do()
+	assert.Error(t, err)
+}
+
+func TestClientRepositoryScopedAuth(t *testing.T) {
+	// A token server granting a token per scope.
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"token":%q,"expires_in":300}`, r.URL.Query().Get("scope"))
+	}))
+	defer tokenServer.Close()
+
+	// A registry which requires a token scoped to the repository of each request.
+	challenges := 0
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path[:strings.LastIndex(r.URL.Path, "/blobs/")], "/v2/")
+		scope := "repository:" + name + ":pull,push"
+		if r.Header.Get("Authorization") != "Bearer "+scope {
+			challenges++
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s",service="registry.test",scope="%s"`,
+				tokenServer.URL, scope))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "blob content", string(body))
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer registry.Close()
+
+	client, err := NewClient(&ClientConfig{Host: registry.URL, APIPath: "v2", Username: "regi", Password: "secret"})
+	assert.NoError(t, err)
+
+	// Switching between repositories, each one is challenged once. Streams are sent once
+	// the challenge of their repository is known.
+	for _, name := range []string{"library/alpine", "library/busybox", "library/alpine", "library/busybox"} {
+		resp, err := client.Verb("PUT").Path(name, "blobs", "uploads", "1234").
+			RawBody(io.LimitReader(strings.NewReader("blob content"), 12), 12).Do()
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	}
+	assert.Equal(t, 2, challenges)
+}
+
+func TestAuthorizationKeys(t *testing.T) {
+	assert.Equal(t, []string{"registry.test/library/alpine", "registry.test"},
+		authorizationKeys("https://registry.test/v2/library/alpine/manifests/latest"))
+	assert.Equal(t, []string{"registry.test/alpine", "registry.test"},
+		authorizationKeys("https://registry.test/v2/alpine/blobs/uploads/?mount=sha256:abc&from=busybox"))
+	assert.Equal(t, []string{"registry.test/alpine", "registry.test"},
+		authorizationKeys("https://registry.test/v2/alpine/tags/list"))
+	assert.Equal(t, []string{"registry.test"}, authorizationKeys("https://registry.test/v2/_catalog"))
+}
+
+func TestClientBasicAuth(t *testing.T) {
+	// A registry which requires basic authentication.
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Basic realm="Registry Realm"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if user != "regi" || pass != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		fmt.Fprint(w, `{"repositories":["hello-world"]}`)
+	}))
+	defer registry.Close()
+
+	cases := []struct {
+		name     string
+		password string
+		status   int
+	}{
+		{name: "valid credentials", password: "secret", status: http.StatusOK},
+		{name: "invalid credentials", password: "wrong", status: http.StatusForbidden},
+	}
+
+	for _, c := range cases {
+		client, err := NewClient(&ClientConfig{
+			Host:     registry.URL,
+			APIPath:  "v2/_catalog",
+			Username: "regi",
+			Password: c.password,
+		})
+		assert.NoError(t, err)
+
+		resp, err := client.Verb("GET").Do()
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, c.status, resp.StatusCode, c.name)
+
+		_, err = DecodeResponse(resp)
+		resp.Body.Close()
+		assert.Equal(t, c.status != http.StatusOK, err != nil, c.name)
+	}
+}
+
+func TestClientStaticBearerToken(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Token xyz", r.Header.Get("Authorization"))
+	}))
+	defer registry.Close()
+
+	client, err := NewClient(&ClientConfig{
+		Host:           registry.URL,
+		APIPath:        "v2/",
+		BearerToken:    "xyz",
+		BearerTokenTag: "Token",
+	})
+	assert.NoError(t, err)
+
+	resp, err := client.Verb("GET").Do()
+	assert.NoError(t, err)
+	resp.Body.Close()
+}
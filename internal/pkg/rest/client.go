@@ -3,9 +3,11 @@ package rest
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"github.com/pkg/errors"
 	"net/http"
 	"net/url"
-	"time"
+	"os"
+	"sync"
 )
 
 // Client defines a common JAC CMS API client.
@@ -18,6 +20,24 @@ type Client struct {
 
 	contentConfig *ContentConfig
 
+	// username and password are used to answer authentication challenges.
+	username string
+	password string
+
+	// identityToken is exchanged for bearer tokens, instead of username and password.
+	identityToken string
+
+	// tokens caches bearer tokens obtained from token servers.
+	tokens *TokenCache
+
+	// bearerTokenValue and bearerTokenTag make up a static Authorization header.
+	bearerTokenValue string
+	bearerTokenTag   string
+
+	// challenges are the challenges answered so far, by repository, see authorizationKeys.
+	challengesMu sync.Mutex
+	challenges   map[string][]Challenge
+
 	*http.Client
 }
 
@@ -37,6 +57,8 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 			return nil, err
 		}
 		enableTLS = true
+	} else {
+		client = &http.Client{}
 	}
 
 	base, versionedAPIPath, err := DefaultServerURL(cfg.Host, cfg.APIPath, enableTLS)
@@ -45,14 +67,25 @@ func NewClient(cfg *ClientConfig) (*Client, error) {
 	}
 
 	// SetCurrentContext client timeout.
-	client = &http.Client{}
-	client.Timeout = time.Second * 2
+	client.Timeout = cfg.Timeout
+
+	// Share the token cache if one is given.
+	tokens := cfg.TokenCache
+	if tokens == nil {
+		tokens = NewTokenCache()
+	}
 
 	return &Client{
 		base:             base,
 		versionedAPIPath: versionedAPIPath,
 		Client:           client,
 		contentConfig:    cfg.ContentConfig,
+		username:         cfg.Username,
+		password:         cfg.Password,
+		identityToken:    cfg.IdentityToken,
+		tokens:           tokens,
+		bearerTokenValue: cfg.BearerToken,
+		bearerTokenTag:   cfg.BearerTokenTag,
 	}, nil
 }
 
@@ -61,22 +94,63 @@ func (c *Client) Verb(verb string) *Request {
 	return NewRequest(c).Verb(verb)
 }
 
-// createHTTPClientWithTLS creates an HTTPS client.
+// createHTTPClientWithTLS creates an HTTPS client. PEM data takes precedence over files.
 func createHTTPClientWithTLS(tlsConfig *TLSClientConfig) (*http.Client, error) {
-	cert, err := tls.X509KeyPair(tlsConfig.CertData, tlsConfig.KeyData)
+	conf := &tls.Config{
+		InsecureSkipVerify: tlsConfig.Insecure,
+		ServerName:         tlsConfig.ServerName,
+	}
+
+	// Client certificate for mutual TLS.
+	certData, err := readPEM(tlsConfig.CertData, tlsConfig.CertFile)
+	if err != nil {
+		return nil, err
+	}
+	keyData, err := readPEM(tlsConfig.KeyData, tlsConfig.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	if len(certData) > 0 || len(keyData) > 0 {
+		cert, err := tls.X509KeyPair(certData, keyData)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load client certificate")
+		}
+		conf.Certificates = []tls.Certificate{cert}
+	}
+
+	// Trusted root certificates, in addition to the system ones.
+	caData, err := readPEM(tlsConfig.CAData, tlsConfig.CAFile)
 	if err != nil {
 		return nil, err
 	}
+	if len(caData) > 0 {
+		caCertPool, err := x509.SystemCertPool()
+		if err != nil || caCertPool == nil {
+			caCertPool = x509.NewCertPool()
+		}
+		if !caCertPool.AppendCertsFromPEM(caData) {
+			return nil, errors.New("unable to load CA certificates, no valid PEM certificate found")
+		}
+		conf.RootCAs = caCertPool
+	}
 
-	caCertPool := x509.NewCertPool()
-	caCertPool.AppendCertsFromPEM(tlsConfig.CAData)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = conf
 
 	return &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				Certificates: []tls.Certificate{cert},
-				RootCAs:      caCertPool,
-			},
-		},
+		Transport: transport,
 	}, nil
 }
+
+// readPEM returns the given PEM data, or reads it from file if data is empty.
+func readPEM(data []byte, file string) ([]byte, error) {
+	if len(data) > 0 || len(file) == 0 {
+		return data, nil
+	}
+
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", file)
+	}
+	return data, nil
+}
@@ -1,7 +1,21 @@
 package rest
 
 import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
 	"github.com/stretchr/testify/assert"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 )
@@ -42,3 +56,102 @@ func TestNewClient(t *testing.T) {
 		"regi is a CLI tool for managing your accessibility to multiple Docker registries.",
 		rres["description"])
 }
+
+func TestNewClientWithTLS(t *testing.T) {
+	// A registry which requires a client certificate.
+	registry := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	registry.TLS = &tls.Config{ClientAuth: tls.RequireAnyClientCert}
+	registry.StartTLS()
+	defer registry.Close()
+
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{
+		Type:  "CERTIFICATE",
+		Bytes: registry.Certificate().Raw,
+	}), 0600)
+	assert.NoError(t, err)
+
+	certFile, keyFile := writeClientCert(t, dir)
+
+	cases := []struct {
+		name      string
+		tlsConfig *TLSClientConfig
+		ok        bool
+	}{
+		{
+			name:      "private CA with client certificate",
+			tlsConfig: &TLSClientConfig{CAFile: caFile, CertFile: certFile, KeyFile: keyFile},
+			ok:        true,
+		},
+		{
+			name:      "insecure skip verify with client certificate",
+			tlsConfig: &TLSClientConfig{Insecure: true, CertFile: certFile, KeyFile: keyFile},
+			ok:        true,
+		},
+		{
+			name:      "unknown CA",
+			tlsConfig: &TLSClientConfig{CertFile: certFile, KeyFile: keyFile},
+			ok:        false,
+		},
+		{
+			name:      "no client certificate",
+			tlsConfig: &TLSClientConfig{CAFile: caFile},
+			ok:        false,
+		},
+	}
+
+	for _, c := range cases {
+		client, err := NewClient(&ClientConfig{
+			Host:            strings.TrimPrefix(registry.URL, "https://"),
+			APIPath:         "v2/",
+			TLSClientConfig: c.tlsConfig,
+			Timeout:         time.Second * 3,
+		})
+		assert.NoError(t, err, c.name)
+
+		resp, err := client.Verb("GET").Do()
+		if !c.ok {
+			assert.Error(t, err, c.name)
+			continue
+		}
+		assert.NoError(t, err, c.name)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, c.name)
+		resp.Body.Close()
+	}
+
+	// Missing files are reported when creating the client.
+	_, err = NewClient(&ClientConfig{
+		Host:            registry.URL,
+		TLSClientConfig: &TLSClientConfig{CAFile: filepath.Join(dir, "missing.pem")},
+	})
+	assert.Error(t, err)
+}
+
+// writeClientCert writes a self-signed client certificate and its key into dir.
+func writeClientCert(t *testing.T, dir string) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err)
+
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "regi"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	assert.NoError(t, err)
+
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err)
+
+	certFile := filepath.Join(dir, "client.pem")
+	keyFile := filepath.Join(dir, "client-key.pem")
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+
+	return certFile, keyFile
+}
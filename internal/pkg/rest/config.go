@@ -17,11 +17,23 @@ type ClientConfig struct {
 	// sent to the server.
 	ContentConfig *ContentConfig
 
+	// Username and Password are used when server requires basic or token authentication.
+	Username string
+	Password string
+
+	// IdentityToken is an OAuth2 refresh token, e.g. saved by 'docker login', which is exchanged
+	// at the token server for bearer tokens. It is never sent as a password.
+	IdentityToken string
+
+	// TokenCache caches tokens obtained from token servers. It can be shared by clients
+	// talking to the same server. If not set, each client creates its own cache.
+	TokenCache *TokenCache
+
 	// BearerToken is needed when server requires Bearer authentication (not refreshable).
 	BearerToken string
 
 	// BearerTokenTag defines what tag name to be used.
-	// If not set, default 'bearer' will be set.
+	// If not set, default 'Bearer' will be set.
 	BearerTokenTag string
 
 	TLSClientConfig *TLSClientConfig
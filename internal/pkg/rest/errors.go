@@ -0,0 +1,127 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Error codes defined by the distribution spec.
+const (
+	ErrorCodeBlobUnknown         = "BLOB_UNKNOWN"
+	ErrorCodeBlobUploadInvalid   = "BLOB_UPLOAD_INVALID"
+	ErrorCodeBlobUploadUnknown   = "BLOB_UPLOAD_UNKNOWN"
+	ErrorCodeDigestInvalid       = "DIGEST_INVALID"
+	ErrorCodeManifestBlobUnknown = "MANIFEST_BLOB_UNKNOWN"
+	ErrorCodeManifestInvalid     = "MANIFEST_INVALID"
+	ErrorCodeManifestUnknown     = "MANIFEST_UNKNOWN"
+	ErrorCodeNameInvalid         = "NAME_INVALID"
+	ErrorCodeNameUnknown         = "NAME_UNKNOWN"
+	ErrorCodeSizeInvalid         = "SIZE_INVALID"
+	ErrorCodeUnauthorized        = "UNAUTHORIZED"
+	ErrorCodeDenied              = "DENIED"
+	ErrorCodeUnsupported         = "UNSUPPORTED"
+	ErrorCodeTooManyRequests     = "TOOMANYREQUESTS"
+)
+
+// maxErrorBodySize limits how much of an error response body is read.
+const maxErrorBodySize = 64 * 1024
+
+// ErrorDetail is an entry of the error envelope returned by registries, e.g.
+// {"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown","detail":{...}}]}.
+type ErrorDetail struct {
+	Code    string          `json:"code"`
+	Message string          `json:"message"`
+	Detail  json.RawMessage `json:"detail,omitempty"`
+}
+
+// RegistryError is a failed response from a registry. Code, Message and Detail come from
+// the first entry of the error envelope, if the registry sent one.
+type RegistryError struct {
+	ErrorDetail
+
+	// StatusCode and Status are the HTTP status of the response.
+	StatusCode int
+	Status     string
+
+	// URL is the requested URL.
+	URL string
+
+	// Errors holds all the entries of the error envelope.
+	Errors []ErrorDetail
+}
+
+// Error implements error.
+func (e *RegistryError) Error() string {
+	var msg string
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		msg = fmt.Sprintf("unauthorized to access %s, please check the user and password of the context", e.URL)
+	case http.StatusForbidden:
+		msg = fmt.Sprintf("access to %s is forbidden, the user of the context lacks permission", e.URL)
+	default:
+		msg = fmt.Sprintf("request to %s failed, server responded %s", e.URL, e.Status)
+	}
+
+	if len(e.Errors) == 0 {
+		return msg
+	}
+
+	var details []string
+	for _, d := range e.Errors {
+		details = append(details, fmt.Sprintf("%s: %s", d.Code, d.Message))
+	}
+	return fmt.Sprintf("%s (%s)", msg, strings.Join(details, "; "))
+}
+
+// newRegistryError creates a RegistryError from a failed response, decoding the error
+// envelope from the body if there is one.
+func newRegistryError(resp *http.Response) *RegistryError {
+	e := &RegistryError{
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+	}
+	if resp.Request != nil && resp.Request.URL != nil {
+		e.URL = resp.Request.URL.String()
+	}
+
+	if resp.Body == nil {
+		return e
+	}
+
+	var envelope struct {
+		Errors []ErrorDetail `json:"errors"`
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+	if err != nil || json.Unmarshal(data, &envelope) != nil || len(envelope.Errors) == 0 {
+		return e
+	}
+
+	e.Errors = envelope.Errors
+	e.ErrorDetail = envelope.Errors[0]
+	return e
+}
+
+// HasErrorCode tells if the error, or any error it wraps, is a RegistryError with the given code.
+func HasErrorCode(err error, code string) bool {
+	var e *RegistryError
+	if !errors.As(err, &e) {
+		return false
+	}
+
+	for _, d := range e.Errors {
+		if d.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// HasStatus tells if the error, or any error it wraps, is a RegistryError with the given HTTP status.
+func HasStatus(err error, status int) bool {
+	var e *RegistryError
+	return errors.As(err, &e) && e.StatusCode == status
+}
@@ -0,0 +1,159 @@
+package rest
+
+import (
+	"github.com/pkg/errors"
+	"net/http"
+	"strings"
+)
+
+const (
+	// headerLink is the header carrying RFC 5988 web links.
+	headerLink = "Link"
+
+	// relNext is the relation type of the link pointing to the next page.
+	relNext = "next"
+)
+
+// ParseLinks parses RFC 5988 Link header values into a map from relation type to URL,
+// e.g. `</v2/_catalog?last=b&n=100>; rel="next"` gives {"next": "/v2/_catalog?last=b&n=100"}.
+func ParseLinks(headers []string) map[string]string {
+	links := map[string]string{}
+	for _, h := range headers {
+		for _, link := range splitLinks(h) {
+			parts := strings.Split(link, ";")
+			target := strings.TrimSpace(parts[0])
+			if !strings.HasPrefix(target, "<") || !strings.HasSuffix(target, ">") {
+				continue
+			}
+			target = strings.TrimSuffix(strings.TrimPrefix(target, "<"), ">")
+
+			for _, param := range parts[1:] {
+				kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+				if len(kv) != 2 || strings.ToLower(strings.TrimSpace(kv[0])) != "rel" {
+					continue
+				}
+				// A rel parameter may hold several space separated relation types.
+				for _, rel := range strings.Fields(strings.Trim(kv[1], `"`)) {
+					links[strings.ToLower(rel)] = target
+				}
+			}
+		}
+	}
+	return links
+}
+
+// splitLinks splits a Link header value on the commas separating links, skipping the ones inside URLs.
+func splitLinks(h string) []string {
+	var (
+		links []string
+		inURL bool
+		start int
+	)
+	for i, c := range h {
+		switch c {
+		case '<':
+			inURL = true
+		case '>':
+			inURL = false
+		case ',':
+			if !inURL {
+				links = append(links, h[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(links, h[start:])
+}
+
+// Pager walks through paginated results. Each call to Next fetches a page, following the
+// Link header of the previous one until there is no next page.
+//
+//	pager := client.Pages(map[string]string{"n": "100"})
+//	defer pager.Close()
+//	for pager.Next() {
+//		resp := pager.Response()
+//		...
+//	}
+//	if err := pager.Err(); err != nil {
+//		...
+//	}
+type Pager struct {
+	first   *Request
+	next    string
+	started bool
+	resp    *http.Response
+	err     error
+}
+
+// Pages returns a Pager for GET requests. The selectors only apply to the first page,
+// since the links to the following pages carry their own query strings.
+func (c *Client) Pages(selectors map[string]string) *Pager {
+	return NewPager(c.Verb("GET").Selectors(selectors))
+}
+
+// NewPager returns a Pager which sends the given request for the first page.
+func NewPager(first *Request) *Pager {
+	return &Pager{first: first}
+}
+
+// Next fetches the next page. It returns false when all the pages have been fetched or an
+// error occurs. The response of the previous page is closed.
+func (p *Pager) Next() bool {
+	p.Close()
+	if p.err != nil || (p.started && len(p.next) == 0) {
+		return false
+	}
+
+	r := p.first
+	if !p.started {
+		p.started = true
+	} else {
+		r = p.first.client.Verb(p.first.verb)
+		r.headers = p.first.headers
+		r.url = p.next
+	}
+
+	resp, err := r.Do()
+	if err != nil {
+		p.err = err
+		return false
+	}
+	if err := CheckResponse(resp); err != nil {
+		resp.Body.Close()
+		p.err = err
+		return false
+	}
+
+	// Links are usually relative to the server.
+	p.next = ""
+	if link, ok := ParseLinks(resp.Header.Values(headerLink))[relNext]; ok {
+		u, err := r.client.base.Parse(link)
+		if err != nil {
+			resp.Body.Close()
+			p.err = errors.Wrapf(err, "invalid next page link %q", link)
+			return false
+		}
+		p.next = u.String()
+	}
+
+	p.resp = resp
+	return true
+}
+
+// Response returns the response of the current page.
+func (p *Pager) Response() *http.Response {
+	return p.resp
+}
+
+// Err returns the first error occurred while fetching pages.
+func (p *Pager) Err() error {
+	return p.err
+}
+
+// Close closes the response of the current page.
+func (p *Pager) Close() {
+	if p.resp != nil {
+		p.resp.Body.Close()
+		p.resp = nil
+	}
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+func TestParseLinks(t *testing.T) {
+	cases := []struct {
+		name     string
+		headers  []string
+		expected map[string]string
+	}{
+		{
+			name:     "next page",
+			headers:  []string{`</v2/_catalog?last=b&n=100>; rel="next"`},
+			expected: map[string]string{"next": "/v2/_catalog?last=b&n=100"},
+		},
+		{
+			name:    "several links",
+			headers: []string{`<https://registry.test/v2/_catalog?n=2&last=a,b>; rel="next", </v2/_catalog>; rel=first`},
+			expected: map[string]string{
+				"next":  "https://registry.test/v2/_catalog?n=2&last=a,b",
+				"first": "/v2/_catalog",
+			},
+		},
+		{
+			name:     "no link",
+			headers:  nil,
+			expected: map[string]string{},
+		},
+	}
+
+	for _, c := range cases {
+		assert.Equal(t, c.expected, ParseLinks(c.headers), c.name)
+	}
+}
+
+func TestPager(t *testing.T) {
+	repositories := []string{"alpine", "busybox", "golang", "hello-world", "mysql"}
+
+	// A registry which serves the catalog in pages of at most n entries.
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, err := strconv.Atoi(r.URL.Query().Get("n"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		start := sort.SearchStrings(repositories, r.URL.Query().Get("last"))
+		if last := r.URL.Query().Get("last"); len(last) > 0 {
+			start++
+		}
+
+		end := start + n
+		if end >= len(repositories) {
+			end = len(repositories)
+		} else {
+			w.Header().Set("Link", fmt.Sprintf(`</v2/_catalog?last=%s&n=%d>; rel="next"`, repositories[end-1], n))
+		}
+		json.NewEncoder(w).Encode(map[string][]string{"repositories": repositories[start:end]})
+	}))
+	defer registry.Close()
+
+	client, err := NewClient(&ClientConfig{Host: registry.URL, APIPath: "v2/_catalog"})
+	assert.NoError(t, err)
+
+	var (
+		pages int
+		got   []string
+	)
+	pager := client.Pages(map[string]string{"n": "2"})
+	defer pager.Close()
+	for pager.Next() {
+		var page struct {
+			Repositories []string `json:"repositories"`
+		}
+		assert.NoError(t, json.NewDecoder(pager.Response().Body).Decode(&page))
+		got = append(got, page.Repositories...)
+		pages++
+	}
+	assert.NoError(t, pager.Err())
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, repositories, got)
+
+	// Failed pages are reported.
+	pager = client.Pages(map[string]string{"n": "x"})
+	assert.False(t, pager.Next())
+	assert.Error(t, pager.Err())
+}
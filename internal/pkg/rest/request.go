@@ -4,8 +4,13 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"github.com/pkg/errors"
 	"io"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
 )
 
 const (
@@ -22,6 +27,13 @@ type Request struct {
 	url       string
 	body      map[string]string
 	selectors map[string]string
+	headers   http.Header
+
+	// rawBody is sent as it is, its size is known in advance.
+	rawBody io.Reader
+	rawSize int64
+
+	err error
 }
 
 // NewRequest returns an new Request.
@@ -44,19 +56,61 @@ func (r *Request) Selectors(selectors map[string]string) *Request {
 	return r
 }
 
+// Path appends segments to the request path, e.g. Path("library/alpine", "tags", "list").
+func (r *Request) Path(segments ...string) *Request {
+	r.url = fmt.Sprintf("%s/%s", strings.TrimSuffix(r.url, "/"), strings.Join(segments, "/"))
+	return r
+}
+
+// Location sets the request URL to a location given by the server, e.g. the Location header
+// of a blob upload. A relative location is resolved against the server.
+func (r *Request) Location(location string) *Request {
+	u, err := r.client.base.Parse(location)
+	if err != nil {
+		r.err = errors.Wrapf(err, "invalid location %q", location)
+		return r
+	}
+	r.url = u.String()
+	return r
+}
+
+// SetHeader sets a request header, which takes precedence over the client content config.
+func (r *Request) SetHeader(key, value string) *Request {
+	if r.headers == nil {
+		r.headers = http.Header{}
+	}
+	r.headers.Set(key, value)
+	return r
+}
+
 // Body receives body that will be used to make POST call.
 func (r *Request) Body(body map[string]string) *Request {
 	r.body = body
 	return r
 }
 
-// Do does the real dirty job.
+// RawBody receives a body that is sent as it is, e.g. the content of a blob. If the request
+// has to be sent again for authentication, the body is rewound if it is an io.Seeker, or else
+// replayed from what has been recorded of it.
+func (r *Request) RawBody(body io.Reader, size int64) *Request {
+	r.rawBody = body
+	r.rawSize = size
+	return r
+}
+
+// Do does the real dirty job. If the server challenges the request for basic or token
+// authentication, the request is sent again with credentials. Requests to a repository whose
+// challenge is answered already are authorized up front.
 func (r *Request) Do() (*http.Response, error) {
 	var (
-		body io.Reader
+		body []byte
 		err  error
 	)
 
+	if r.err != nil {
+		return nil, r.err
+	}
+
 	if r.selectors != nil {
 		r.url = fmt.Sprintf("%s%s", r.url, r.makeQueryStrings())
 	}
@@ -68,7 +122,154 @@ func (r *Request) Do() (*http.Response, error) {
 		}
 	}
 
-	req, err := http.NewRequest(r.verb, r.url, body)
+	// A stream sent before knowing how to authorize it is recorded, to be sent again if challenged.
+	var replay *replayBody
+	_, seekable := r.rawBody.(io.Seeker)
+	if r.rawBody != nil && !seekable && len(r.client.staticAuthorization()) == 0 &&
+		len(r.client.rememberedChallenges(r.url)) == 0 {
+		if replay, err = newReplayBody(r.rawBody); err != nil {
+			return nil, err
+		}
+		defer replay.close()
+		r.rawBody = replay
+	}
+
+	req, err := r.newHTTPRequest(body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	// Answer the authentication challenge if there is any.
+	challenges := ParseChallenges(resp.Header.Values(headerAuthenticate))
+	authz, err := r.client.authorize(challenges)
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(authz) == 0 {
+		return resp, nil
+	}
+	resp.Body.Close()
+	r.client.rememberChallenges(r.url, challenges)
+
+	// Retry with credentials. A raw body must be read again from the start.
+	switch {
+	case r.rawBody == nil:
+	case replay != nil:
+		if r.rawBody, err = replay.replay(); err != nil {
+			return nil, err
+		}
+	case seekable:
+		if _, err := r.rawBody.(io.Seeker).Seek(0, io.SeekStart); err != nil {
+			return nil, errors.Wrap(err, "unable to rewind request body")
+		}
+	default:
+		return nil, errors.Errorf("unable to send %s %s again with credentials, the body can not be rewound", r.verb, r.url)
+	}
+
+	req, err = r.newHTTPRequest(body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(headerAuthorization, authz)
+
+	return r.client.Do(req)
+}
+
+// replayBody records what is read of a body which can not be rewound to a temporary file, so
+// that the body can be sent again from the start.
+type replayBody struct {
+	mu      sync.Mutex
+	body    io.Reader
+	file    *os.File
+	stopped bool
+}
+
+// newReplayBody returns a replayBody recording the body.
+func newReplayBody(body io.Reader) (*replayBody, error) {
+	f, err := os.CreateTemp("", "regi-body-*")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to record request body")
+	}
+	return &replayBody{body: body, file: f}, nil
+}
+
+// Read implements io.Reader.
+func (b *replayBody) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	// The transport may still be reading the body of the first request.
+	if b.stopped {
+		return 0, errors.New("request body is being sent again")
+	}
+
+	n, err := b.body.Read(p)
+	if n > 0 {
+		if _, werr := b.file.Write(p[:n]); werr != nil {
+			return n, errors.Wrap(werr, "unable to record request body")
+		}
+	}
+	return n, err
+}
+
+// replay stops recording, and returns the whole body from the start: what has been recorded,
+// then what is left.
+func (b *replayBody) replay() (io.Reader, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.stopped = true
+	if _, err := b.file.Seek(0, io.SeekStart); err != nil {
+		return nil, errors.Wrap(err, "unable to replay request body")
+	}
+	return io.MultiReader(b.file, b.body), nil
+}
+
+// close removes the recording.
+func (b *replayBody) close() {
+	b.file.Close()
+	os.Remove(b.file.Name())
+}
+
+// newHTTPRequest creates an HTTP request with content headers set.
+func (r *Request) newHTTPRequest(body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	if r.rawBody != nil {
+		// Hide the concrete type so that the body is not closed by the transport.
+		reader = io.NopCloser(r.rawBody)
+	}
+
+	req, err := http.NewRequest(r.verb, r.url, reader)
+	if err != nil {
+		return nil, err
+	}
+	if r.rawBody != nil {
+		req.ContentLength = r.rawSize
+	}
+
+	// Credentials answering the challenge of the repository are likely to be accepted again.
+	authz, err := r.client.authorization(r.url)
+	if err != nil {
+		return nil, err
+	}
+	if len(authz) > 0 {
+		req.Header.Set(headerAuthorization, authz)
+	}
+
+	defer r.setHeaders(req)
+
+	if r.client.contentConfig == nil {
+		return req, nil
+	}
 
 	contentType := r.client.contentConfig.ContentType
 	acceptType := r.client.contentConfig.AcceptContentTypes
@@ -80,31 +281,30 @@ func (r *Request) Do() (*http.Response, error) {
 		req.Header.Set("Accept", acceptType)
 	}
 
-	if err != nil {
-		return nil, err
-	}
-	return r.client.Do(req)
+	return req, nil
+}
 
+// setHeaders sets the request headers.
+func (r *Request) setHeaders(req *http.Request) {
+	for k, v := range r.headers {
+		req.Header[k] = v
+	}
 }
 
 func (r *Request) makeQueryStrings() string {
 	q := ""
-	first := true
+	first := !strings.Contains(r.url, "?")
 	for k, v := range r.selectors {
 		if first {
-			q += fmt.Sprintf("?%s=%s", k, v)
+			q += fmt.Sprintf("?%s=%s", k, url.QueryEscape(v))
 			first = false
 		} else {
-			q += fmt.Sprintf("&%s=%s", k, v)
+			q += fmt.Sprintf("&%s=%s", k, url.QueryEscape(v))
 		}
 	}
 	return q
 }
 
-func (r *Request) makePostBody() (io.Reader, error) {
-	postBody, err := json.Marshal(r.body)
-	if err != nil {
-		return nil, err
-	}
-	return bytes.NewBuffer(postBody), nil
+func (r *Request) makePostBody() ([]byte, error) {
+	return json.Marshal(r.body)
 }
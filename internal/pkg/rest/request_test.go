@@ -1,7 +1,12 @@
 package rest
 
 import (
+	"bytes"
 	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -54,3 +59,73 @@ func TestNewRequest(t *testing.T) {
 	}
 
 }
+
+func TestRequest_Path(t *testing.T) {
+	client, err := NewClient(&ClientConfig{Host: "http://registry.test", APIPath: "v2"})
+	assert.NoError(t, err)
+
+	r := client.Verb("GET").Path("library/alpine", "tags", "list").SetHeader("Accept", "application/json")
+	assert.Equal(t, "http://registry.test/v2/library/alpine/tags/list", r.url)
+	assert.Equal(t, "application/json", r.headers.Get("Accept"))
+
+	// The ping endpoint keeps its trailing slash.
+	r = client.Verb("GET").Path("")
+	assert.Equal(t, "http://registry.test/v2/", r.url)
+}
+
+func TestRequest_Location(t *testing.T) {
+	client, err := NewClient(&ClientConfig{Host: "http://registry.test", APIPath: "v2"})
+	assert.NoError(t, err)
+
+	r := client.Verb("PUT").Location("/v2/alpine/blobs/uploads/1234?_state=abc").
+		Selectors(map[string]string{"digest": "sha256:0123"})
+	assert.Equal(t, "http://registry.test/v2/alpine/blobs/uploads/1234?_state=abc&digest=sha256%3A0123",
+		r.url+r.makeQueryStrings())
+
+	r = client.Verb("PUT").Location("https://storage.test/upload/1234")
+	assert.Equal(t, "https://storage.test/upload/1234", r.url)
+}
+
+func TestRequest_RawBody(t *testing.T) {
+	unauthorized := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, _, ok := r.BasicAuth(); !ok {
+			unauthorized++
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		assert.Equal(t, "blob content", string(body))
+		assert.Equal(t, int64(len("blob content")), r.ContentLength)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	client, err := NewClient(&ClientConfig{Host: server.URL, APIPath: "v2", Username: "alice", Password: "secret"})
+	assert.NoError(t, err)
+
+	// The body is rewound when the request is sent again with credentials.
+	resp, err := client.Verb("PUT").RawBody(bytes.NewReader([]byte("blob content")), 12).Do()
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, 1, unauthorized)
+
+	// The following requests are authorized up front, so a stream can be sent.
+	resp, err = client.Verb("PUT").RawBody(strings.NewReader("blob content"), 12).Do()
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, 1, unauthorized)
+
+	// A stream sent before any challenge is known is replayed.
+	client, err = NewClient(&ClientConfig{Host: server.URL, APIPath: "v2", Username: "alice", Password: "secret"})
+	assert.NoError(t, err)
+	resp, err = client.Verb("PUT").RawBody(io.LimitReader(strings.NewReader("blob content"), 12), 12).Do()
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusCreated, resp.StatusCode)
+	assert.Equal(t, 2, unauthorized)
+}
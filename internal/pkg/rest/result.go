@@ -7,11 +7,24 @@ import (
 	"net/http"
 )
 
+// CheckResponse returns a *RegistryError if the response status tells the request has failed.
+// The error envelope is read from the response body.
+func CheckResponse(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+	return newRegistryError(resp)
+}
+
 // DecodeResponse decodes response body into a map.
 func DecodeResponse(resp *http.Response) (map[string]interface{}, error) {
+	if err := CheckResponse(resp); err != nil {
+		return nil, err
+	}
+
 	data, err := io.ReadAll(resp.Body)
 	if err != nil {
-		errors.Wrap(err, "decoding response failed")
+		return nil, errors.Wrap(err, "decoding response failed")
 	}
 
 	var m map[string]interface{}
@@ -22,3 +35,15 @@ func DecodeResponse(resp *http.Response) (map[string]interface{}, error) {
 
 	return m, nil
 }
+
+// DecodeResponseInto decodes response body into the given value.
+func DecodeResponseInto(resp *http.Response, v interface{}) error {
+	if err := CheckResponse(resp); err != nil {
+		return err
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return errors.Wrap(err, "decoding response failed")
+	}
+	return nil
+}
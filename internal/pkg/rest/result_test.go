@@ -0,0 +1,90 @@
+package rest
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCheckResponse(t *testing.T) {
+	cases := []struct {
+		name   string
+		status int
+		err    string
+	}{
+		{
+			name:   "ok",
+			status: http.StatusOK,
+		},
+		{
+			name:   "unauthorized",
+			status: http.StatusUnauthorized,
+			err:    "unauthorized to access http://registry.test/v2/, please check the user and password of the context",
+		},
+		{
+			name:   "forbidden",
+			status: http.StatusForbidden,
+			err:    "access to http://registry.test/v2/ is forbidden, the user of the context lacks permission",
+		},
+		{
+			name:   "not found",
+			status: http.StatusNotFound,
+			err:    "request to http://registry.test/v2/ failed, server responded 404 Not Found",
+		},
+	}
+
+	u, _ := url.Parse("http://registry.test/v2/")
+	for _, c := range cases {
+		resp := &http.Response{
+			StatusCode: c.status,
+			Request:    &http.Request{URL: u},
+		}
+		resp.Status = fmt.Sprintf("%d %s", c.status, http.StatusText(c.status))
+
+		err := CheckResponse(resp)
+		if len(c.err) == 0 {
+			assert.NoError(t, err, c.name)
+			continue
+		}
+		assert.EqualError(t, err, c.err, c.name)
+	}
+}
+
+func TestCheckResponse_ErrorEnvelope(t *testing.T) {
+	u, _ := url.Parse("http://registry.test/v2/hello-world/manifests/latest")
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Status:     "404 Not Found",
+		Request:    &http.Request{URL: u},
+		Body: io.NopCloser(strings.NewReader(
+			`{"errors":[{"code":"MANIFEST_UNKNOWN","message":"manifest unknown","detail":{"Tag":"latest"}}]}`)),
+	}
+
+	err := CheckResponse(resp)
+	assert.EqualError(t, err,
+		"request to http://registry.test/v2/hello-world/manifests/latest failed, "+
+			"server responded 404 Not Found (MANIFEST_UNKNOWN: manifest unknown)")
+
+	var e *RegistryError
+	assert.True(t, errors.As(errors.Wrap(err, "wrapped"), &e))
+	assert.Equal(t, http.StatusNotFound, e.StatusCode)
+	assert.Equal(t, ErrorCodeManifestUnknown, e.Code)
+	assert.Equal(t, "manifest unknown", e.Message)
+	assert.JSONEq(t, `{"Tag":"latest"}`, string(e.Detail))
+
+	assert.True(t, HasErrorCode(errors.Wrap(err, "wrapped"), ErrorCodeManifestUnknown))
+	assert.False(t, HasErrorCode(err, ErrorCodeUnsupported))
+	assert.True(t, HasStatus(err, http.StatusNotFound))
+	assert.False(t, HasStatus(errors.New("other"), http.StatusNotFound))
+
+	// A body which is not an error envelope is ignored.
+	resp.Body = io.NopCloser(strings.NewReader("<html>oops</html>"))
+	err = CheckResponse(resp)
+	assert.True(t, HasStatus(err, http.StatusNotFound))
+	assert.False(t, HasErrorCode(err, ErrorCodeManifestUnknown))
+}